@@ -12,3 +12,6 @@ const GENESIS_SLOT Slot = 0
 const GENESIS_EPOCH Epoch = 0
 
 const JUSTIFICATION_BITS_LENGTH = 4
+
+// INTERVALS_PER_SLOT is the number of intervals the forkchoice divides a slot in.
+const INTERVALS_PER_SLOT = 3
@@ -0,0 +1,42 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// DataAvailabilityChecker implements the is_data_available check of blocks that commit to blobs.
+// It is optional: when attached to the Spec, the Deneb block processing checks the availability of the blobs,
+// before the block is accepted. Use a copy of the Spec to use a different checker for a specific transition.
+type DataAvailabilityChecker interface {
+	// IsDataAvailable checks that the blobs of the block with the given root are available,
+	// and match the given KZG commitments.
+	// A *DataUnavailableError is returned if the data is not available (yet),
+	// any other error means the data is invalid or could not be checked.
+	IsDataAvailable(ctx context.Context, blockRoot Root, commitments []KZGCommitment) error
+}
+
+// DataUnavailableError signals that the blob data of a block is not available (yet).
+// Unlike other block processing errors, the block may still be valid: it may be retried once the blobs arrive.
+type DataUnavailableError struct {
+	BlockRoot Root
+	Err       error
+}
+
+func (e *DataUnavailableError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("data of block %s is unavailable", e.BlockRoot)
+	}
+	return fmt.Sprintf("data of block %s is unavailable: %v", e.BlockRoot, e.Err)
+}
+
+func (e *DataUnavailableError) Unwrap() error {
+	return e.Err
+}
+
+// IsDataUnavailable checks if the error, or any error it wraps, is a *DataUnavailableError.
+func IsDataUnavailable(err error) bool {
+	var target *DataUnavailableError
+	return errors.As(err, &target)
+}
@@ -0,0 +1,72 @@
+package common
+
+// ScheduledFork describes a fork in the fork schedule: its version and activation epoch, as configured in the Spec.
+type ScheduledFork struct {
+	Name string
+	// Version returns the fork version, as configured in the spec.
+	Version func(spec *Spec) Version
+	// Epoch returns the activation epoch, as configured in the spec.
+	Epoch func(spec *Spec) Epoch
+}
+
+var (
+	Phase0Fork = &ScheduledFork{
+		Name:    "phase0",
+		Version: func(spec *Spec) Version { return spec.GENESIS_FORK_VERSION },
+		Epoch:   func(spec *Spec) Epoch { return GENESIS_EPOCH },
+	}
+	AltairFork = &ScheduledFork{
+		Name:    "altair",
+		Version: func(spec *Spec) Version { return spec.ALTAIR_FORK_VERSION },
+		Epoch:   func(spec *Spec) Epoch { return spec.ALTAIR_FORK_EPOCH },
+	}
+	BellatrixFork = &ScheduledFork{
+		Name:    "bellatrix",
+		Version: func(spec *Spec) Version { return spec.BELLATRIX_FORK_VERSION },
+		Epoch:   func(spec *Spec) Epoch { return spec.BELLATRIX_FORK_EPOCH },
+	}
+	CapellaFork = &ScheduledFork{
+		Name:    "capella",
+		Version: func(spec *Spec) Version { return spec.CAPELLA_FORK_VERSION },
+		Epoch:   func(spec *Spec) Epoch { return spec.CAPELLA_FORK_EPOCH },
+	}
+	DenebFork = &ScheduledFork{
+		Name:    "deneb",
+		Version: func(spec *Spec) Version { return spec.DENEB_FORK_VERSION },
+		Epoch:   func(spec *Spec) Epoch { return spec.DENEB_FORK_EPOCH },
+	}
+)
+
+// ForkSchedule lists the forks in activation order, starting with the genesis fork.
+// The beacon package registers the fork-specific types and functions of each of these forks, in the same order.
+var ForkSchedule = []*ScheduledFork{
+	Phase0Fork,
+	AltairFork,
+	BellatrixFork,
+	CapellaFork,
+	DenebFork,
+}
+
+// ForkIndexAtEpoch returns the index in the ForkSchedule of the fork that is active at the given epoch.
+// Forks scheduled before the fork preceding them are ignored,
+// to ignore forks that are missing (zeroed) in older configs.
+func (spec *Spec) ForkIndexAtEpoch(epoch Epoch) int {
+	out := 0
+	prev := ForkSchedule[0].Epoch(spec)
+	for i := 1; i < len(ForkSchedule); i++ {
+		forkEpoch := ForkSchedule[i].Epoch(spec)
+		if forkEpoch < prev {
+			continue
+		}
+		if forkEpoch > epoch {
+			break
+		}
+		out, prev = i, forkEpoch
+	}
+	return out
+}
+
+// ForkVersion returns the version of the fork that is active at the given slot.
+func (spec *Spec) ForkVersion(slot Slot) Version {
+	return ForkSchedule[spec.ForkIndexAtEpoch(spec.SlotToEpoch(slot))].Version(spec)
+}
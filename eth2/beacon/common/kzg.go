@@ -81,3 +81,65 @@ func (p KZGCommitment) ToVersionedHash() (out Hash32) {
 	out[0] = VERSIONED_HASH_VERSION_KZG
 	return out
 }
+
+const KZGProofSize = 48
+
+type KZGProof [KZGProofSize]byte
+
+var KZGProofType = view.BasicVectorType(view.ByteType, KZGProofSize)
+
+func (p *KZGProof) Deserialize(dr *codec.DecodingReader) error {
+	if p == nil {
+		return errors.New("nil kzg proof")
+	}
+	_, err := dr.Read(p[:])
+	return err
+}
+
+func (p *KZGProof) Serialize(w *codec.EncodingWriter) error {
+	return w.Write(p[:])
+}
+
+func (KZGProof) ByteLength() uint64 {
+	return KZGProofSize
+}
+
+func (KZGProof) FixedLength() uint64 {
+	return KZGProofSize
+}
+
+func (p KZGProof) HashTreeRoot(hFn tree.HashFn) tree.Root {
+	var a, b tree.Root
+	copy(a[:], p[0:32])
+	copy(b[:], p[32:48])
+	return hFn(a, b)
+}
+
+func (p KZGProof) MarshalText() ([]byte, error) {
+	return []byte("0x" + hex.EncodeToString(p[:])), nil
+}
+
+func (p KZGProof) String() string {
+	return "0x" + hex.EncodeToString(p[:])
+}
+
+func (p *KZGProof) UnmarshalText(text []byte) error {
+	if p == nil {
+		return errors.New("cannot decode into nil KZGProof")
+	}
+	if len(text) >= 2 && text[0] == '0' && (text[1] == 'x' || text[1] == 'X') {
+		text = text[2:]
+	}
+	if len(text) != 2*KZGProofSize {
+		return fmt.Errorf("unexpected length string '%s'", string(text))
+	}
+	_, err := hex.Decode(p[:], text)
+	return err
+}
+
+// KZGVerifier verifies blobs against their KZG commitments and proofs.
+// See the kzg package for an implementation backed by a trusted setup.
+type KZGVerifier interface {
+	VerifyBlobKZGProof(blob []byte, commitment KZGCommitment, proof KZGProof) (bool, error)
+	VerifyBlobKZGProofBatch(blobs [][]byte, commitments []KZGCommitment, proofs []KZGProof) (bool, error)
+}
@@ -37,6 +37,7 @@ var DOMAIN_BLS_TO_EXECUTION_CHANGE = BLSDomainType{0x0A, 0x00, 0x00, 0x00}
 // Deneb
 const BLOB_TX_TYPE = 0x03
 const VERSIONED_HASH_VERSION_KZG = 0x01
+const BYTES_PER_FIELD_ELEMENT = 32
 
 type Phase0Preset struct {
 	// Misc.
@@ -300,22 +301,15 @@ type Spec struct {
 	Config          `json:",inline" yaml:",inline"`
 
 	ExecutionEngine `json:"-" yaml:"-"`
+
+	// KZG verifies blob data, loaded from a trusted setup. Optional, only required for Deneb blob verification.
+	KZG KZGVerifier `json:"-" yaml:"-"`
+
+	// DataAvailability checks if the blobs of a block are available. Optional, not checked if nil.
+	DataAvailability DataAvailabilityChecker `json:"-" yaml:"-"`
 }
 
 // Wraps the object to parametrize with given spec. JSON and YAML functionality is proxied to the inner value.
 func (spec *Spec) Wrap(des SpecObj) SSZObj {
 	return &specObj{spec, des}
 }
-
-func (spec *Spec) ForkVersion(slot Slot) Version {
-	epoch := spec.SlotToEpoch(slot)
-	if epoch < spec.ALTAIR_FORK_EPOCH {
-		return spec.GENESIS_FORK_VERSION
-	} else if epoch < spec.BELLATRIX_FORK_EPOCH {
-		return spec.ALTAIR_FORK_VERSION
-	} else if epoch < spec.CAPELLA_FORK_EPOCH {
-		return spec.BELLATRIX_FORK_VERSION
-	} else {
-		return spec.CAPELLA_FORK_VERSION
-	}
-}
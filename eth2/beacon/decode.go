@@ -0,0 +1,79 @@
+package beacon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/protolambda/ztyp/codec"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+// Offset of fork.current_version in the BeaconState, after genesis_time, genesis_validators_root, slot and fork.previous_version.
+// This is the same for all forks.
+const stateCurrentVersionOffset = 8 + 32 + 8 + 4
+
+// Offset of message.slot in a SignedBeaconBlock: after the 4 byte offset of the message and the 96 byte signature.
+// This is the same for all forks.
+const signedBlockSlotOffset = 4 + 96
+
+// DecodeState decodes a beacon state of any registered fork,
+// the fork is detected from the fork.current_version of the state.
+func DecodeState(spec *common.Spec, data []byte) (common.BeaconState, *Fork, error) {
+	return DecodeStateReader(spec, bytes.NewReader(data), uint64(len(data)))
+}
+
+// DecodeStateReader decodes a beacon state of the given byte length from the reader, like DecodeState,
+// without buffering the full state.
+func DecodeStateReader(spec *common.Spec, r io.Reader, length uint64) (common.BeaconState, *Fork, error) {
+	var header [stateCurrentVersionOffset + 4]byte
+	if length < uint64(len(header)) {
+		return nil, nil, fmt.Errorf("state is too short: %d bytes", length)
+	}
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, nil, fmt.Errorf("failed to read state fork version: %w", err)
+	}
+	var version common.Version
+	copy(version[:], header[stateCurrentVersionOffset:])
+	fork, ok := ForkByVersion(spec, version)
+	if !ok {
+		return nil, nil, fmt.Errorf("unrecognized state fork version: %s", version)
+	}
+	dr := codec.NewDecodingReader(io.MultiReader(bytes.NewReader(header[:]), r), length)
+	state, err := fork.DecodeState(spec, dr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode %s state: %w", fork.Name, err)
+	}
+	return state, fork, nil
+}
+
+// DecodeSignedBlock decodes a signed beacon block of any registered fork,
+// the fork is detected from the slot of the block.
+func DecodeSignedBlock(spec *common.Spec, data []byte) (OpaqueBlock, *Fork, error) {
+	return DecodeSignedBlockReader(spec, bytes.NewReader(data), uint64(len(data)))
+}
+
+// DecodeSignedBlockReader decodes a signed beacon block of the given byte length from the reader, like DecodeSignedBlock,
+// without buffering the full block.
+func DecodeSignedBlockReader(spec *common.Spec, r io.Reader, length uint64) (OpaqueBlock, *Fork, error) {
+	var header [signedBlockSlotOffset + 8]byte
+	if length < uint64(len(header)) {
+		return nil, nil, fmt.Errorf("signed block is too short: %d bytes", length)
+	}
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, nil, fmt.Errorf("failed to read block slot: %w", err)
+	}
+	if offset := binary.LittleEndian.Uint32(header[:4]); offset != signedBlockSlotOffset {
+		return nil, nil, fmt.Errorf("unexpected signed block message offset: %d", offset)
+	}
+	slot := common.Slot(binary.LittleEndian.Uint64(header[signedBlockSlotOffset:]))
+	fork := ForkAtEpoch(spec, spec.SlotToEpoch(slot))
+	block := fork.NewBlock()
+	dr := codec.NewDecodingReader(io.MultiReader(bytes.NewReader(header[:]), r), length)
+	if err := block.Deserialize(spec, dr); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode %s block: %w", fork.Name, err)
+	}
+	return block, fork, nil
+}
@@ -0,0 +1,77 @@
+package beacon
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/protolambda/ztyp/codec"
+	"github.com/protolambda/ztyp/tree"
+
+	"github.com/protolambda/zrnt/eth2/beacon/altair"
+	"github.com/protolambda/zrnt/eth2/beacon/capella"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
+	"github.com/protolambda/zrnt/eth2/configs"
+)
+
+func TestDecodeState(t *testing.T) {
+	spec := configs.Minimal
+	for _, f := range Forks {
+		state := f.NewState(spec)
+		if err := state.SetFork(common.Fork{CurrentVersion: f.Version(spec)}); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := state.Serialize(codec.NewEncodingWriter(&buf)); err != nil {
+			t.Fatal(err)
+		}
+		out, fork, err := DecodeState(spec, buf.Bytes())
+		if err != nil {
+			t.Fatalf("%s: %v", f.Name, err)
+		}
+		if fork != f || !f.IsState(out) {
+			t.Fatalf("expected %s state, got %s state %T", f.Name, fork.Name, out)
+		}
+		if out.HashTreeRoot(tree.GetHashFn()) != state.HashTreeRoot(tree.GetHashFn()) {
+			t.Fatalf("%s: decoded state root does not match", f.Name)
+		}
+	}
+}
+
+func TestDecodeSignedBlock(t *testing.T) {
+	spec := *configs.Minimal
+	spec.ALTAIR_FORK_EPOCH = 0
+	spec.BELLATRIX_FORK_EPOCH = 0
+	spec.CAPELLA_FORK_EPOCH = 1
+	spec.DENEB_FORK_EPOCH = 2
+	for slot, expected := range map[common.Slot]*Fork{
+		spec.SLOTS_PER_EPOCH:     Capella,
+		spec.SLOTS_PER_EPOCH * 2: Deneb,
+	} {
+		bits := make(altair.SyncCommitteeBits, spec.SYNC_COMMITTEE_SIZE/8)
+		var block common.SpecObj
+		if expected == Deneb {
+			b := &deneb.SignedBeaconBlock{Message: deneb.BeaconBlock{Slot: slot}}
+			b.Message.Body.SyncAggregate.SyncCommitteeBits = bits
+			block = b
+		} else {
+			b := &capella.SignedBeaconBlock{Message: capella.BeaconBlock{Slot: slot}}
+			b.Message.Body.SyncAggregate.SyncCommitteeBits = bits
+			block = b
+		}
+		var buf bytes.Buffer
+		if err := block.Serialize(&spec, codec.NewEncodingWriter(&buf)); err != nil {
+			t.Fatal(err)
+		}
+		out, fork, err := DecodeSignedBlockReader(&spec, bytes.NewReader(buf.Bytes()), uint64(buf.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fork != expected {
+			t.Fatalf("slot %d: expected %s block, got %s", slot, expected.Name, fork.Name)
+		}
+		if out.HashTreeRoot(&spec, tree.GetHashFn()) != block.HashTreeRoot(&spec, tree.GetHashFn()) {
+			t.Fatalf("slot %d: decoded block root does not match", slot)
+		}
+	}
+}
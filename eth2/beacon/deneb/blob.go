@@ -0,0 +1,363 @@
+package deneb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/protolambda/ztyp/codec"
+	"github.com/protolambda/ztyp/conv"
+	"github.com/protolambda/ztyp/tree"
+	. "github.com/protolambda/ztyp/view"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/util/merkle"
+)
+
+func BlobType(spec *common.Spec) *BasicVectorTypeDef {
+	return BasicVectorType(ByteType, uint64(spec.FIELD_ELEMENTS_PER_BLOB)*common.BYTES_PER_FIELD_ELEMENT)
+}
+
+type Blob []byte
+
+func blobByteLength(spec *common.Spec) uint64 {
+	return uint64(spec.FIELD_ELEMENTS_PER_BLOB) * common.BYTES_PER_FIELD_ELEMENT
+}
+
+func (b *Blob) Deserialize(spec *common.Spec, dr *codec.DecodingReader) error {
+	byteLen := blobByteLength(spec)
+	if uint64(cap(*b)) < byteLen {
+		*b = make(Blob, byteLen)
+	} else {
+		*b = (*b)[:byteLen]
+	}
+	_, err := dr.Read(*b)
+	return err
+}
+
+func (b Blob) Serialize(spec *common.Spec, w *codec.EncodingWriter) error {
+	if x := uint64(len(b)); x != blobByteLength(spec) {
+		return fmt.Errorf("unexpected blob length: %d", x)
+	}
+	return w.Write(b)
+}
+
+func (b Blob) ByteLength(spec *common.Spec) uint64 {
+	return blobByteLength(spec)
+}
+
+func (b *Blob) FixedLength(spec *common.Spec) uint64 {
+	return blobByteLength(spec)
+}
+
+func (b Blob) HashTreeRoot(spec *common.Spec, hFn tree.HashFn) common.Root {
+	return hFn.ByteVectorHTR(b)
+}
+
+func (b Blob) MarshalText() ([]byte, error) {
+	return conv.BytesMarshalText(b[:])
+}
+
+func (b *Blob) UnmarshalText(text []byte) error {
+	if b == nil {
+		return errors.New("cannot decode into nil blob")
+	}
+	return conv.DynamicBytesUnmarshalText((*[]byte)(b), text[:])
+}
+
+const BlobIndexType = Uint64Type
+
+type BlobIndex Uint64View
+
+func AsBlobIndex(v View, err error) (BlobIndex, error) {
+	i, err := AsUint64(v, err)
+	return BlobIndex(i), err
+}
+
+func (i *BlobIndex) Deserialize(dr *codec.DecodingReader) error {
+	return (*Uint64View)(i).Deserialize(dr)
+}
+
+func (i BlobIndex) Serialize(w *codec.EncodingWriter) error {
+	return w.WriteUint64(uint64(i))
+}
+
+func (BlobIndex) ByteLength() uint64 {
+	return 8
+}
+
+func (BlobIndex) FixedLength() uint64 {
+	return 8
+}
+
+func (i BlobIndex) HashTreeRoot(hFn tree.HashFn) common.Root {
+	return Uint64View(i).HashTreeRoot(hFn)
+}
+
+func (i BlobIndex) MarshalJSON() ([]byte, error) {
+	return Uint64View(i).MarshalJSON()
+}
+
+func (i *BlobIndex) UnmarshalJSON(b []byte) error {
+	return ((*Uint64View)(i)).UnmarshalJSON(b)
+}
+
+func (i BlobIndex) String() string {
+	return Uint64View(i).String()
+}
+
+func KZGCommitmentInclusionProofType(spec *common.Spec) *ComplexVectorTypeDef {
+	return ComplexVectorType(RootType, uint64(spec.KZG_COMMITMENT_INCLUSION_PROOF_DEPTH))
+}
+
+type KZGCommitmentInclusionProof []common.Root
+
+func (p *KZGCommitmentInclusionProof) Deserialize(spec *common.Spec, dr *codec.DecodingReader) error {
+	return tree.ReadRoots(dr, (*[]common.Root)(p), uint64(spec.KZG_COMMITMENT_INCLUSION_PROOF_DEPTH))
+}
+
+func (p KZGCommitmentInclusionProof) Serialize(spec *common.Spec, w *codec.EncodingWriter) error {
+	if x := uint64(len(p)); x != uint64(spec.KZG_COMMITMENT_INCLUSION_PROOF_DEPTH) {
+		return fmt.Errorf("unexpected kzg commitment inclusion proof length: %d", x)
+	}
+	return tree.WriteRoots(w, p)
+}
+
+func (p KZGCommitmentInclusionProof) ByteLength(spec *common.Spec) uint64 {
+	return uint64(spec.KZG_COMMITMENT_INCLUSION_PROOF_DEPTH) * 32
+}
+
+func (p *KZGCommitmentInclusionProof) FixedLength(spec *common.Spec) uint64 {
+	return uint64(spec.KZG_COMMITMENT_INCLUSION_PROOF_DEPTH) * 32
+}
+
+func (p KZGCommitmentInclusionProof) HashTreeRoot(spec *common.Spec, hFn tree.HashFn) common.Root {
+	length := uint64(len(p))
+	return hFn.ComplexVectorHTR(func(i uint64) tree.HTR {
+		if i < length {
+			return &p[i]
+		}
+		return nil
+	}, uint64(spec.KZG_COMMITMENT_INCLUSION_PROOF_DEPTH))
+}
+
+func BlobSidecarType(spec *common.Spec) *ContainerTypeDef {
+	return ContainerType("BlobSidecar", []FieldDef{
+		{"index", BlobIndexType},
+		{"blob", BlobType(spec)},
+		{"kzg_commitment", common.KZGCommitmentType},
+		{"kzg_proof", common.KZGProofType},
+		{"signed_block_header", common.SignedBeaconBlockHeaderType},
+		{"kzg_commitment_inclusion_proof", KZGCommitmentInclusionProofType(spec)},
+	})
+}
+
+type BlobSidecar struct {
+	Index                       BlobIndex                      `json:"index" yaml:"index"`
+	Blob                        Blob                           `json:"blob" yaml:"blob"`
+	KZGCommitment               common.KZGCommitment           `json:"kzg_commitment" yaml:"kzg_commitment"`
+	KZGProof                    common.KZGProof                `json:"kzg_proof" yaml:"kzg_proof"`
+	SignedBlockHeader           common.SignedBeaconBlockHeader `json:"signed_block_header" yaml:"signed_block_header"`
+	KZGCommitmentInclusionProof KZGCommitmentInclusionProof    `json:"kzg_commitment_inclusion_proof" yaml:"kzg_commitment_inclusion_proof"`
+}
+
+func (sc *BlobSidecar) Deserialize(spec *common.Spec, dr *codec.DecodingReader) error {
+	return dr.FixedLenContainer(&sc.Index, spec.Wrap(&sc.Blob), &sc.KZGCommitment, &sc.KZGProof,
+		&sc.SignedBlockHeader, spec.Wrap(&sc.KZGCommitmentInclusionProof))
+}
+
+func (sc *BlobSidecar) Serialize(spec *common.Spec, w *codec.EncodingWriter) error {
+	return w.FixedLenContainer(&sc.Index, spec.Wrap(&sc.Blob), &sc.KZGCommitment, &sc.KZGProof,
+		&sc.SignedBlockHeader, spec.Wrap(&sc.KZGCommitmentInclusionProof))
+}
+
+func (sc *BlobSidecar) ByteLength(spec *common.Spec) uint64 {
+	return codec.ContainerLength(&sc.Index, spec.Wrap(&sc.Blob), &sc.KZGCommitment, &sc.KZGProof,
+		&sc.SignedBlockHeader, spec.Wrap(&sc.KZGCommitmentInclusionProof))
+}
+
+func (sc *BlobSidecar) FixedLength(spec *common.Spec) uint64 {
+	return codec.ContainerLength(&sc.Index, spec.Wrap(&sc.Blob), &sc.KZGCommitment, &sc.KZGProof,
+		&sc.SignedBlockHeader, spec.Wrap(&sc.KZGCommitmentInclusionProof))
+}
+
+func (sc *BlobSidecar) HashTreeRoot(spec *common.Spec, hFn tree.HashFn) common.Root {
+	return hFn.HashTreeRoot(&sc.Index, spec.Wrap(&sc.Blob), &sc.KZGCommitment, &sc.KZGProof,
+		&sc.SignedBlockHeader, spec.Wrap(&sc.KZGCommitmentInclusionProof))
+}
+
+// Identifier returns the (block_root, index) pair that identifies the sidecar in req-resp and storage.
+func (sc *BlobSidecar) Identifier() BlobIdentifier {
+	return BlobIdentifier{
+		BlockRoot: sc.SignedBlockHeader.Message.HashTreeRoot(tree.GetHashFn()),
+		Index:     sc.Index,
+	}
+}
+
+var BlobIdentifierType = ContainerType("BlobIdentifier", []FieldDef{
+	{"block_root", RootType},
+	{"index", BlobIndexType},
+})
+
+type BlobIdentifier struct {
+	BlockRoot common.Root `json:"block_root" yaml:"block_root"`
+	Index     BlobIndex   `json:"index" yaml:"index"`
+}
+
+func (b *BlobIdentifier) Deserialize(dr *codec.DecodingReader) error {
+	return dr.FixedLenContainer(&b.BlockRoot, &b.Index)
+}
+
+func (b *BlobIdentifier) Serialize(w *codec.EncodingWriter) error {
+	return w.FixedLenContainer(&b.BlockRoot, &b.Index)
+}
+
+func (b *BlobIdentifier) ByteLength() uint64 {
+	return 32 + 8
+}
+
+func (b *BlobIdentifier) FixedLength() uint64 {
+	return 32 + 8
+}
+
+func (b *BlobIdentifier) HashTreeRoot(hFn tree.HashFn) common.Root {
+	return hFn.HashTreeRoot(&b.BlockRoot, &b.Index)
+}
+
+func (b *BlobIdentifier) String() string {
+	return fmt.Sprintf("%s:%d", b.BlockRoot, b.Index)
+}
+
+// The BeaconBlockBody has 12 fields
+// This is padded to 16, a depth of 4 bits
+const blockBodyProofLen = 4
+
+// Index of the blob_kzg_commitments field in the BeaconBlockBody
+const _bodyBlobKZGCommitments = 11
+
+func (b *BeaconBlockBody) fieldRoots(spec *common.Spec, hFn tree.HashFn) []common.Root {
+	return []common.Root{
+		b.RandaoReveal.HashTreeRoot(hFn),
+		b.Eth1Data.HashTreeRoot(hFn),
+		b.Graffiti,
+		spec.Wrap(&b.ProposerSlashings).HashTreeRoot(hFn),
+		spec.Wrap(&b.AttesterSlashings).HashTreeRoot(hFn),
+		spec.Wrap(&b.Attestations).HashTreeRoot(hFn),
+		spec.Wrap(&b.Deposits).HashTreeRoot(hFn),
+		spec.Wrap(&b.VoluntaryExits).HashTreeRoot(hFn),
+		spec.Wrap(&b.SyncAggregate).HashTreeRoot(hFn),
+		spec.Wrap(&b.ExecutionPayload).HashTreeRoot(hFn),
+		spec.Wrap(&b.BLSToExecutionChanges).HashTreeRoot(hFn),
+		spec.Wrap(&b.BlobKZGCommitments).HashTreeRoot(hFn),
+	}
+}
+
+// merkleBranch computes the branch of the leaf at the given index,
+// in a tree of the given depth that is padded with zero-hashes beyond the given leaves.
+func merkleBranch(hFn tree.HashFn, leaves []common.Root, depth uint64, index uint64) []common.Root {
+	branch := make([]common.Root, 0, depth)
+	layer := leaves
+	for d := uint64(0); d < depth; d++ {
+		if sibling := index ^ 1; sibling < uint64(len(layer)) {
+			branch = append(branch, layer[sibling])
+		} else {
+			branch = append(branch, tree.ZeroHashes[d])
+		}
+		next := make([]common.Root, (len(layer)+1)/2)
+		for i := range next {
+			if 2*i+1 < len(layer) {
+				next[i] = hFn(layer[2*i], layer[2*i+1])
+			} else {
+				next[i] = hFn(layer[2*i], tree.ZeroHashes[d])
+			}
+		}
+		layer = next
+		index >>= 1
+	}
+	return branch
+}
+
+func kzgCommitmentsProofLen(spec *common.Spec) uint64 {
+	return uint64(tree.CoverDepth(uint64(spec.MAX_BLOB_COMMITMENTS_PER_BLOCK)))
+}
+
+// kzgCommitmentSubtreeIndex is the generalized index of the commitment,
+// without the leading bit, relative to the BeaconBlockBody root.
+func kzgCommitmentSubtreeIndex(spec *common.Spec, index BlobIndex) uint64 {
+	return ((_bodyBlobKZGCommitments << 1) << kzgCommitmentsProofLen(spec)) | uint64(index)
+}
+
+// KZGCommitmentInclusionProof computes the merkle proof of the commitment at the given index,
+// against the hash-tree-root of the block body.
+func (b *BeaconBlockBody) KZGCommitmentInclusionProof(spec *common.Spec, index BlobIndex) (KZGCommitmentInclusionProof, error) {
+	count := uint64(len(b.BlobKZGCommitments))
+	if uint64(index) >= count {
+		return nil, fmt.Errorf("blob index %d out of range, block has %d commitments", index, count)
+	}
+	listDepth := kzgCommitmentsProofLen(spec)
+	if depth := blockBodyProofLen + 1 + listDepth; depth != uint64(spec.KZG_COMMITMENT_INCLUSION_PROOF_DEPTH) {
+		return nil, fmt.Errorf("configured kzg commitment inclusion proof depth %d does not match body depth %d",
+			spec.KZG_COMMITMENT_INCLUSION_PROOF_DEPTH, depth)
+	}
+	hFn := tree.GetHashFn()
+	leaves := make([]common.Root, count)
+	for i := range b.BlobKZGCommitments {
+		leaves[i] = b.BlobKZGCommitments[i].HashTreeRoot(hFn)
+	}
+	proof := make(KZGCommitmentInclusionProof, 0, spec.KZG_COMMITMENT_INCLUSION_PROOF_DEPTH)
+	proof = append(proof, merkleBranch(hFn, leaves, listDepth, uint64(index))...)
+	// the length mix-in of the commitments list
+	var lengthRoot common.Root
+	binary.LittleEndian.PutUint64(lengthRoot[:], count)
+	proof = append(proof, lengthRoot)
+	proof = append(proof, merkleBranch(hFn, b.fieldRoots(spec, hFn), blockBodyProofLen, _bodyBlobKZGCommitments)...)
+	return proof, nil
+}
+
+// VerifyBlobSidecarInclusionProof checks that the KZG commitment of the sidecar is included
+// in the block body, as committed to by the body_root of the signed block header.
+func VerifyBlobSidecarInclusionProof(spec *common.Spec, sidecar *BlobSidecar) bool {
+	depth := uint64(spec.KZG_COMMITMENT_INCLUSION_PROOF_DEPTH)
+	if uint64(len(sidecar.KZGCommitmentInclusionProof)) != depth {
+		return false
+	}
+	if uint64(sidecar.Index) >= uint64(spec.MAX_BLOB_COMMITMENTS_PER_BLOCK) {
+		return false
+	}
+	return merkle.VerifyMerkleBranch(
+		sidecar.KZGCommitment.HashTreeRoot(tree.GetHashFn()),
+		sidecar.KZGCommitmentInclusionProof,
+		depth,
+		kzgCommitmentSubtreeIndex(spec, sidecar.Index),
+		sidecar.SignedBlockHeader.Message.BodyRoot,
+	)
+}
+
+// BlobSidecars creates the sidecars for the blobs of the block, with the commitment inclusion proofs.
+// The blobs and proofs must match the KZG commitments in the block body, in order.
+func (b *SignedBeaconBlock) BlobSidecars(spec *common.Spec, blobs []Blob, proofs []common.KZGProof) ([]*BlobSidecar, error) {
+	commitments := b.Message.Body.BlobKZGCommitments
+	if len(blobs) != len(commitments) {
+		return nil, fmt.Errorf("got %d blobs, but block has %d commitments", len(blobs), len(commitments))
+	}
+	if len(proofs) != len(commitments) {
+		return nil, fmt.Errorf("got %d proofs, but block has %d commitments", len(proofs), len(commitments))
+	}
+	header := b.SignedHeader(spec)
+	out := make([]*BlobSidecar, len(blobs))
+	for i := range blobs {
+		inclusionProof, err := b.Message.Body.KZGCommitmentInclusionProof(spec, BlobIndex(i))
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute inclusion proof of blob %d: %w", i, err)
+		}
+		out[i] = &BlobSidecar{
+			Index:                       BlobIndex(i),
+			Blob:                        blobs[i],
+			KZGCommitment:               commitments[i],
+			KZGProof:                    proofs[i],
+			SignedBlockHeader:           *header,
+			KZGCommitmentInclusionProof: inclusionProof,
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,69 @@
+package deneb
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/protolambda/ztyp/codec"
+	"github.com/protolambda/ztyp/tree"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/configs"
+)
+
+func TestKZGCommitmentInclusionProof(t *testing.T) {
+	for _, spec := range []*common.Spec{configs.Mainnet, configs.Minimal} {
+		var block SignedBeaconBlock
+		block.Message.Slot = 123
+		block.Message.Body.Graffiti = common.Root{0xaa}
+		for i := 0; i < 3; i++ {
+			block.Message.Body.BlobKZGCommitments = append(block.Message.Body.BlobKZGCommitments, common.KZGCommitment{byte(i + 1), 0x42})
+		}
+		bodyRoot := block.Message.Body.HashTreeRoot(spec, tree.GetHashFn())
+		blobs := make([]Blob, 3)
+		for i := range blobs {
+			blobs[i] = make(Blob, blobByteLength(spec))
+		}
+		sidecars, err := block.BlobSidecars(spec, blobs, make([]common.KZGProof, 3))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, sc := range sidecars {
+			if sc.SignedBlockHeader.Message.BodyRoot != bodyRoot {
+				t.Fatalf("sidecar %d has unexpected body root", i)
+			}
+			if !VerifyBlobSidecarInclusionProof(spec, sc) {
+				t.Fatalf("%s: inclusion proof of sidecar %d is invalid", spec.CONFIG_NAME, i)
+			}
+			sc.Index = (sc.Index + 1) % 3
+			if VerifyBlobSidecarInclusionProof(spec, sc) {
+				t.Fatalf("%s: inclusion proof of sidecar %d is valid for wrong index", spec.CONFIG_NAME, i)
+			}
+		}
+	}
+}
+
+func TestBlobSidecarSSZ(t *testing.T) {
+	spec := configs.Minimal
+	sc := BlobSidecar{
+		Index:                       1,
+		Blob:                        make(Blob, blobByteLength(spec)),
+		KZGCommitment:               common.KZGCommitment{0x01},
+		KZGProof:                    common.KZGProof{0x02},
+		KZGCommitmentInclusionProof: make(KZGCommitmentInclusionProof, spec.KZG_COMMITMENT_INCLUSION_PROOF_DEPTH),
+	}
+	sc.Blob[len(sc.Blob)-1] = 0x03
+	sc.SignedBlockHeader.Message.Slot = 123
+	sc.KZGCommitmentInclusionProof[0] = common.Root{0x04}
+	var buf bytes.Buffer
+	if err := sc.Serialize(spec, codec.NewEncodingWriter(&buf)); err != nil {
+		t.Fatal(err)
+	}
+	var out BlobSidecar
+	if err := out.Deserialize(spec, codec.NewDecodingReader(bytes.NewReader(buf.Bytes()), uint64(buf.Len()))); err != nil {
+		t.Fatal(err)
+	}
+	if a, b := sc.HashTreeRoot(spec, tree.GetHashFn()), out.HashTreeRoot(spec, tree.GetHashFn()); a != b {
+		t.Fatalf("sidecar SSZ roundtrip changed root: %s <> %s", a, b)
+	}
+}
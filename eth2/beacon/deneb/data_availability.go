@@ -0,0 +1,66 @@
+package deneb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+// BlobSidecarsGetter retrieves the known blob sidecars of a block.
+type BlobSidecarsGetter interface {
+	// BlobSidecarsByBlockRoot returns the sidecars that are known for the given block root, in any order.
+	// Missing sidecars are not an error, the result may be incomplete or empty.
+	BlobSidecarsByBlockRoot(ctx context.Context, blockRoot common.Root) ([]*BlobSidecar, error)
+}
+
+// BlobDataAvailabilityChecker implements common.DataAvailabilityChecker:
+// it looks up the sidecars of a block, and verifies them against the commitments of the block.
+type BlobDataAvailabilityChecker struct {
+	Spec     *common.Spec
+	Sidecars BlobSidecarsGetter
+}
+
+var _ common.DataAvailabilityChecker = (*BlobDataAvailabilityChecker)(nil)
+
+func NewBlobDataAvailabilityChecker(spec *common.Spec, sidecars BlobSidecarsGetter) *BlobDataAvailabilityChecker {
+	return &BlobDataAvailabilityChecker{Spec: spec, Sidecars: sidecars}
+}
+
+func (d *BlobDataAvailabilityChecker) IsDataAvailable(ctx context.Context, blockRoot common.Root, commitments []common.KZGCommitment) error {
+	if len(commitments) == 0 {
+		return nil
+	}
+	if d.Spec.KZG == nil {
+		return errors.New("no KZG trusted setup available to verify blobs with")
+	}
+	sidecars, err := d.Sidecars.BlobSidecarsByBlockRoot(ctx, blockRoot)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve blob sidecars of block %s: %w", blockRoot, err)
+	}
+	byIndex := make(map[BlobIndex]*BlobSidecar, len(sidecars))
+	for _, sc := range sidecars {
+		// Only consider sidecars that match the block commitments,
+		// the right sidecar may still arrive if a different one is stored.
+		if uint64(sc.Index) < uint64(len(commitments)) && sc.KZGCommitment == commitments[sc.Index] {
+			byIndex[sc.Index] = sc
+		}
+	}
+	blobs := make([][]byte, len(commitments))
+	proofs := make([]common.KZGProof, len(commitments))
+	for i := range commitments {
+		sc, ok := byIndex[BlobIndex(i)]
+		if !ok {
+			return &common.DataUnavailableError{BlockRoot: blockRoot, Err: fmt.Errorf("missing blob sidecar %d of %d", i, len(commitments))}
+		}
+		blobs[i] = sc.Blob
+		proofs[i] = sc.KZGProof
+	}
+	if ok, err := d.Spec.KZG.VerifyBlobKZGProofBatch(blobs, commitments, proofs); err != nil {
+		return fmt.Errorf("failed to verify blob KZG proofs of block %s: %w", blockRoot, err)
+	} else if !ok {
+		return fmt.Errorf("invalid blob KZG proofs for block %s", blockRoot)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package deneb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/zrnt/eth2/kzg"
+)
+
+type testSidecars map[common.Root][]*BlobSidecar
+
+func (s testSidecars) BlobSidecarsByBlockRoot(ctx context.Context, blockRoot common.Root) ([]*BlobSidecar, error) {
+	return s[blockRoot], nil
+}
+
+func TestBlobDataAvailabilityChecker(t *testing.T) {
+	kzgCtx, err := kzg.LoadTrustedSetup("../../configs/yamls/presets/mainnet/trusted_setups/trusted_setup_4096.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := *configs.Mainnet
+	spec.KZG = kzgCtx
+
+	blob := make(Blob, blobByteLength(&spec))
+	blob[31] = 0x42
+	commitment, err := kzgCtx.BlobToKZGCommitment(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := kzgCtx.ComputeBlobKZGProof(blob, commitment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blockRoot := common.Root{0x01}
+	commitments := []common.KZGCommitment{commitment}
+
+	sidecars := testSidecars{}
+	checker := NewBlobDataAvailabilityChecker(&spec, sidecars)
+	if err := checker.IsDataAvailable(context.Background(), blockRoot, nil); err != nil {
+		t.Fatalf("block without blobs must be available: %v", err)
+	}
+	if err := checker.IsDataAvailable(context.Background(), blockRoot, commitments); !common.IsDataUnavailable(err) {
+		t.Fatalf("expected data unavailable error, got: %v", err)
+	}
+	sidecars[blockRoot] = []*BlobSidecar{{Index: 0, Blob: blob, KZGCommitment: commitment, KZGProof: common.KZGProof{0xc0}}}
+	if err := checker.IsDataAvailable(context.Background(), blockRoot, commitments); err == nil || common.IsDataUnavailable(err) {
+		t.Fatalf("expected invalid proof error, got: %v", err)
+	}
+	sidecars[blockRoot][0].KZGProof = proof
+	if err := checker.IsDataAvailable(context.Background(), blockRoot, commitments); err != nil {
+		t.Fatalf("expected data to be available: %v", err)
+	}
+}
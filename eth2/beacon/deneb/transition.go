@@ -85,6 +85,12 @@ func (state *BeaconStateView) ProcessBlock(ctx context.Context, spec *common.Spe
 	if err := capella.ProcessWithdrawals(ctx, spec, state, &body.ExecutionPayload); err != nil {
 		return err
 	}
+	// New in Deneb: [is_data_available] the blobs of the block must be available before the block is accepted.
+	if spec.DataAvailability != nil {
+		if err := spec.DataAvailability.IsDataAvailable(ctx, benv.BlockRoot, body.GetBlobKZGCommitments()); err != nil {
+			return fmt.Errorf("blob data availability check failed: %w", err)
+		}
+	}
 	// Modified in Deneb
 	eng, ok := spec.ExecutionEngine.(ExecutionEngine)
 	if !ok {
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/protolambda/ztyp/codec"
+
 	"github.com/protolambda/zrnt/eth2/beacon/altair"
 	"github.com/protolambda/zrnt/eth2/beacon/bellatrix"
 	"github.com/protolambda/zrnt/eth2/beacon/capella"
@@ -12,168 +14,352 @@ import (
 	"github.com/protolambda/zrnt/eth2/beacon/phase0"
 )
 
-type ForkDecoder struct {
-	Spec      *common.Spec
-	Genesis   common.ForkDigest
-	Altair    common.ForkDigest
-	Bellatrix common.ForkDigest
-	Capella   common.ForkDigest
-	Deneb     common.ForkDigest
-	// TODO more forks
-}
-
-func NewForkDecoder(spec *common.Spec, genesisValRoot common.Root) *ForkDecoder {
-	return &ForkDecoder{
-		Spec:      spec,
-		Genesis:   common.ComputeForkDigest(spec.GENESIS_FORK_VERSION, genesisValRoot),
-		Altair:    common.ComputeForkDigest(spec.ALTAIR_FORK_VERSION, genesisValRoot),
-		Bellatrix: common.ComputeForkDigest(spec.BELLATRIX_FORK_VERSION, genesisValRoot),
-		Capella:   common.ComputeForkDigest(spec.CAPELLA_FORK_VERSION, genesisValRoot),
-		Deneb:     common.ComputeForkDigest(spec.DENEB_FORK_VERSION, genesisValRoot),
-	}
-}
-
 type OpaqueBlock interface {
 	common.SpecObj
 	common.EnvelopeBuilder
 }
 
-func (d *ForkDecoder) BlockAllocator(digest common.ForkDigest) (func() OpaqueBlock, error) {
-	switch digest {
-	case d.Genesis:
-		return func() OpaqueBlock { return new(phase0.SignedBeaconBlock) }, nil
-	case d.Altair:
-		return func() OpaqueBlock { return new(altair.SignedBeaconBlock) }, nil
-	case d.Bellatrix:
-		return func() OpaqueBlock { return new(bellatrix.SignedBeaconBlock) }, nil
-	case d.Capella:
-		return func() OpaqueBlock { return new(capella.SignedBeaconBlock) }, nil
-	case d.Deneb:
-		return func() OpaqueBlock { return new(deneb.SignedBeaconBlock) }, nil
-	default:
-		return nil, fmt.Errorf("unrecognized fork digest: %s", digest)
-	}
-}
+// Fork describes a fork in the fork schedule: the scheduled fork, and the fork-specific types and functions.
+type Fork struct {
+	// ScheduledFork is the entry of the fork in common.ForkSchedule, with the name, version and activation epoch.
+	*common.ScheduledFork
 
-func (d *ForkDecoder) ForkDigest(epoch common.Epoch) common.ForkDigest {
-	if epoch < d.Spec.ALTAIR_FORK_EPOCH {
-		return d.Genesis
-	} else if epoch < d.Spec.BELLATRIX_FORK_EPOCH {
-		return d.Altair
-	} else if epoch < d.Spec.CAPELLA_FORK_EPOCH {
-		return d.Bellatrix
-	} else {
-		// only consider deneb if it's actually set equal or higher than capella, to ignore it if it's missing in a config.
-		if d.Spec.DENEB_FORK_EPOCH >= d.Spec.CAPELLA_FORK_EPOCH && epoch >= d.Spec.DENEB_FORK_EPOCH {
-			return d.Deneb
-		}
-		return d.Capella
-	}
+	// NewBlock allocates an empty signed beacon block of this fork.
+	NewBlock func() OpaqueBlock
+	// NewState allocates a default beacon state of this fork.
+	NewState func(spec *common.Spec) common.BeaconState
+	// DecodeState decodes a beacon state of this fork.
+	DecodeState func(spec *common.Spec, dr *codec.DecodingReader) (common.BeaconState, error)
+	// IsState checks if the state is a beacon state of this fork.
+	IsState func(state common.BeaconState) bool
+	// Upgrade upgrades a state of the previous fork to this fork. Nil for the genesis fork.
+	Upgrade func(spec *common.Spec, epc *common.EpochsContext, pre common.BeaconState) (common.BeaconState, error)
+	// EnvelopeToSignedBeaconBlock converts the envelope back into a full signed block,
+	// if the envelope body is of this fork (ok == false otherwise).
+	EnvelopeToSignedBeaconBlock func(benv *common.BeaconBlockEnvelope) (block common.SpecObj, ok bool)
 }
 
-type StandardUpgradeableBeaconState struct {
-	common.BeaconState
-}
-
-func (s *StandardUpgradeableBeaconState) UpgradeMaybe(ctx context.Context, spec *common.Spec, epc *common.EpochsContext) error {
-	slot, err := s.BeaconState.Slot()
-	if err != nil {
-		return err
-	}
-	if tpre, ok := s.BeaconState.(*phase0.BeaconStateView); ok && slot == common.Slot(spec.ALTAIR_FORK_EPOCH)*spec.SLOTS_PER_EPOCH {
-		post, err := altair.UpgradeToAltair(spec, epc, tpre)
-		if err != nil {
-			return fmt.Errorf("failed to upgrade phase0 to altair state: %v", err)
-		}
-		if err := epc.LoadSyncCommittees(post); err != nil {
-			return fmt.Errorf("failed to pre-compute sync committees: %v", err)
-		}
-		s.BeaconState = post
-	}
-	if tpre, ok := s.BeaconState.(*altair.BeaconStateView); ok && slot == common.Slot(spec.BELLATRIX_FORK_EPOCH)*spec.SLOTS_PER_EPOCH {
-		post, err := bellatrix.UpgradeToBellatrix(spec, epc, tpre)
-		if err != nil {
-			return fmt.Errorf("failed to upgrade atalir to bellatrix state: %v", err)
-		}
-		s.BeaconState = post
-	}
-	if tpre, ok := s.BeaconState.(*bellatrix.BeaconStateView); ok && slot == common.Slot(spec.CAPELLA_FORK_EPOCH)*spec.SLOTS_PER_EPOCH {
-		post, err := capella.UpgradeToCapella(spec, epc, tpre)
-		if err != nil {
-			return fmt.Errorf("failed to upgrade bellatrix to capella state: %v", err)
+var Phase0 = &Fork{
+	ScheduledFork: common.Phase0Fork,
+	NewBlock:      func() OpaqueBlock { return new(phase0.SignedBeaconBlock) },
+	NewState: func(spec *common.Spec) common.BeaconState {
+		return phase0.NewBeaconStateView(spec)
+	},
+	DecodeState: func(spec *common.Spec, dr *codec.DecodingReader) (common.BeaconState, error) {
+		return phase0.AsBeaconStateView(phase0.BeaconStateType(spec).Deserialize(dr))
+	},
+	IsState: func(state common.BeaconState) bool {
+		_, ok := state.(*phase0.BeaconStateView)
+		return ok
+	},
+	EnvelopeToSignedBeaconBlock: func(benv *common.BeaconBlockEnvelope) (common.SpecObj, bool) {
+		body, ok := benv.Body.(*phase0.BeaconBlockBody)
+		if !ok {
+			return nil, false
 		}
-		s.BeaconState = post
-	}
-	if tpre, ok := s.BeaconState.(*capella.BeaconStateView); ok && slot == common.Slot(spec.DENEB_FORK_EPOCH)*spec.SLOTS_PER_EPOCH {
-		post, err := deneb.UpgradeToDeneb(spec, epc, tpre)
-		if err != nil {
-			return fmt.Errorf("failed to upgrade bellatrix to capella state: %v", err)
-		}
-		s.BeaconState = post
-	}
-	return nil
-}
-
-var _ common.UpgradeableBeaconState = (*StandardUpgradeableBeaconState)(nil)
-
-func EnvelopeToSignedBeaconBlock(benv *common.BeaconBlockEnvelope) (common.SpecObj, error) {
-	switch x := benv.Body.(type) {
-	case *phase0.BeaconBlockBody:
 		return &phase0.SignedBeaconBlock{
 			Message: phase0.BeaconBlock{
 				Slot:          benv.Slot,
 				ProposerIndex: benv.ProposerIndex,
 				ParentRoot:    benv.ParentRoot,
 				StateRoot:     benv.StateRoot,
-				Body:          *x,
+				Body:          *body,
 			},
 			Signature: benv.Signature,
-		}, nil
-	case *altair.BeaconBlockBody:
+		}, true
+	},
+}
+
+var Altair = &Fork{
+	ScheduledFork: common.AltairFork,
+	NewBlock:      func() OpaqueBlock { return new(altair.SignedBeaconBlock) },
+	NewState: func(spec *common.Spec) common.BeaconState {
+		return altair.NewBeaconStateView(spec)
+	},
+	DecodeState: func(spec *common.Spec, dr *codec.DecodingReader) (common.BeaconState, error) {
+		return altair.AsBeaconStateView(altair.BeaconStateType(spec).Deserialize(dr))
+	},
+	IsState: func(state common.BeaconState) bool {
+		_, ok := state.(*altair.BeaconStateView)
+		return ok
+	},
+	Upgrade: func(spec *common.Spec, epc *common.EpochsContext, pre common.BeaconState) (common.BeaconState, error) {
+		tpre, ok := pre.(*phase0.BeaconStateView)
+		if !ok {
+			return nil, fmt.Errorf("expected phase0 state to upgrade to altair, got %T", pre)
+		}
+		post, err := altair.UpgradeToAltair(spec, epc, tpre)
+		if err != nil {
+			return nil, err
+		}
+		if err := epc.LoadSyncCommittees(post); err != nil {
+			return nil, fmt.Errorf("failed to pre-compute sync committees: %v", err)
+		}
+		return post, nil
+	},
+	EnvelopeToSignedBeaconBlock: func(benv *common.BeaconBlockEnvelope) (common.SpecObj, bool) {
+		body, ok := benv.Body.(*altair.BeaconBlockBody)
+		if !ok {
+			return nil, false
+		}
 		return &altair.SignedBeaconBlock{
 			Message: altair.BeaconBlock{
 				Slot:          benv.Slot,
 				ProposerIndex: benv.ProposerIndex,
 				ParentRoot:    benv.ParentRoot,
 				StateRoot:     benv.StateRoot,
-				Body:          *x,
+				Body:          *body,
 			},
 			Signature: benv.Signature,
-		}, nil
-	case *bellatrix.BeaconBlockBody:
+		}, true
+	},
+}
+
+var Bellatrix = &Fork{
+	ScheduledFork: common.BellatrixFork,
+	NewBlock:      func() OpaqueBlock { return new(bellatrix.SignedBeaconBlock) },
+	NewState: func(spec *common.Spec) common.BeaconState {
+		return bellatrix.NewBeaconStateView(spec)
+	},
+	DecodeState: func(spec *common.Spec, dr *codec.DecodingReader) (common.BeaconState, error) {
+		return bellatrix.AsBeaconStateView(bellatrix.BeaconStateType(spec).Deserialize(dr))
+	},
+	IsState: func(state common.BeaconState) bool {
+		_, ok := state.(*bellatrix.BeaconStateView)
+		return ok
+	},
+	Upgrade: func(spec *common.Spec, epc *common.EpochsContext, pre common.BeaconState) (common.BeaconState, error) {
+		tpre, ok := pre.(*altair.BeaconStateView)
+		if !ok {
+			return nil, fmt.Errorf("expected altair state to upgrade to bellatrix, got %T", pre)
+		}
+		return bellatrix.UpgradeToBellatrix(spec, epc, tpre)
+	},
+	EnvelopeToSignedBeaconBlock: func(benv *common.BeaconBlockEnvelope) (common.SpecObj, bool) {
+		body, ok := benv.Body.(*bellatrix.BeaconBlockBody)
+		if !ok {
+			return nil, false
+		}
 		return &bellatrix.SignedBeaconBlock{
 			Message: bellatrix.BeaconBlock{
 				Slot:          benv.Slot,
 				ProposerIndex: benv.ProposerIndex,
 				ParentRoot:    benv.ParentRoot,
 				StateRoot:     benv.StateRoot,
-				Body:          *x,
+				Body:          *body,
 			},
 			Signature: benv.Signature,
-		}, nil
-	case *capella.BeaconBlockBody:
+		}, true
+	},
+}
+
+var Capella = &Fork{
+	ScheduledFork: common.CapellaFork,
+	NewBlock:      func() OpaqueBlock { return new(capella.SignedBeaconBlock) },
+	NewState: func(spec *common.Spec) common.BeaconState {
+		return capella.NewBeaconStateView(spec)
+	},
+	DecodeState: func(spec *common.Spec, dr *codec.DecodingReader) (common.BeaconState, error) {
+		return capella.AsBeaconStateView(capella.BeaconStateType(spec).Deserialize(dr))
+	},
+	IsState: func(state common.BeaconState) bool {
+		_, ok := state.(*capella.BeaconStateView)
+		return ok
+	},
+	Upgrade: func(spec *common.Spec, epc *common.EpochsContext, pre common.BeaconState) (common.BeaconState, error) {
+		tpre, ok := pre.(*bellatrix.BeaconStateView)
+		if !ok {
+			return nil, fmt.Errorf("expected bellatrix state to upgrade to capella, got %T", pre)
+		}
+		return capella.UpgradeToCapella(spec, epc, tpre)
+	},
+	EnvelopeToSignedBeaconBlock: func(benv *common.BeaconBlockEnvelope) (common.SpecObj, bool) {
+		body, ok := benv.Body.(*capella.BeaconBlockBody)
+		if !ok {
+			return nil, false
+		}
 		return &capella.SignedBeaconBlock{
 			Message: capella.BeaconBlock{
 				Slot:          benv.Slot,
 				ProposerIndex: benv.ProposerIndex,
 				ParentRoot:    benv.ParentRoot,
 				StateRoot:     benv.StateRoot,
-				Body:          *x,
+				Body:          *body,
 			},
 			Signature: benv.Signature,
-		}, nil
-	case *deneb.BeaconBlockBody:
+		}, true
+	},
+}
+
+var Deneb = &Fork{
+	ScheduledFork: common.DenebFork,
+	NewBlock:      func() OpaqueBlock { return new(deneb.SignedBeaconBlock) },
+	NewState: func(spec *common.Spec) common.BeaconState {
+		return deneb.NewBeaconStateView(spec)
+	},
+	DecodeState: func(spec *common.Spec, dr *codec.DecodingReader) (common.BeaconState, error) {
+		return deneb.AsBeaconStateView(deneb.BeaconStateType(spec).Deserialize(dr))
+	},
+	IsState: func(state common.BeaconState) bool {
+		_, ok := state.(*deneb.BeaconStateView)
+		return ok
+	},
+	Upgrade: func(spec *common.Spec, epc *common.EpochsContext, pre common.BeaconState) (common.BeaconState, error) {
+		tpre, ok := pre.(*capella.BeaconStateView)
+		if !ok {
+			return nil, fmt.Errorf("expected capella state to upgrade to deneb, got %T", pre)
+		}
+		return deneb.UpgradeToDeneb(spec, epc, tpre)
+	},
+	EnvelopeToSignedBeaconBlock: func(benv *common.BeaconBlockEnvelope) (common.SpecObj, bool) {
+		body, ok := benv.Body.(*deneb.BeaconBlockBody)
+		if !ok {
+			return nil, false
+		}
 		return &deneb.SignedBeaconBlock{
 			Message: deneb.BeaconBlock{
 				Slot:          benv.Slot,
 				ProposerIndex: benv.ProposerIndex,
 				ParentRoot:    benv.ParentRoot,
 				StateRoot:     benv.StateRoot,
-				Body:          *x,
+				Body:          *body,
 			},
 			Signature: benv.Signature,
-		}, nil
-	default:
-		return nil, fmt.Errorf("cannot convert beacon block envelope to full signed block, unrecognized body type: %T", x)
+		}, true
+	},
+}
+
+// Forks registers the fork-specific types and functions of each fork in common.ForkSchedule, in the same order.
+// Adding a fork means adding its schedule entry to common.ForkSchedule, and its registration here.
+var Forks = []*Fork{
+	Phase0,
+	Altair,
+	Bellatrix,
+	Capella,
+	Deneb,
+}
+
+func init() {
+	if len(Forks) != len(common.ForkSchedule) {
+		panic(fmt.Errorf("registered %d forks, but %d are scheduled", len(Forks), len(common.ForkSchedule)))
+	}
+	for i, f := range Forks {
+		if f.ScheduledFork != common.ForkSchedule[i] {
+			panic(fmt.Errorf("registered fork %s does not match scheduled fork %s", f.Name, common.ForkSchedule[i].Name))
+		}
+	}
+}
+
+// ForkByName returns the registered fork with the given name, if any.
+func ForkByName(name string) (*Fork, bool) {
+	for _, f := range Forks {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+// ForkByVersion returns the registered fork with the given version, if any.
+func ForkByVersion(spec *common.Spec, version common.Version) (*Fork, bool) {
+	for _, f := range Forks {
+		if f.Version(spec) == version {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+// ForkAtEpoch returns the registered fork that is active at the given epoch.
+func ForkAtEpoch(spec *common.Spec, epoch common.Epoch) *Fork {
+	return Forks[spec.ForkIndexAtEpoch(epoch)]
+}
+
+// ForkOfState returns the registered fork of the given state type, if any.
+func ForkOfState(state common.BeaconState) (*Fork, bool) {
+	for _, f := range Forks {
+		if f.IsState(state) {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+type ForkDecoder struct {
+	Spec *common.Spec
+	// Fork digests, one for each of the registered Forks, in the same order.
+	Digests []common.ForkDigest
+}
+
+func NewForkDecoder(spec *common.Spec, genesisValRoot common.Root) *ForkDecoder {
+	digests := make([]common.ForkDigest, len(Forks))
+	for i, f := range Forks {
+		digests[i] = common.ComputeForkDigest(f.Version(spec), genesisValRoot)
+	}
+	return &ForkDecoder{
+		Spec:    spec,
+		Digests: digests,
+	}
+}
+
+// ForkByDigest returns the registered fork with the given digest.
+func (d *ForkDecoder) ForkByDigest(digest common.ForkDigest) (*Fork, error) {
+	for i, x := range d.Digests {
+		if x == digest {
+			return Forks[i], nil
+		}
+	}
+	return nil, fmt.Errorf("unrecognized fork digest: %s", digest)
+}
+
+func (d *ForkDecoder) BlockAllocator(digest common.ForkDigest) (func() OpaqueBlock, error) {
+	f, err := d.ForkByDigest(digest)
+	if err != nil {
+		return nil, err
+	}
+	return f.NewBlock, nil
+}
+
+func (d *ForkDecoder) ForkDigest(epoch common.Epoch) common.ForkDigest {
+	return d.Digests[d.Spec.ForkIndexAtEpoch(epoch)]
+}
+
+type StandardUpgradeableBeaconState struct {
+	common.BeaconState
+}
+
+func (s *StandardUpgradeableBeaconState) UpgradeMaybe(ctx context.Context, spec *common.Spec, epc *common.EpochsContext) error {
+	slot, err := s.BeaconState.Slot()
+	if err != nil {
+		return err
+	}
+	// Multiple forks may activate at the same epoch, upgrade through each of them in order.
+	for i := 1; i < len(Forks); i++ {
+		pre, post := Forks[i-1], Forks[i]
+		if !pre.IsState(s.BeaconState) {
+			continue
+		}
+		forkSlot, err := spec.EpochStartSlot(post.Epoch(spec))
+		if err != nil || slot != forkSlot {
+			// Not scheduled, or not at the fork boundary
+			continue
+		}
+		state, err := post.Upgrade(spec, epc, s.BeaconState)
+		if err != nil {
+			return fmt.Errorf("failed to upgrade %s to %s state: %v", pre.Name, post.Name, err)
+		}
+		s.BeaconState = state
+	}
+	return nil
+}
+
+var _ common.UpgradeableBeaconState = (*StandardUpgradeableBeaconState)(nil)
+
+func EnvelopeToSignedBeaconBlock(benv *common.BeaconBlockEnvelope) (common.SpecObj, error) {
+	for _, f := range Forks {
+		if block, ok := f.EnvelopeToSignedBeaconBlock(benv); ok {
+			return block, nil
+		}
 	}
+	return nil, fmt.Errorf("cannot convert beacon block envelope to full signed block, unrecognized body type: %T", benv.Body)
 }
@@ -0,0 +1,47 @@
+package beacon
+
+import (
+	"testing"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/configs"
+)
+
+func TestForkAtEpoch(t *testing.T) {
+	spec := *configs.Mainnet
+	spec.ALTAIR_FORK_EPOCH = 10
+	spec.BELLATRIX_FORK_EPOCH = 20
+	spec.CAPELLA_FORK_EPOCH = 30
+	spec.DENEB_FORK_EPOCH = 40
+	for epoch, expected := range map[common.Epoch]*Fork{
+		0:                Phase0,
+		9:                Phase0,
+		10:               Altair,
+		29:               Bellatrix,
+		30:               Capella,
+		40:               Deneb,
+		^common.Epoch(0): Deneb,
+	} {
+		if f := ForkAtEpoch(&spec, epoch); f != expected {
+			t.Errorf("epoch %d: expected fork %s, got %s", epoch, expected.Name, f.Name)
+		}
+	}
+	// the spec version accessor must agree with the registry
+	for _, f := range Forks {
+		slot, err := spec.EpochStartSlot(f.Epoch(&spec))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := spec.ForkVersion(slot); v != f.Version(&spec) {
+			t.Errorf("%s: expected fork version %s, got %s", f.Name, f.Version(&spec), v)
+		}
+	}
+	// a fork scheduled before its preceding fork is ignored
+	spec.DENEB_FORK_EPOCH = 0
+	if f := ForkAtEpoch(&spec, 50); f != Capella {
+		t.Errorf("expected out-of-order deneb fork to be ignored, got %s", f.Name)
+	}
+	if v := spec.ForkVersion(50 * spec.SLOTS_PER_EPOCH); v != spec.CAPELLA_FORK_VERSION {
+		t.Errorf("expected capella fork version, got %s", v)
+	}
+}
@@ -0,0 +1,59 @@
+package beacon
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/protolambda/zrnt/eth2/beacon/altair"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+)
+
+// ComputeUnrealizedCheckpoints runs justification and finalization on a copy of the state,
+// to get the checkpoints the state would have at the start of the next epoch (the "pulled-up" checkpoints).
+// The epochs context must match the state. The state itself is not modified.
+func ComputeUnrealizedCheckpoints(ctx context.Context, spec *common.Spec, epc *common.EpochsContext,
+	state common.BeaconState) (justified common.Checkpoint, finalized common.Checkpoint, err error) {
+	state, err = state.CopyState()
+	if err != nil {
+		return
+	}
+	vals, err := state.Validators()
+	if err != nil {
+		return
+	}
+	flats, err := common.FlattenValidators(vals)
+	if err != nil {
+		return
+	}
+	just := phase0.JustificationStakeData{
+		CurrentEpoch:     epc.CurrentEpoch.Epoch,
+		TotalActiveStake: epc.TotalActiveStake,
+	}
+	switch s := state.(type) {
+	case altair.AltairLikeBeaconState:
+		data, err := altair.ComputeEpochAttesterData(ctx, spec, epc, flats, s)
+		if err != nil {
+			return justified, finalized, err
+		}
+		just.PrevEpochUnslashedTargetStake = data.PrevEpochUnslashedStake.TargetStake
+		just.CurrEpochUnslashedTargetStake = data.CurrEpochUnslashedTargetStake
+	case phase0.Phase0PendingAttestationsBeaconState:
+		data, err := phase0.ComputeEpochAttesterData(ctx, spec, epc, flats, s)
+		if err != nil {
+			return justified, finalized, err
+		}
+		just.PrevEpochUnslashedTargetStake = data.PrevEpochUnslashedStake.TargetStake
+		just.CurrEpochUnslashedTargetStake = data.CurrEpochUnslashedTargetStake
+	default:
+		return justified, finalized, fmt.Errorf("unrecognized beacon state type: %T", state)
+	}
+	if err = phase0.ProcessEpochJustification(ctx, spec, &just, state); err != nil {
+		return
+	}
+	if justified, err = state.CurrentJustifiedCheckpoint(); err != nil {
+		return
+	}
+	finalized, err = state.FinalizedCheckpoint()
+	return
+}
@@ -0,0 +1,134 @@
+package blobs
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/protolambda/ztyp/codec"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
+)
+
+// FileBlobStore persists blob sidecars as SSZ files, grouped in a directory per slot:
+// <dir>/<slot>/<block root hex>_<index>.ssz
+// The index is kept in memory, and rebuilt from the file names when the store is opened.
+type FileBlobStore struct {
+	*indexedStore
+}
+
+var _ BlobStore = (*FileBlobStore)(nil)
+
+// NewFileBlobStore opens the blob store in the given directory, and creates the directory if it does not exist yet.
+func NewFileBlobStore(spec *common.Spec, dir string) (*FileBlobStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create blob store dir: %w", err)
+	}
+	s := &FileBlobStore{newIndexedStore(spec, &fileStorage{spec: spec, dir: dir})}
+	slotDirs, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob store dir: %w", err)
+	}
+	for _, slotDir := range slotDirs {
+		if !slotDir.IsDir() {
+			continue
+		}
+		slot, err := strconv.ParseUint(slotDir.Name(), 10, 64)
+		if err != nil {
+			continue
+		}
+		files, err := ioutil.ReadDir(filepath.Join(dir, slotDir.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read blob store slot dir %d: %w", slot, err)
+		}
+		for _, f := range files {
+			id, ok := parseBlobFileName(f.Name())
+			if !ok {
+				continue
+			}
+			s.index(common.Slot(slot), id)
+		}
+	}
+	return s, nil
+}
+
+func blobFileName(id deneb.BlobIdentifier) string {
+	return fmt.Sprintf("%s_%d.ssz", hex.EncodeToString(id.BlockRoot[:]), id.Index)
+}
+
+func parseBlobFileName(name string) (id deneb.BlobIdentifier, ok bool) {
+	name = strings.TrimSuffix(name, ".ssz")
+	parts := strings.Split(name, "_")
+	if len(parts) != 2 || hex.DecodedLen(len(parts[0])) != len(id.BlockRoot) {
+		return id, false
+	}
+	if _, err := hex.Decode(id.BlockRoot[:], []byte(parts[0])); err != nil {
+		return id, false
+	}
+	index, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return id, false
+	}
+	id.Index = deneb.BlobIndex(index)
+	return id, true
+}
+
+type fileStorage struct {
+	spec *common.Spec
+	dir  string
+}
+
+func (f *fileStorage) slotDir(slot common.Slot) string {
+	return filepath.Join(f.dir, strconv.FormatUint(uint64(slot), 10))
+}
+
+func (f *fileStorage) put(ctx context.Context, slot common.Slot, id deneb.BlobIdentifier, sidecar *deneb.BlobSidecar) error {
+	dir := f.slotDir(slot)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := sidecar.Serialize(f.spec, codec.NewEncodingWriter(&buf)); err != nil {
+		return err
+	}
+	// write to a temporary file first, to not leave a partial sidecar behind
+	p := filepath.Join(dir, blobFileName(id))
+	tmp := p + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+func (f *fileStorage) get(ctx context.Context, slot common.Slot, id deneb.BlobIdentifier) (*deneb.BlobSidecar, error) {
+	data, err := ioutil.ReadFile(filepath.Join(f.slotDir(slot), blobFileName(id)))
+	if err != nil {
+		return nil, err
+	}
+	var sidecar deneb.BlobSidecar
+	if err := sidecar.Deserialize(f.spec, codec.NewDecodingReader(bytes.NewReader(data), uint64(len(data)))); err != nil {
+		return nil, fmt.Errorf("failed to decode blob sidecar %s: %w", &id, err)
+	}
+	return &sidecar, nil
+}
+
+func (f *fileStorage) remove(slot common.Slot, id deneb.BlobIdentifier) error {
+	dir := f.slotDir(slot)
+	if err := os.Remove(filepath.Join(dir, blobFileName(id))); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// Clean up the slot dir if it is empty, the error is ignored if it is not.
+	_ = os.Remove(dir)
+	return nil
+}
+
+func (f *fileStorage) close() error {
+	return nil
+}
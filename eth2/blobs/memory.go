@@ -0,0 +1,39 @@
+package blobs
+
+import (
+	"context"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
+)
+
+// MemoryBlobStore keeps all blob sidecars in memory.
+type MemoryBlobStore struct {
+	*indexedStore
+}
+
+var _ BlobStore = (*MemoryBlobStore)(nil)
+
+func NewMemoryBlobStore(spec *common.Spec) *MemoryBlobStore {
+	return &MemoryBlobStore{newIndexedStore(spec, make(memoryStorage))}
+}
+
+type memoryStorage map[deneb.BlobIdentifier]*deneb.BlobSidecar
+
+func (m memoryStorage) put(ctx context.Context, slot common.Slot, id deneb.BlobIdentifier, sidecar *deneb.BlobSidecar) error {
+	m[id] = sidecar
+	return nil
+}
+
+func (m memoryStorage) get(ctx context.Context, slot common.Slot, id deneb.BlobIdentifier) (*deneb.BlobSidecar, error) {
+	return m[id], nil
+}
+
+func (m memoryStorage) remove(slot common.Slot, id deneb.BlobIdentifier) error {
+	delete(m, id)
+	return nil
+}
+
+func (m memoryStorage) close() error {
+	return nil
+}
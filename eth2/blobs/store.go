@@ -0,0 +1,297 @@
+package blobs
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
+)
+
+// CanonicalBlock returns the root of the canonical block at the given slot, if there is any.
+type CanonicalBlock func(slot common.Slot) (blockRoot common.Root, ok bool)
+
+// BlobStore keeps validated blob sidecars around for the data-availability window,
+// and serves them to the BlobSidecarsByRange and BlobSidecarsByRoot req-resp methods.
+type BlobStore interface {
+	deneb.BlobSidecarsGetter
+
+	// AddBlobSidecar stores the sidecar, it should be validated beforehand.
+	// Returns false if the sidecar was already stored.
+	AddBlobSidecar(ctx context.Context, sidecar *deneb.BlobSidecar) (bool, error)
+	// BlobSidecar retrieves a single sidecar. Returns nil if the sidecar is not known.
+	BlobSidecar(ctx context.Context, id deneb.BlobIdentifier) (*deneb.BlobSidecar, error)
+	// BlobSidecarsByRoot returns the known sidecars of the given identifiers, in request order. Unknown sidecars are skipped.
+	// An error is returned if more than MAX_REQUEST_BLOB_SIDECARS identifiers are requested.
+	BlobSidecarsByRoot(ctx context.Context, ids []deneb.BlobIdentifier) ([]*deneb.BlobSidecar, error)
+	// BlobSidecarsByRange returns the known sidecars of the canonical blocks in the slot range [startSlot, startSlot+count),
+	// ordered by slot, then by index. At most MAX_REQUEST_BLOB_SIDECARS sidecars are returned.
+	// An error is returned if more than MAX_REQUEST_BLOCKS_DENEB slots are requested.
+	BlobSidecarsByRange(ctx context.Context, startSlot common.Slot, count uint64, canonical CanonicalBlock) ([]*deneb.BlobSidecar, error)
+	// OnFinalized removes the sidecars of blocks up to and including the finalized slot, that are not canonical.
+	OnFinalized(ctx context.Context, finalizedSlot common.Slot, canonical CanonicalBlock) error
+	// Prune removes all sidecars from before the retention window of the given current epoch.
+	// See RetentionStartSlot.
+	Prune(ctx context.Context, currentEpoch common.Epoch) error
+	// Close releases any resources of the store.
+	Close() error
+}
+
+// RetentionStartSlot is the first slot of the window that blob sidecars must be available for,
+// i.e. the start of epoch max(current_epoch - MIN_EPOCHS_FOR_BLOB_SIDECARS_REQUESTS, DENEB_FORK_EPOCH).
+func RetentionStartSlot(spec *common.Spec, currentEpoch common.Epoch) common.Slot {
+	start := spec.DENEB_FORK_EPOCH
+	if minEpochs := common.Epoch(spec.MIN_EPOCHS_FOR_BLOB_SIDECARS_REQUESTS); currentEpoch > minEpochs && currentEpoch-minEpochs > start {
+		start = currentEpoch - minEpochs
+	}
+	slot, err := spec.EpochStartSlot(start)
+	if err != nil {
+		// Far-future fork epoch: nothing is retained before it.
+		return ^common.Slot(0)
+	}
+	return slot
+}
+
+// blobStorage persists the sidecars for an indexedStore.
+type blobStorage interface {
+	put(ctx context.Context, slot common.Slot, id deneb.BlobIdentifier, sidecar *deneb.BlobSidecar) error
+	get(ctx context.Context, slot common.Slot, id deneb.BlobIdentifier) (*deneb.BlobSidecar, error)
+	remove(slot common.Slot, id deneb.BlobIdentifier) error
+	close() error
+}
+
+type blockBlobs struct {
+	slot    common.Slot
+	indices []deneb.BlobIndex // sorted
+}
+
+// indexedStore implements the BlobStore queries on top of an in-memory index, the sidecars are kept by the storage.
+type indexedStore struct {
+	sync.RWMutex
+	spec    *common.Spec
+	storage blobStorage
+	byRoot  map[common.Root]*blockBlobs
+	bySlot  map[common.Slot][]common.Root
+}
+
+func newIndexedStore(spec *common.Spec, storage blobStorage) *indexedStore {
+	return &indexedStore{
+		spec:    spec,
+		storage: storage,
+		byRoot:  make(map[common.Root]*blockBlobs),
+		bySlot:  make(map[common.Slot][]common.Root),
+	}
+}
+
+// has checks if the sidecar is indexed, the caller must hold the lock.
+func (s *indexedStore) has(id deneb.BlobIdentifier) (*blockBlobs, bool) {
+	b, ok := s.byRoot[id.BlockRoot]
+	if !ok {
+		return nil, false
+	}
+	i := sort.Search(len(b.indices), func(i int) bool { return b.indices[i] >= id.Index })
+	return b, i < len(b.indices) && b.indices[i] == id.Index
+}
+
+// index adds the sidecar to the index, the caller must hold the lock.
+func (s *indexedStore) index(slot common.Slot, id deneb.BlobIdentifier) {
+	b, ok := s.byRoot[id.BlockRoot]
+	if !ok {
+		b = &blockBlobs{slot: slot}
+		s.byRoot[id.BlockRoot] = b
+		s.bySlot[slot] = append(s.bySlot[slot], id.BlockRoot)
+	}
+	i := sort.Search(len(b.indices), func(i int) bool { return b.indices[i] >= id.Index })
+	if i < len(b.indices) && b.indices[i] == id.Index {
+		return
+	}
+	b.indices = append(b.indices, 0)
+	copy(b.indices[i+1:], b.indices[i:])
+	b.indices[i] = id.Index
+}
+
+// removeBlock removes all sidecars of the block from the index and storage, the caller must hold the lock.
+func (s *indexedStore) removeBlock(blockRoot common.Root) error {
+	b, ok := s.byRoot[blockRoot]
+	if !ok {
+		return nil
+	}
+	for _, index := range b.indices {
+		if err := s.storage.remove(b.slot, deneb.BlobIdentifier{BlockRoot: blockRoot, Index: index}); err != nil {
+			return fmt.Errorf("failed to remove blob sidecar %s:%d: %w", blockRoot, index, err)
+		}
+	}
+	delete(s.byRoot, blockRoot)
+	roots := s.bySlot[b.slot]
+	for i, r := range roots {
+		if r == blockRoot {
+			roots = append(roots[:i], roots[i+1:]...)
+			break
+		}
+	}
+	if len(roots) == 0 {
+		delete(s.bySlot, b.slot)
+	} else {
+		s.bySlot[b.slot] = roots
+	}
+	return nil
+}
+
+func (s *indexedStore) AddBlobSidecar(ctx context.Context, sidecar *deneb.BlobSidecar) (bool, error) {
+	if uint64(sidecar.Index) >= uint64(s.spec.MAX_BLOBS_PER_BLOCK) {
+		return false, fmt.Errorf("blob index %d is not below max %d", sidecar.Index, s.spec.MAX_BLOBS_PER_BLOCK)
+	}
+	id := sidecar.Identifier()
+	slot := sidecar.SignedBlockHeader.Message.Slot
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.has(id); ok {
+		return false, nil
+	}
+	if err := s.storage.put(ctx, slot, id, sidecar); err != nil {
+		return false, fmt.Errorf("failed to store blob sidecar %s: %w", &id, err)
+	}
+	s.index(slot, id)
+	return true, nil
+}
+
+func (s *indexedStore) BlobSidecar(ctx context.Context, id deneb.BlobIdentifier) (*deneb.BlobSidecar, error) {
+	s.RLock()
+	defer s.RUnlock()
+	b, ok := s.has(id)
+	if !ok {
+		return nil, nil
+	}
+	return s.storage.get(ctx, b.slot, id)
+}
+
+func (s *indexedStore) BlobSidecarsByBlockRoot(ctx context.Context, blockRoot common.Root) ([]*deneb.BlobSidecar, error) {
+	s.RLock()
+	defer s.RUnlock()
+	b, ok := s.byRoot[blockRoot]
+	if !ok {
+		return nil, nil
+	}
+	out := make([]*deneb.BlobSidecar, 0, len(b.indices))
+	for _, index := range b.indices {
+		sc, err := s.storage.get(ctx, b.slot, deneb.BlobIdentifier{BlockRoot: blockRoot, Index: index})
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, sc)
+	}
+	return out, nil
+}
+
+func (s *indexedStore) BlobSidecarsByRoot(ctx context.Context, ids []deneb.BlobIdentifier) ([]*deneb.BlobSidecar, error) {
+	if uint64(len(ids)) > uint64(s.spec.MAX_REQUEST_BLOB_SIDECARS) {
+		return nil, fmt.Errorf("too many blob sidecars requested: %d, max is %d", len(ids), s.spec.MAX_REQUEST_BLOB_SIDECARS)
+	}
+	s.RLock()
+	defer s.RUnlock()
+	out := make([]*deneb.BlobSidecar, 0, len(ids))
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		b, ok := s.has(id)
+		if !ok {
+			continue
+		}
+		sc, err := s.storage.get(ctx, b.slot, id)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, sc)
+	}
+	return out, nil
+}
+
+func (s *indexedStore) BlobSidecarsByRange(ctx context.Context, startSlot common.Slot, count uint64, canonical CanonicalBlock) ([]*deneb.BlobSidecar, error) {
+	if count > uint64(s.spec.MAX_REQUEST_BLOCKS_DENEB) {
+		return nil, fmt.Errorf("too many blocks requested: %d, max is %d", count, s.spec.MAX_REQUEST_BLOCKS_DENEB)
+	}
+	if startSlot+common.Slot(count) < startSlot {
+		return nil, fmt.Errorf("slot range overflow: %d + %d", startSlot, count)
+	}
+	limit := uint64(s.spec.MAX_REQUEST_BLOB_SIDECARS)
+	s.RLock()
+	defer s.RUnlock()
+	var out []*deneb.BlobSidecar
+	for slot := startSlot; slot < startSlot+common.Slot(count); slot++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if _, ok := s.bySlot[slot]; !ok {
+			continue
+		}
+		blockRoot, ok := canonical(slot)
+		if !ok {
+			continue
+		}
+		b, ok := s.byRoot[blockRoot]
+		if !ok || b.slot != slot {
+			continue
+		}
+		for _, index := range b.indices {
+			if uint64(len(out)) >= limit {
+				return out, nil
+			}
+			sc, err := s.storage.get(ctx, slot, deneb.BlobIdentifier{BlockRoot: blockRoot, Index: index})
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, sc)
+		}
+	}
+	return out, nil
+}
+
+func (s *indexedStore) OnFinalized(ctx context.Context, finalizedSlot common.Slot, canonical CanonicalBlock) error {
+	s.Lock()
+	defer s.Unlock()
+	var orphaned []common.Root
+	for slot, roots := range s.bySlot {
+		if slot > finalizedSlot {
+			continue
+		}
+		canonRoot, ok := canonical(slot)
+		for _, r := range roots {
+			if !ok || r != canonRoot {
+				orphaned = append(orphaned, r)
+			}
+		}
+	}
+	for _, r := range orphaned {
+		if err := s.removeBlock(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *indexedStore) Prune(ctx context.Context, currentEpoch common.Epoch) error {
+	boundary := RetentionStartSlot(s.spec, currentEpoch)
+	s.Lock()
+	defer s.Unlock()
+	var expired []common.Root
+	for slot, roots := range s.bySlot {
+		if slot < boundary {
+			expired = append(expired, roots...)
+		}
+	}
+	for _, r := range expired {
+		if err := s.removeBlock(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *indexedStore) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	return s.storage.close()
+}
@@ -0,0 +1,130 @@
+package blobs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/protolambda/ztyp/tree"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
+	"github.com/protolambda/zrnt/eth2/configs"
+)
+
+func testSidecar(spec *common.Spec, slot common.Slot, graffiti byte, index deneb.BlobIndex) *deneb.BlobSidecar {
+	sc := &deneb.BlobSidecar{
+		Index:                       index,
+		Blob:                        make(deneb.Blob, uint64(spec.FIELD_ELEMENTS_PER_BLOB)*common.BYTES_PER_FIELD_ELEMENT),
+		KZGCommitmentInclusionProof: make(deneb.KZGCommitmentInclusionProof, spec.KZG_COMMITMENT_INCLUSION_PROOF_DEPTH),
+	}
+	sc.Blob[0] = graffiti
+	sc.SignedBlockHeader.Message.Slot = slot
+	sc.SignedBlockHeader.Message.BodyRoot = common.Root{graffiti}
+	return sc
+}
+
+func blockRoot(sc *deneb.BlobSidecar) common.Root {
+	return sc.SignedBlockHeader.Message.HashTreeRoot(tree.GetHashFn())
+}
+
+func testBlobStore(t *testing.T, spec *common.Spec, open func() BlobStore) {
+	ctx := context.Background()
+	store := open()
+
+	a0, a1 := testSidecar(spec, 10, 0xa, 0), testSidecar(spec, 10, 0xa, 1)
+	b0 := testSidecar(spec, 10, 0xb, 0) // competing block at the same slot
+	c0 := testSidecar(spec, 20, 0xc, 0)
+	for _, sc := range []*deneb.BlobSidecar{a1, a0, b0, c0} {
+		if added, err := store.AddBlobSidecar(ctx, sc); err != nil {
+			t.Fatal(err)
+		} else if !added {
+			t.Fatal("expected sidecar to be added")
+		}
+	}
+	if added, err := store.AddBlobSidecar(ctx, a0); err != nil || added {
+		t.Fatalf("expected duplicate to be ignored: %v", err)
+	}
+	canonical := func(slot common.Slot) (common.Root, bool) {
+		switch slot {
+		case 10:
+			return blockRoot(a0), true
+		case 20:
+			return blockRoot(c0), true
+		}
+		return common.Root{}, false
+	}
+
+	// reopen, to check the index is restored
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	store = open()
+
+	res, err := store.BlobSidecarsByRange(ctx, 9, 12, canonical)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 3 || res[0].Index != 0 || res[1].Index != 1 || blockRoot(res[1]) != blockRoot(a0) || res[2].SignedBlockHeader.Message.Slot != 20 {
+		t.Fatalf("unexpected by-range result: %d sidecars", len(res))
+	}
+	res, err = store.BlobSidecarsByRoot(ctx, []deneb.BlobIdentifier{b0.Identifier(), {BlockRoot: blockRoot(b0), Index: 3}, a1.Identifier()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 2 || blockRoot(res[0]) != blockRoot(b0) || res[1].Index != 1 {
+		t.Fatalf("unexpected by-root result: %d sidecars", len(res))
+	}
+	if _, err := store.BlobSidecarsByRoot(ctx, make([]deneb.BlobIdentifier, spec.MAX_REQUEST_BLOB_SIDECARS+1)); err == nil {
+		t.Fatal("expected request limit error")
+	}
+
+	// finalizing slot 10 removes the competing block
+	if err := store.OnFinalized(ctx, 10, canonical); err != nil {
+		t.Fatal(err)
+	}
+	if sc, err := store.BlobSidecar(ctx, b0.Identifier()); err != nil || sc != nil {
+		t.Fatalf("expected orphaned sidecar to be pruned: %v", err)
+	}
+	if res, err := store.BlobSidecarsByBlockRoot(ctx, blockRoot(a0)); err != nil || len(res) != 2 {
+		t.Fatalf("expected canonical sidecars to be kept: %v", err)
+	}
+
+	// moving the retention window past slot 10 prunes the old sidecars
+	currentEpoch := common.Epoch(spec.MIN_EPOCHS_FOR_BLOB_SIDECARS_REQUESTS) + spec.SlotToEpoch(20)
+	if err := store.Prune(ctx, currentEpoch); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := store.BlobSidecarsByBlockRoot(ctx, blockRoot(a0)); err != nil || len(res) != 0 {
+		t.Fatalf("expected expired sidecars to be pruned: %v", err)
+	}
+	if sc, err := store.BlobSidecar(ctx, c0.Identifier()); err != nil || sc == nil {
+		t.Fatalf("expected sidecar within retention window to be kept: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testSpec() *common.Spec {
+	spec := *configs.Minimal
+	spec.DENEB_FORK_EPOCH = 0
+	return &spec
+}
+
+func TestMemoryBlobStore(t *testing.T) {
+	spec := testSpec()
+	mem := NewMemoryBlobStore(spec)
+	testBlobStore(t, spec, func() BlobStore { return mem })
+}
+
+func TestFileBlobStore(t *testing.T) {
+	spec := testSpec()
+	dir := t.TempDir()
+	testBlobStore(t, spec, func() BlobStore {
+		s, err := NewFileBlobStore(spec, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
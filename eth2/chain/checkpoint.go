@@ -0,0 +1,132 @@
+package chain
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/protolambda/ztyp/tree"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+// NewCheckpointChain starts a chain from a trusted finalized state, and the signed block of that state,
+// instead of replaying every block since genesis (weak-subjectivity sync).
+// The state must be at the start of an epoch, and be the post-state of the block,
+// or the state after empty slots following the block: the block must match the latest block header of the state.
+// Older blocks can be verified with a Backfill that starts at the anchor block.
+func NewCheckpointChain(spec *common.Spec, state common.BeaconState, block common.EnvelopeBuilder, sink EntrySink) (*UnfinalizedChain, error) {
+	slot, err := state.Slot()
+	if err != nil {
+		return nil, err
+	}
+	if slot%spec.SLOTS_PER_EPOCH != 0 {
+		return nil, fmt.Errorf("checkpoint state slot %d is not at an epoch boundary", slot)
+	}
+	genesisValRoot, err := state.GenesisValidatorsRoot()
+	if err != nil {
+		return nil, err
+	}
+	benv := block.Envelope(spec, common.ComputeForkDigest(spec.ForkVersion(slot), genesisValRoot))
+	if benv.Slot > slot {
+		return nil, fmt.Errorf("checkpoint block slot %d is after state slot %d", benv.Slot, slot)
+	}
+	if benv.Slot < slot {
+		// the block may be from before a fork at the epoch boundary
+		benv = block.Envelope(spec, common.ComputeForkDigest(spec.ForkVersion(benv.Slot), genesisValRoot))
+	}
+	stateRoot := state.HashTreeRoot(tree.GetHashFn())
+	if benv.Slot == slot && benv.StateRoot != stateRoot {
+		return nil, fmt.Errorf("checkpoint block state root %s does not match state root %s", benv.StateRoot, stateRoot)
+	}
+	header, err := state.LatestBlockHeader()
+	if err != nil {
+		return nil, err
+	}
+	// The state root of the header is only filled in at the next slot, after empty slots it is already there.
+	if header.StateRoot == (common.Root{}) {
+		header.StateRoot = benv.StateRoot
+	}
+	if headerRoot := header.HashTreeRoot(tree.GetHashFn()); headerRoot != benv.BlockRoot {
+		return nil, fmt.Errorf("checkpoint block %s does not match latest block header %s of state", benv.BlockRoot, headerRoot)
+	}
+	epc, err := common.NewEpochsContext(spec, state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create epochs context of checkpoint: %w", err)
+	}
+	return newUnfinalizedChain(spec, state, epc, benv, sink)
+}
+
+var errBackfillDone = errors.New("backfill is done")
+
+// Backfill verifies the blocks before an anchor block, newest first:
+// every block must be the parent of the previously verified block, and be signed by its proposer.
+// Verified blocks are not stored, the caller is responsible for persisting them.
+type Backfill struct {
+	sync.Mutex
+	spec           *common.Spec
+	pubkeys        *common.PubkeyCache
+	genesisValRoot common.Root
+	// the next block to verify
+	expected common.Root
+	// slot of the last verified block
+	slot   common.Slot
+	toSlot common.Slot
+}
+
+// NewBackfill starts verifying blocks from the parent of the anchor block, down to (and including) toSlot.
+// The pubkeys are those of the anchor state: every older proposer is part of it.
+func NewBackfill(spec *common.Spec, pubkeys *common.PubkeyCache, genesisValRoot common.Root,
+	anchorParent common.Root, anchorSlot common.Slot, toSlot common.Slot) *Backfill {
+	return &Backfill{
+		spec:           spec,
+		pubkeys:        pubkeys,
+		genesisValRoot: genesisValRoot,
+		expected:       anchorParent,
+		slot:           anchorSlot,
+		toSlot:         toSlot,
+	}
+}
+
+// Next returns the root of the next block to verify.
+func (b *Backfill) Next() common.Root {
+	b.Lock()
+	defer b.Unlock()
+	return b.expected
+}
+
+// Done returns true when the backfill reached the requested slot, or genesis.
+func (b *Backfill) Done() bool {
+	b.Lock()
+	defer b.Unlock()
+	return b.done()
+}
+
+func (b *Backfill) done() bool {
+	return b.slot <= b.toSlot || b.slot == common.GENESIS_SLOT
+}
+
+// AddBlock verifies the block to be the next block of the backfill, and moves the backfill to its parent.
+func (b *Backfill) AddBlock(benv *common.BeaconBlockEnvelope) error {
+	b.Lock()
+	defer b.Unlock()
+	if b.done() {
+		return errBackfillDone
+	}
+	if benv.BlockRoot != b.expected {
+		return fmt.Errorf("expected block %s, got %s", b.expected, benv.BlockRoot)
+	}
+	if benv.Slot >= b.slot {
+		return fmt.Errorf("block %s at slot %d is not older than its child at slot %d", benv.BlockRoot, benv.Slot, b.slot)
+	}
+	pub, ok := b.pubkeys.Pubkey(benv.ProposerIndex)
+	if !ok {
+		return fmt.Errorf("unknown proposer %d of block %s", benv.ProposerIndex, benv.BlockRoot)
+	}
+	if !benv.VerifySignature(b.spec, b.genesisValRoot, benv.ProposerIndex, pub) {
+		return fmt.Errorf("invalid proposer signature of block %s", benv.BlockRoot)
+	}
+	b.expected = benv.ParentRoot
+	b.slot = benv.Slot
+	return nil
+}
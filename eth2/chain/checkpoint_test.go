@@ -0,0 +1,145 @@
+package chain
+
+import (
+	"context"
+	"testing"
+
+	"github.com/protolambda/zrnt/eth2/beacon"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+func TestCheckpointChain(t *testing.T) {
+	ctx := context.Background()
+	tc := newTestChain(t, 64)
+	genesis, err := tc.chain.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent, _ := genesis.BlockRoot()
+	var blocks []*common.BeaconBlockEnvelope
+	for _, slot := range []common.Slot{1, 3, 6, 7, 8} {
+		benv := tc.addBlock(parent, slot, 0)
+		blocks = append(blocks, benv)
+		parent = benv.BlockRoot
+	}
+	anchor := blocks[len(blocks)-1]
+	anchorEntry, ok := tc.chain.ByBlock(anchor.BlockRoot)
+	if !ok {
+		t.Fatal("missing anchor")
+	}
+	state, err := anchorEntry.State(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := beacon.EnvelopeToSignedBeaconBlock(anchor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := signed.(common.EnvelopeBuilder)
+
+	ch, err := NewCheckpointChain(tc.spec, state, block, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := ch.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Step() != common.AsStep(8, true) {
+		t.Fatalf("unexpected head step %s", head.Step())
+	}
+	if e, ok := ch.ByBlock(anchor.BlockRoot); !ok || e.(*HotEntry).Block() == nil {
+		t.Fatal("expected anchor block to be available")
+	}
+	// continue the chain from the checkpoint
+	next := tc.buildBlock(anchor.BlockRoot, 9, 0)
+	if err := ch.AddBlock(ctx, next); err != nil {
+		t.Fatal(err)
+	}
+	if head, err := ch.Head(); err != nil {
+		t.Fatal(err)
+	} else if root, _ := head.BlockRoot(); root != next.BlockRoot {
+		t.Fatal("expected new block to be head")
+	}
+
+	// the block must match the state
+	if _, err := NewCheckpointChain(tc.spec, state, mustSigned(t, blocks[3]), nil); err == nil {
+		t.Fatal("expected block mismatch error")
+	}
+	// the state must be at an epoch boundary
+	e7, _ := tc.chain.ByBlock(blocks[3].BlockRoot)
+	state7, err := e7.State(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewCheckpointChain(tc.spec, state7, mustSigned(t, blocks[3]), nil); err == nil {
+		t.Fatal("expected epoch boundary error")
+	}
+
+	// the state may be after empty slots following the block
+	late := tc.addBlock(anchor.BlockRoot, 14, 0)
+	emptyEntry, err := tc.chain.Towards(ctx, late.BlockRoot, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state16, err := emptyEntry.State(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	emptyCh, err := NewCheckpointChain(tc.spec, state16, mustSigned(t, late), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head, err := emptyCh.Head(); err != nil {
+		t.Fatal(err)
+	} else if root, _ := head.BlockRoot(); root != late.BlockRoot || head.Step() != common.AsStep(16, false) {
+		t.Fatalf("expected empty slot 16 after the checkpoint block, got %s at %s", root, head.Step())
+	}
+	if _, err := NewCheckpointChain(tc.spec, state16, mustSigned(t, anchor), nil); err == nil {
+		t.Fatal("expected block mismatch error for an older block")
+	}
+
+	epc, err := anchorEntry.EpochsContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gvr := tc.chain.Genesis().ValidatorsRoot
+	bf := NewBackfill(tc.spec, epc.ValidatorPubkeyCache, gvr, anchor.ParentRoot, anchor.Slot, 3)
+	if err := bf.AddBlock(blocks[2]); err == nil {
+		t.Fatal("expected out of order error")
+	}
+	bad := *blocks[3]
+	bad.Signature = blocks[2].Signature
+	if err := bf.AddBlock(&bad); err == nil {
+		t.Fatal("expected signature error")
+	}
+	for i := 3; i >= 0; i-- {
+		if bf.Done() {
+			t.Fatalf("unexpected early finish at block %d", i)
+		}
+		if err := bf.AddBlock(blocks[i]); err != nil {
+			t.Fatal(err)
+		}
+		// the block at slot 3 completes the backfill
+		if i == 1 {
+			break
+		}
+	}
+	if !bf.Done() {
+		t.Fatal("expected backfill to be done")
+	}
+	if bf.Next() != blocks[0].BlockRoot {
+		t.Fatal("expected block 1 to be next")
+	}
+	if err := bf.AddBlock(blocks[0]); err == nil {
+		t.Fatal("expected done error")
+	}
+}
+
+func mustSigned(t *testing.T, benv *common.BeaconBlockEnvelope) common.EnvelopeBuilder {
+	signed, err := beacon.EnvelopeToSignedBeaconBlock(benv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed.(common.EnvelopeBuilder)
+}
@@ -0,0 +1,530 @@
+package chain
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/protolambda/ztyp/codec"
+	"github.com/protolambda/ztyp/tree"
+
+	"github.com/protolambda/zrnt/eth2/beacon"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/db"
+	"github.com/protolambda/zrnt/eth2/forkchoice/proto"
+)
+
+type ColdChain interface {
+	// Start of the finalized range, inclusive. The first entry may be a post-block step.
+	Start() common.Step
+	// End of the finalized range, exclusive.
+	End() common.Step
+	// OnFinalizedEntry appends the next entry of the finalized chain.
+	// Entries must be added in order, without gaps. Entries before the End are ignored.
+	OnFinalizedEntry(ctx context.Context, entry *HotEntry) error
+	ByStateRoot(root common.Root) (entry beacon.ChainEntry, ok bool)
+	ByBlock(root common.Root) (entry beacon.ChainEntry, ok bool)
+	ByBlockSlot(root common.Root, slot common.Slot) (entry beacon.ChainEntry, ok bool)
+	// Get the entry at the given step. Return nil if there is no block but the slot exists.
+	ByCanonStep(step common.Step) (entry beacon.ChainEntry, ok bool)
+	Closest(fromBlockRoot common.Root, toSlot common.Slot) (entry beacon.ChainEntry, ok bool)
+	Iter() (beacon.ChainIter, error)
+	// Block retrieves a finalized block. Returns nil if the block is unknown.
+	Block(ctx context.Context, root common.Root) (*common.BeaconBlockEnvelope, error)
+}
+
+// Key prefixes of the finalized chain data in the KV store.
+var (
+	// meta -> start step, end step, genesis validators root, genesis time
+	coldMetaKey = []byte("meta")
+	// step/<step> -> block root, parent root, state root
+	coldStepPrefix = []byte("step/")
+	// stateroot/<root> -> step
+	coldStateRootPrefix = []byte("stateroot/")
+	// blockstep/<root> -> step
+	coldBlockStepPrefix = []byte("blockstep/")
+	// block/<root> -> signed block SSZ
+	coldBlockPrefix = []byte("block/")
+	// snapshot/<step> -> state SSZ
+	coldSnapshotPrefix = []byte("snapshot/")
+)
+
+func coldKey(prefix []byte, id []byte) []byte {
+	return append(append(make([]byte, 0, len(prefix)+len(id)), prefix...), id...)
+}
+
+func stepBytes(step common.Step) []byte {
+	var out [8]byte
+	binary.BigEndian.PutUint64(out[:], uint64(step))
+	return out[:]
+}
+
+// FinalizedChain is a ColdChain: a linear series of slot and block transitions, persisted in a KV store.
+// Blocks are stored by root, and by canonical slot.
+// Full states are only stored as periodic snapshots, other states are rebuilt by replaying blocks on the closest snapshot.
+type FinalizedChain struct {
+	sync.RWMutex
+	spec *common.Spec
+	kv   db.KV
+	// Full state snapshots are stored for the first entry, and for every empty-slot step at a multiple of this interval.
+	snapshotInterval common.Slot
+	start            common.Step
+	end              common.Step
+	genesis          beacon.GenesisInfo
+}
+
+var _ ColdChain = (*FinalizedChain)(nil)
+var _ EntrySink = (*FinalizedChain)(nil)
+
+// NewFinalizedChain opens the finalized chain in the KV store. The chain is empty if the store is new.
+// A state snapshot is stored every snapshotInterval slots, this trades storage for faster historical state access.
+func NewFinalizedChain(spec *common.Spec, kv db.KV, snapshotInterval common.Slot) (*FinalizedChain, error) {
+	if snapshotInterval == 0 {
+		return nil, errors.New("snapshot interval must not be zero")
+	}
+	c := &FinalizedChain{spec: spec, kv: kv, snapshotInterval: snapshotInterval}
+	meta, ok, err := kv.Get(coldMetaKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read finalized chain meta data: %w", err)
+	}
+	if ok {
+		if len(meta) != 8+8+32+8 {
+			return nil, fmt.Errorf("invalid finalized chain meta data length: %d", len(meta))
+		}
+		c.start = common.Step(binary.BigEndian.Uint64(meta[0:8]))
+		c.end = common.Step(binary.BigEndian.Uint64(meta[8:16]))
+		copy(c.genesis.ValidatorsRoot[:], meta[16:48])
+		c.genesis.Time = common.Timestamp(binary.BigEndian.Uint64(meta[48:56]))
+	}
+	return c, nil
+}
+
+func (c *FinalizedChain) Start() common.Step {
+	c.RLock()
+	defer c.RUnlock()
+	return c.start
+}
+
+func (c *FinalizedChain) End() common.Step {
+	c.RLock()
+	defer c.RUnlock()
+	return c.end
+}
+
+// Genesis returns the genesis info of the chain, it is zeroed if the chain is empty.
+func (c *FinalizedChain) Genesis() beacon.GenesisInfo {
+	c.RLock()
+	defer c.RUnlock()
+	return c.genesis
+}
+
+func (c *FinalizedChain) empty() bool {
+	return c.start == c.end
+}
+
+func (c *FinalizedChain) OnFinalizedEntry(ctx context.Context, entry *HotEntry) error {
+	c.Lock()
+	defer c.Unlock()
+	first := c.empty()
+	start, genesis := c.start, c.genesis
+	if !first {
+		if entry.step < c.end {
+			return nil
+		}
+		// Entries are consecutive, but an empty slot has no block step.
+		if entry.step != c.end && !(c.end.Block() && entry.step == c.end+1) {
+			return fmt.Errorf("finalized entry %s does not connect to the end of the finalized chain %s", entry.step, c.end)
+		}
+	} else {
+		genesisTime, err := entry.state.GenesisTime()
+		if err != nil {
+			return err
+		}
+		genesisValRoot, err := entry.state.GenesisValidatorsRoot()
+		if err != nil {
+			return err
+		}
+		genesis = beacon.GenesisInfo{Time: genesisTime, ValidatorsRoot: genesisValRoot}
+		start = entry.step
+	}
+
+	var record [3 * 32]byte
+	copy(record[0:32], entry.blockRoot[:])
+	copy(record[32:64], entry.parentRoot[:])
+	copy(record[64:96], entry.stateRoot[:])
+	if err := c.kv.Put(coldKey(coldStepPrefix, stepBytes(entry.step)), record[:]); err != nil {
+		return err
+	}
+	if err := c.kv.Put(coldKey(coldStateRootPrefix, entry.stateRoot[:]), stepBytes(entry.step)); err != nil {
+		return err
+	}
+	if entry.step.Block() {
+		if err := c.kv.Put(coldKey(coldBlockStepPrefix, entry.blockRoot[:]), stepBytes(entry.step)); err != nil {
+			return err
+		}
+		// The block of the anchor is not known.
+		if entry.block != nil {
+			block, err := beacon.EnvelopeToSignedBeaconBlock(entry.block)
+			if err != nil {
+				return err
+			}
+			var buf bytes.Buffer
+			if err := block.Serialize(c.spec, codec.NewEncodingWriter(&buf)); err != nil {
+				return fmt.Errorf("failed to encode block %s: %w", entry.blockRoot, err)
+			}
+			if err := c.kv.Put(coldKey(coldBlockPrefix, entry.blockRoot[:]), buf.Bytes()); err != nil {
+				return err
+			}
+		}
+	}
+	if first || (!entry.step.Block() && entry.step.Slot()%c.snapshotInterval == 0) {
+		var buf bytes.Buffer
+		if err := entry.state.Serialize(codec.NewEncodingWriter(&buf)); err != nil {
+			return fmt.Errorf("failed to encode state %s: %w", entry.stateRoot, err)
+		}
+		if err := c.kv.Put(coldKey(coldSnapshotPrefix, stepBytes(entry.step)), buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	// Update the range last, so the entry is only visible once everything is stored.
+	end := entry.step + 1
+	var meta [8 + 8 + 32 + 8]byte
+	binary.BigEndian.PutUint64(meta[0:8], uint64(start))
+	binary.BigEndian.PutUint64(meta[8:16], uint64(end))
+	copy(meta[16:48], genesis.ValidatorsRoot[:])
+	binary.BigEndian.PutUint64(meta[48:56], uint64(genesis.Time))
+	if err := c.kv.Put(coldMetaKey, meta[:]); err != nil {
+		return err
+	}
+	c.start, c.end, c.genesis = start, end, genesis
+	return nil
+}
+
+// OnPrunedEntry makes the finalized chain an EntrySink of a hot chain: canonical entries are appended, others are ignored.
+func (c *FinalizedChain) OnPrunedEntry(ctx context.Context, entry *HotEntry, canonical bool) error {
+	if !canonical {
+		return nil
+	}
+	return c.OnFinalizedEntry(ctx, entry)
+}
+
+// NodeSink adapts the finalized chain to a forkchoice node sink, for ProtoArray.OnPrune:
+// the entries of canonical pruned nodes are looked up, and moved into the finalized chain.
+func (c *FinalizedChain) NodeSink(entries func(ref common.NodeRef) (*HotEntry, bool)) proto.NodeSink {
+	return proto.NodeSinkFn(func(ctx context.Context, ref common.NodeRef, canonical bool) error {
+		if !canonical {
+			return nil
+		}
+		entry, ok := entries(ref)
+		if !ok {
+			return fmt.Errorf("missing entry for pruned node %s", ref)
+		}
+		return c.OnFinalizedEntry(ctx, entry)
+	})
+}
+
+// ColdEntry is an entry of the finalized chain. The state is rebuilt when requested.
+type ColdEntry struct {
+	chain      *FinalizedChain
+	step       common.Step
+	blockRoot  common.Root
+	parentRoot common.Root
+	stateRoot  common.Root
+}
+
+var _ beacon.ChainEntry = (*ColdEntry)(nil)
+
+func (e *ColdEntry) Step() common.Step {
+	return e.step
+}
+
+func (e *ColdEntry) BlockRoot() (common.Root, error) {
+	return e.blockRoot, nil
+}
+
+func (e *ColdEntry) ParentRoot() (common.Root, error) {
+	return e.parentRoot, nil
+}
+
+func (e *ColdEntry) StateRoot() (common.Root, error) {
+	return e.stateRoot, nil
+}
+
+func (e *ColdEntry) EpochsContext(ctx context.Context) (*common.EpochsContext, error) {
+	state, err := e.State(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return common.NewEpochsContext(e.chain.spec, state)
+}
+
+// State rebuilds the state by replaying blocks on top of the closest snapshot before the entry.
+func (e *ColdEntry) State(ctx context.Context) (common.BeaconState, error) {
+	state, err := e.chain.stateAt(ctx, e.step)
+	if err != nil {
+		return nil, err
+	}
+	if root := state.HashTreeRoot(tree.GetHashFn()); root != e.stateRoot {
+		return nil, fmt.Errorf("rebuilt state of %s has root %s, expected %s", e.step, root, e.stateRoot)
+	}
+	return state, nil
+}
+
+// entry loads the entry of the given step, the caller must hold the lock.
+func (c *FinalizedChain) entry(step common.Step) (*ColdEntry, bool, error) {
+	if step < c.start || step >= c.end {
+		return nil, false, nil
+	}
+	record, ok, err := c.kv.Get(coldKey(coldStepPrefix, stepBytes(step)))
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	if len(record) != 3*32 {
+		return nil, false, fmt.Errorf("invalid record of step %s", step)
+	}
+	e := &ColdEntry{chain: c, step: step}
+	copy(e.blockRoot[:], record[0:32])
+	copy(e.parentRoot[:], record[32:64])
+	copy(e.stateRoot[:], record[64:96])
+	return e, true, nil
+}
+
+// stepOf loads the step that is stored under the given key, the caller must hold the lock.
+func (c *FinalizedChain) stepOf(key []byte) (common.Step, bool) {
+	v, ok, err := c.kv.Get(key)
+	if err != nil || !ok || len(v) != 8 {
+		return 0, false
+	}
+	return common.Step(binary.BigEndian.Uint64(v)), true
+}
+
+func (c *FinalizedChain) ByStateRoot(root common.Root) (entry beacon.ChainEntry, ok bool) {
+	c.RLock()
+	defer c.RUnlock()
+	step, ok := c.stepOf(coldKey(coldStateRootPrefix, root[:]))
+	if !ok {
+		return nil, false
+	}
+	e, ok, err := c.entry(step)
+	if err != nil || !ok {
+		return nil, false
+	}
+	return e, true
+}
+
+func (c *FinalizedChain) ByBlock(root common.Root) (entry beacon.ChainEntry, ok bool) {
+	c.RLock()
+	defer c.RUnlock()
+	step, ok := c.stepOf(coldKey(coldBlockStepPrefix, root[:]))
+	if !ok {
+		return nil, false
+	}
+	e, ok, err := c.entry(step)
+	if err != nil || !ok {
+		return nil, false
+	}
+	return e, true
+}
+
+func (c *FinalizedChain) ByBlockSlot(root common.Root, slot common.Slot) (entry beacon.ChainEntry, ok bool) {
+	c.RLock()
+	defer c.RUnlock()
+	// The block itself, or the empty slot, with the same latest block root.
+	for _, step := range []common.Step{common.AsStep(slot, true), common.AsStep(slot, false)} {
+		e, ok, err := c.entry(step)
+		if err != nil {
+			return nil, false
+		}
+		if ok && e.blockRoot == root {
+			return e, true
+		}
+	}
+	return nil, false
+}
+
+func (c *FinalizedChain) ByCanonStep(step common.Step) (entry beacon.ChainEntry, ok bool) {
+	c.RLock()
+	defer c.RUnlock()
+	e, ok, err := c.entry(step)
+	if err != nil {
+		return nil, false
+	}
+	if !ok {
+		// a slot without block is still within the chain
+		if step.Block() && step >= c.start && step < c.end {
+			return nil, true
+		}
+		return nil, false
+	}
+	return e, true
+}
+
+func (c *FinalizedChain) Closest(fromBlockRoot common.Root, toSlot common.Slot) (entry beacon.ChainEntry, ok bool) {
+	c.RLock()
+	defer c.RUnlock()
+	blockStep, ok := c.stepOf(coldKey(coldBlockStepPrefix, fromBlockRoot[:]))
+	if !ok || blockStep.Slot() > toSlot {
+		return nil, false
+	}
+	// The empty slots after the block all have the block root, binary search for the last one up to toSlot.
+	min := blockStep
+	lo, hi := blockStep.Slot()+1, toSlot+1
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		e, ok, err := c.entry(common.AsStep(mid, false))
+		if err != nil {
+			return nil, false
+		}
+		if ok && e.blockRoot == fromBlockRoot {
+			min = e.step
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	e, ok, err := c.entry(min)
+	if err != nil || !ok {
+		return nil, false
+	}
+	return e, true
+}
+
+func (c *FinalizedChain) Iter() (beacon.ChainIter, error) {
+	c.RLock()
+	defer c.RUnlock()
+	return &coldChainIter{chain: c, start: c.start, end: c.end}, nil
+}
+
+func (c *FinalizedChain) Block(ctx context.Context, root common.Root) (*common.BeaconBlockEnvelope, error) {
+	c.RLock()
+	defer c.RUnlock()
+	return c.block(root)
+}
+
+// block loads the block, the caller must hold the lock.
+func (c *FinalizedChain) block(root common.Root) (*common.BeaconBlockEnvelope, error) {
+	data, ok, err := c.kv.Get(coldKey(coldBlockPrefix, root[:]))
+	if err != nil || !ok {
+		return nil, err
+	}
+	block, _, err := beacon.DecodeSignedBlock(c.spec, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode block %s: %w", root, err)
+	}
+	benv := block.Envelope(c.spec, common.ForkDigest{})
+	benv.ForkDigest = common.ComputeForkDigest(c.spec.ForkVersion(benv.Slot), c.genesis.ValidatorsRoot)
+	return benv, nil
+}
+
+// stateAt rebuilds the state at the given step.
+func (c *FinalizedChain) stateAt(ctx context.Context, target common.Step) (common.BeaconState, error) {
+	c.RLock()
+	defer c.RUnlock()
+	if target < c.start || target >= c.end {
+		return nil, fmt.Errorf("step %s is outside of finalized range [%s, %s)", target, c.start, c.end)
+	}
+	// Find the closest snapshot: at an interval slot, or else the first entry of the chain.
+	snapshot := c.start
+	for slot := target.Slot() - target.Slot()%c.snapshotInterval; ; slot -= c.snapshotInterval {
+		step := common.AsStep(slot, false)
+		if step <= c.start {
+			break
+		}
+		if step <= target {
+			if ok, err := c.kv.Has(coldKey(coldSnapshotPrefix, stepBytes(step))); err != nil {
+				return nil, err
+			} else if ok {
+				snapshot = step
+				break
+			}
+		}
+		if slot < c.snapshotInterval {
+			break
+		}
+	}
+	data, ok, err := c.kv.Get(coldKey(coldSnapshotPrefix, stepBytes(snapshot)))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("missing state snapshot at %s", snapshot)
+	}
+	state, _, err := beacon.DecodeState(c.spec, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode state snapshot at %s: %w", snapshot, err)
+	}
+	epc, err := common.NewEpochsContext(c.spec, state)
+	if err != nil {
+		return nil, err
+	}
+	upgradeable := &beacon.StandardUpgradeableBeaconState{BeaconState: state}
+	for step := snapshot; step < target; {
+		slot := step.Slot()
+		if !step.Block() {
+			e, ok, err := c.entry(common.AsStep(slot, true))
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				benv, err := c.block(e.blockRoot)
+				if err != nil {
+					return nil, err
+				}
+				if benv == nil {
+					return nil, fmt.Errorf("missing block %s at slot %d", e.blockRoot, slot)
+				}
+				if err := common.PostSlotTransition(ctx, c.spec, epc, upgradeable.BeaconState, benv, false); err != nil {
+					return nil, fmt.Errorf("failed to replay block %s: %w", e.blockRoot, err)
+				}
+				step = e.step
+				continue
+			}
+		}
+		if err := common.ProcessSlots(ctx, c.spec, epc, upgradeable, slot+1); err != nil {
+			return nil, err
+		}
+		step = common.AsStep(slot+1, false)
+	}
+	return upgradeable.BeaconState, nil
+}
+
+// Close closes the underlying KV store.
+func (c *FinalizedChain) Close() error {
+	return c.kv.Close()
+}
+
+type coldChainIter struct {
+	chain *FinalizedChain
+	start common.Step
+	end   common.Step
+}
+
+func (it *coldChainIter) Start() common.Step {
+	return it.start
+}
+
+func (it *coldChainIter) End() common.Step {
+	return it.end
+}
+
+func (it *coldChainIter) Entry(step common.Step) (entry beacon.ChainEntry, err error) {
+	if step < it.start || step >= it.end {
+		return nil, fmt.Errorf("%w: %s, range is [%s, %s)", errOutOfRange, step, it.start, it.end)
+	}
+	it.chain.RLock()
+	defer it.chain.RUnlock()
+	e, ok, err := it.chain.entry(step)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if step.Block() {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("missing entry at %s", step)
+	}
+	return e, nil
+}
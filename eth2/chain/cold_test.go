@@ -0,0 +1,131 @@
+package chain
+
+import (
+	"context"
+	"testing"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/db"
+)
+
+func TestFinalizedChain(t *testing.T) {
+	ctx := context.Background()
+	tc := newTestChain(t, 64)
+	genesis, err := tc.chain.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent, _ := genesis.BlockRoot()
+	blocks := make(map[common.Slot]*common.BeaconBlockEnvelope)
+	for _, slot := range []common.Slot{1, 2, 4, 5, 7} {
+		blocks[slot] = tc.addBlock(parent, slot, 0)
+		parent = blocks[slot].BlockRoot
+	}
+	if _, err := tc.chain.Towards(ctx, parent, 9); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	kv, err := db.NewFileKV(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cold, err := NewFinalizedChain(tc.spec, kv, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// move the canonical hot entries into the finalized chain, like pruning would.
+	hotIter, err := tc.chain.Iter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for step := hotIter.Start(); step < hotIter.End(); step++ {
+		e, err := hotIter.Entry(step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e == nil {
+			continue
+		}
+		if err := cold.OnPrunedEntry(ctx, e.(*HotEntry), true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cold.Start() != common.AsStep(0, true) || cold.End() != common.AsStep(9, false)+1 {
+		t.Fatalf("unexpected finalized range [%s, %s)", cold.Start(), cold.End())
+	}
+
+	// reopen, to check that everything is persisted
+	kv, err = db.NewFileKV(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cold, err = NewFinalizedChain(tc.spec, kv, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cold.End() != common.AsStep(9, false)+1 {
+		t.Fatalf("unexpected end %s after reopening", cold.End())
+	}
+
+	// states are rebuilt from snapshots, and checked against the state root of the entry.
+	for _, step := range []common.Step{common.AsStep(3, false), common.AsStep(5, true), common.AsStep(6, false), common.AsStep(9, false)} {
+		e, ok := cold.ByCanonStep(step)
+		if !ok || e == nil {
+			t.Fatalf("missing entry at %s", step)
+		}
+		hot, _ := tc.chain.ByStateRoot(mustStateRoot(t, e))
+		if hot == nil || hot.Step() != step {
+			t.Fatalf("finalized entry at %s does not match hot entry", step)
+		}
+		state, err := e.State(ctx)
+		if err != nil {
+			t.Fatalf("failed to rebuild state at %s: %v", step, err)
+		}
+		if slot, _ := state.Slot(); slot != step.Slot() {
+			t.Fatalf("unexpected state slot %d at %s", slot, step)
+		}
+	}
+
+	if e, ok := cold.ByCanonStep(common.AsStep(3, true)); !ok || e != nil {
+		t.Fatal("expected empty slot 3")
+	}
+	if e, ok := cold.ByBlock(blocks[5].BlockRoot); !ok || e.Step() != common.AsStep(5, true) {
+		t.Fatal("expected block at slot 5")
+	}
+	if e, ok := cold.ByBlockSlot(blocks[5].BlockRoot, 6); !ok || e.Step() != common.AsStep(6, false) {
+		t.Fatal("expected empty slot 6 after block 5")
+	}
+	if e, ok := cold.Closest(blocks[5].BlockRoot, 8); !ok || e.Step() != common.AsStep(7, false) {
+		t.Fatal("expected closest entry to block 5 to be the pre-block node of slot 7")
+	}
+	if e, ok := cold.ByStateRoot(blocks[7].StateRoot); !ok || e.Step() != common.AsStep(7, true) {
+		t.Fatal("expected block 7 by state root")
+	}
+	benv, err := cold.Block(ctx, blocks[4].BlockRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if benv == nil || benv.BlockRoot != blocks[4].BlockRoot || benv.ForkDigest != blocks[4].ForkDigest || benv.Signature != blocks[4].Signature {
+		t.Fatal("block was not stored correctly")
+	}
+
+	iter, err := cold.Iter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, err := iter.Entry(common.AsStep(2, true)); err != nil || e == nil {
+		t.Fatalf("expected block at slot 2: %v", err)
+	}
+	if _, err := iter.Entry(common.AsStep(10, false)); err == nil {
+		t.Fatal("expected out of range error")
+	}
+}
+
+func mustStateRoot(t *testing.T, e interface{ StateRoot() (common.Root, error) }) common.Root {
+	root, err := e.StateRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
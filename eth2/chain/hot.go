@@ -0,0 +1,674 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/protolambda/ztyp/tree"
+
+	"github.com/protolambda/zrnt/eth2/beacon"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/forkchoice"
+	"github.com/protolambda/zrnt/eth2/forkchoice/proto"
+)
+
+// HotEntry is a node of the unfinalized chain: the state after processing a slot, with or without the block of the slot.
+// The states of entries share most of their data with each other, a copy is cheap.
+type HotEntry struct {
+	step       common.Step
+	blockRoot  common.Root
+	parentRoot common.Root
+	stateRoot  common.Root
+	epc        *common.EpochsContext
+	state      common.BeaconState
+	// nil if the entry is an empty slot, or if the entry is the anchor of the chain.
+	block *common.BeaconBlockEnvelope
+}
+
+var _ beacon.ChainEntry = (*HotEntry)(nil)
+
+func (e *HotEntry) Step() common.Step {
+	return e.step
+}
+
+func (e *HotEntry) BlockRoot() (common.Root, error) {
+	return e.blockRoot, nil
+}
+
+func (e *HotEntry) ParentRoot() (common.Root, error) {
+	return e.parentRoot, nil
+}
+
+func (e *HotEntry) StateRoot() (common.Root, error) {
+	return e.stateRoot, nil
+}
+
+func (e *HotEntry) EpochsContext(ctx context.Context) (*common.EpochsContext, error) {
+	return e.epc.Clone(), nil
+}
+
+// State returns a copy of the state, the entry itself is not affected by changes to the copy.
+func (e *HotEntry) State(ctx context.Context) (common.BeaconState, error) {
+	return e.state.CopyState()
+}
+
+// Block returns the block that was processed to get to this entry, or nil if this entry is an empty slot.
+// The anchor of the chain has no block either.
+func (e *HotEntry) Block() *common.BeaconBlockEnvelope {
+	return e.block
+}
+
+// EntrySink receives the entries that are pruned from the hot chain, oldest first.
+// Canonical entries are part of the finalized chain, others are orphaned.
+// The sink is called while the chain is being updated, it must not call back into the chain.
+type EntrySink interface {
+	// OnPrunedEntry is called for every pruned entry.
+	// If it errors, the entry and any later entries are kept in the hot chain.
+	OnPrunedEntry(ctx context.Context, entry *HotEntry, canonical bool) error
+}
+
+type EntrySinkFn func(ctx context.Context, entry *HotEntry, canonical bool) error
+
+func (fn EntrySinkFn) OnPrunedEntry(ctx context.Context, entry *HotEntry, canonical bool) error {
+	return fn(ctx, entry, canonical)
+}
+
+type HotChain interface {
+	beacon.Chain
+	// AddBlock runs the state transition of the block on top of its parent, and adds the block to the chain.
+	// Any empty slots between the parent and the block are added as well.
+	// The justified and finalized checkpoints are updated with the post-state of the block.
+	// The block is registered as arriving at the time of the last tick, see OnTick.
+	AddBlock(ctx context.Context, benv *common.BeaconBlockEnvelope) error
+	// OnTick moves the chain to the current time, the forkchoice is updated at every new slot.
+	OnTick(ctx context.Context, now time.Time) error
+	// AddVote registers the latest vote of a validator with the forkchoice.
+	// Only votes with a newer target epoch than the previous vote of the validator are used.
+	AddVote(index common.ValidatorIndex, blockRoot common.Root, headSlot common.Slot, targetEpoch common.Epoch) (ok bool)
+}
+
+// UnfinalizedChain is a HotChain that keeps all states in memory,
+// and relies on the data-sharing of the state trees to avoid duplication.
+type UnfinalizedChain struct {
+	sync.RWMutex
+	spec       *common.Spec
+	fc         forkchoice.Forkchoice
+	sink       EntrySink
+	genesis    beacon.GenesisInfo
+	entries    map[common.NodeRef]*HotEntry
+	stateRoots map[common.Root]common.NodeRef
+	// slot of every block in the chain
+	blockSlots map[common.Root]common.Slot
+	// time of the last tick
+	now time.Time
+}
+
+var _ HotChain = (*UnfinalizedChain)(nil)
+
+// NewUnfinalizedChain starts a chain at the given anchor state.
+// The anchor state may be a post-block state, or a state after empty slots.
+// The anchor stands in for the justified and finalized checkpoint of the anchor state,
+// the forkchoice is pinned to the anchor until the chain finalizes a checkpoint.
+// The sink is optional, pruned entries are discarded if it is nil.
+func NewUnfinalizedChain(spec *common.Spec, anchor common.BeaconState, sink EntrySink) (*UnfinalizedChain, error) {
+	epc, err := common.NewEpochsContext(spec, anchor)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create epochs context of anchor: %w", err)
+	}
+	return newUnfinalizedChain(spec, anchor, epc, nil, sink)
+}
+
+// newUnfinalizedChain starts a chain at the anchor state, the block is optional and must match the state.
+func newUnfinalizedChain(spec *common.Spec, anchor common.BeaconState, epc *common.EpochsContext,
+	block *common.BeaconBlockEnvelope, sink EntrySink) (*UnfinalizedChain, error) {
+	slot, err := anchor.Slot()
+	if err != nil {
+		return nil, err
+	}
+	header, err := anchor.LatestBlockHeader()
+	if err != nil {
+		return nil, err
+	}
+	stateRoot := anchor.HashTreeRoot(tree.GetHashFn())
+	if header.StateRoot == (common.Root{}) {
+		header.StateRoot = stateRoot
+	}
+	blockRoot := header.HashTreeRoot(tree.GetHashFn())
+	justified, err := anchor.CurrentJustifiedCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+	finalized, err := anchor.FinalizedCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+	genesisTime, err := anchor.GenesisTime()
+	if err != nil {
+		return nil, err
+	}
+	genesisValRoot, err := anchor.GenesisValidatorsRoot()
+	if err != nil {
+		return nil, err
+	}
+	entry := &HotEntry{
+		step:       common.AsStep(slot, header.Slot == slot),
+		blockRoot:  blockRoot,
+		parentRoot: header.ParentRoot,
+		stateRoot:  stateRoot,
+		epc:        epc,
+		state:      anchor,
+	}
+	if header.Slot == slot {
+		entry.block = block
+	} else {
+		// An anchor after empty slots is represented as a slot node: the parent root equals the block root.
+		entry.parentRoot = blockRoot
+	}
+	c := &UnfinalizedChain{
+		spec:       spec,
+		sink:       sink,
+		genesis:    beacon.GenesisInfo{Time: genesisTime, ValidatorsRoot: genesisValRoot},
+		entries:    make(map[common.NodeRef]*HotEntry),
+		stateRoots: make(map[common.Root]common.NodeRef),
+		blockSlots: make(map[common.Root]common.Slot),
+		now:        time.Unix(int64(genesisTime+common.Timestamp(slot)*spec.SECONDS_PER_SLOT), 0),
+	}
+	c.putEntry(entry)
+	c.blockSlots[blockRoot] = header.Slot
+	finalizedCp := common.Checkpoint{Epoch: finalized.Epoch, Root: blockRoot}
+	justifiedCp := common.Checkpoint{Epoch: justified.Epoch, Root: blockRoot}
+	graph := proto.NewProtoArray(spec, entry.parentRoot, blockRoot, slot, justifiedCp.Epoch, finalizedCp.Epoch, proto.NodeSinkFn(c.onPrunedNode))
+	c.fc, err = forkchoice.NewForkChoice(spec, finalizedCp, justifiedCp, blockRoot, slot,
+		graph, proto.NewProtoVoteStore(spec), activeBalances(epc))
+	if err != nil {
+		return nil, fmt.Errorf("failed to start forkchoice at anchor: %w", err)
+	}
+	return c, nil
+}
+
+// ForkChoice returns the forkchoice that drives the chain.
+func (c *UnfinalizedChain) ForkChoice() forkchoice.Forkchoice {
+	return c.fc
+}
+
+func (c *UnfinalizedChain) putEntry(entry *HotEntry) {
+	ref := common.NodeRef{Root: entry.blockRoot, Slot: entry.step.Slot()}
+	c.entries[ref] = entry
+	c.stateRoots[entry.stateRoot] = ref
+}
+
+// onPrunedNode is called by the forkchoice, only during UpdateJustified, while the chain is locked for writing.
+func (c *UnfinalizedChain) onPrunedNode(ctx context.Context, ref common.NodeRef, canonical bool) error {
+	entry, ok := c.entries[ref]
+	if !ok {
+		return nil
+	}
+	if c.sink != nil {
+		if err := c.sink.OnPrunedEntry(ctx, entry, canonical); err != nil {
+			return err
+		}
+	}
+	delete(c.entries, ref)
+	delete(c.stateRoots, entry.stateRoot)
+	if entry.step.Block() {
+		delete(c.blockSlots, entry.blockRoot)
+	}
+	return nil
+}
+
+func (c *UnfinalizedChain) ByStateRoot(root common.Root) (entry beacon.ChainEntry, ok bool) {
+	c.RLock()
+	defer c.RUnlock()
+	ref, ok := c.stateRoots[root]
+	if !ok {
+		return nil, false
+	}
+	return c.entries[ref], true
+}
+
+func (c *UnfinalizedChain) ByBlock(root common.Root) (entry beacon.ChainEntry, ok bool) {
+	c.RLock()
+	defer c.RUnlock()
+	slot, ok := c.blockSlots[root]
+	if !ok {
+		return nil, false
+	}
+	e, ok := c.entries[common.NodeRef{Root: root, Slot: slot}]
+	if !ok {
+		return nil, false
+	}
+	return e, true
+}
+
+func (c *UnfinalizedChain) ByBlockSlot(root common.Root, slot common.Slot) (entry beacon.ChainEntry, ok bool) {
+	c.RLock()
+	defer c.RUnlock()
+	e, ok := c.entries[common.NodeRef{Root: root, Slot: slot}]
+	if !ok {
+		return nil, false
+	}
+	return e, true
+}
+
+// anchor returns the node that the chain currently starts from, the caller must hold the lock.
+func (c *UnfinalizedChain) anchor() (common.NodeRef, error) {
+	fin := c.fc.Finalized()
+	slot, ok := c.fc.GetSlot(fin.Root)
+	if !ok {
+		return common.NodeRef{}, fmt.Errorf("finalized block %s is unknown", fin.Root)
+	}
+	return common.NodeRef{Root: fin.Root, Slot: slot}, nil
+}
+
+func (c *UnfinalizedChain) Search(parentRoot *common.Root, slot *common.Slot) ([]beacon.SearchEntry, error) {
+	c.RLock()
+	defer c.RUnlock()
+	anchor, err := c.anchor()
+	if err != nil {
+		return nil, err
+	}
+	nonCanon, canon, err := c.fc.Search(anchor, parentRoot, slot)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]beacon.SearchEntry, 0, len(nonCanon)+len(canon))
+	for _, ref := range canon {
+		if e, ok := c.entries[ref]; ok {
+			out = append(out, beacon.SearchEntry{ChainEntry: e, Canonical: true})
+		}
+	}
+	for _, ref := range nonCanon {
+		if e, ok := c.entries[ref]; ok {
+			out = append(out, beacon.SearchEntry{ChainEntry: e, Canonical: false})
+		}
+	}
+	return out, nil
+}
+
+func (c *UnfinalizedChain) Closest(fromBlockRoot common.Root, toSlot common.Slot) (entry beacon.ChainEntry, ok bool) {
+	c.RLock()
+	defer c.RUnlock()
+	ref, err := c.fc.ClosestToSlot(fromBlockRoot, toSlot)
+	if err != nil {
+		return nil, false
+	}
+	e, ok := c.entries[ref]
+	if !ok {
+		return nil, false
+	}
+	return e, true
+}
+
+func (c *UnfinalizedChain) InSubtree(anchor common.Root, root common.Root) (unknown bool, inSubtree bool) {
+	return c.fc.InSubtree(anchor, root)
+}
+
+func (c *UnfinalizedChain) ByCanonStep(step common.Step) (entry beacon.ChainEntry, ok bool) {
+	c.RLock()
+	defer c.RUnlock()
+	anchor, err := c.anchor()
+	if err != nil || step.Slot() < anchor.Slot {
+		return nil, false
+	}
+	ref, err := c.fc.CanonAtSlot(anchor.Root, step.Slot(), step.Block())
+	if err != nil {
+		return nil, false
+	}
+	if ref == (common.NodeRef{}) {
+		// the slot is empty, there is no block
+		return nil, true
+	}
+	e, ok := c.entries[ref]
+	if !ok {
+		return nil, false
+	}
+	return e, true
+}
+
+func (c *UnfinalizedChain) Iter() (beacon.ChainIter, error) {
+	c.RLock()
+	defer c.RUnlock()
+	anchor, err := c.anchor()
+	if err != nil {
+		return nil, err
+	}
+	anchorEntry, ok := c.entries[anchor]
+	if !ok {
+		return nil, fmt.Errorf("missing entry for anchor %s", anchor)
+	}
+	head, _, err := c.fc.FindHead(anchor.Root, anchor.Slot)
+	if err != nil {
+		return nil, err
+	}
+	headEntry, ok := c.entries[head]
+	if !ok {
+		return nil, fmt.Errorf("missing entry for head %s", head)
+	}
+	return &hotChainIter{
+		chain:  c,
+		anchor: anchor,
+		start:  anchorEntry.step,
+		end:    headEntry.step + 1,
+	}, nil
+}
+
+func (c *UnfinalizedChain) JustifiedCheckpoint() common.Checkpoint {
+	return c.fc.Justified()
+}
+
+func (c *UnfinalizedChain) FinalizedCheckpoint() common.Checkpoint {
+	return c.fc.Finalized()
+}
+
+// checkpointEntry returns the closest known entry to the start of the checkpoint epoch, the caller must hold the lock.
+// If the block of the checkpoint is later than the epoch start (i.e. the chain anchor), the block entry is returned.
+func (c *UnfinalizedChain) checkpointEntry(cp common.Checkpoint) (*HotEntry, error) {
+	startSlot, err := c.spec.EpochStartSlot(cp.Epoch)
+	if err != nil {
+		return nil, err
+	}
+	ref, err := c.fc.ClosestToSlot(cp.Root, startSlot)
+	if err != nil {
+		slot, ok := c.blockSlots[cp.Root]
+		if !ok || slot < startSlot {
+			return nil, fmt.Errorf("cannot find checkpoint %s: %w", cp, err)
+		}
+		ref = common.NodeRef{Root: cp.Root, Slot: slot}
+	}
+	e, ok := c.entries[ref]
+	if !ok {
+		return nil, fmt.Errorf("missing entry for checkpoint %s", cp)
+	}
+	return e, nil
+}
+
+func (c *UnfinalizedChain) Justified() (beacon.ChainEntry, error) {
+	c.RLock()
+	defer c.RUnlock()
+	return c.checkpointEntry(c.fc.Justified())
+}
+
+func (c *UnfinalizedChain) Finalized() (beacon.ChainEntry, error) {
+	c.RLock()
+	defer c.RUnlock()
+	return c.checkpointEntry(c.fc.Finalized())
+}
+
+func (c *UnfinalizedChain) Head() (beacon.ChainEntry, error) {
+	c.RLock()
+	defer c.RUnlock()
+	ref, err := c.fc.Head()
+	if err != nil {
+		return nil, err
+	}
+	e, ok := c.entries[ref]
+	if !ok {
+		return nil, fmt.Errorf("missing entry for head %s", ref)
+	}
+	return e, nil
+}
+
+func (c *UnfinalizedChain) Towards(ctx context.Context, fromBlockRoot common.Root, toSlot common.Slot) (beacon.ChainEntry, error) {
+	c.Lock()
+	defer c.Unlock()
+	return c.towards(ctx, fromBlockRoot, toSlot)
+}
+
+// towards processes empty slots from the closest entry to the slot, the caller must hold the lock for writing.
+func (c *UnfinalizedChain) towards(ctx context.Context, fromBlockRoot common.Root, toSlot common.Slot) (*HotEntry, error) {
+	ref, err := c.fc.ClosestToSlot(fromBlockRoot, toSlot)
+	if err != nil {
+		return nil, err
+	}
+	e, ok := c.entries[ref]
+	if !ok {
+		return nil, fmt.Errorf("missing entry for %s", ref)
+	}
+	if ref.Slot == toSlot {
+		return e, nil
+	}
+	return c.processSlots(ctx, e, toSlot)
+}
+
+// processSlots adds an entry for every empty slot after the given entry, up to and including toSlot.
+// The caller must hold the lock for writing. Returns the last entry.
+func (c *UnfinalizedChain) processSlots(ctx context.Context, from *HotEntry, toSlot common.Slot) (*HotEntry, error) {
+	state, err := from.state.CopyState()
+	if err != nil {
+		return nil, err
+	}
+	epc := from.epc.Clone()
+	upgradeable := &beacon.StandardUpgradeableBeaconState{BeaconState: state}
+	entry := from
+	for slot := from.step.Slot() + 1; slot <= toSlot; slot++ {
+		if err := common.ProcessSlots(ctx, c.spec, epc, upgradeable, slot); err != nil {
+			return nil, err
+		}
+		// keep a copy, the next slot processing continues with the original.
+		slotState, err := upgradeable.CopyState()
+		if err != nil {
+			return nil, err
+		}
+		justified, err := slotState.CurrentJustifiedCheckpoint()
+		if err != nil {
+			return nil, err
+		}
+		finalized, err := slotState.FinalizedCheckpoint()
+		if err != nil {
+			return nil, err
+		}
+		entry = &HotEntry{
+			step:       common.AsStep(slot, false),
+			blockRoot:  from.blockRoot,
+			parentRoot: from.blockRoot,
+			stateRoot:  slotState.HashTreeRoot(tree.GetHashFn()),
+			epc:        epc.Clone(),
+			state:      slotState,
+		}
+		c.putEntry(entry)
+		c.fc.ProcessSlot(from.blockRoot, slot, justified.Epoch, finalized.Epoch)
+	}
+	return entry, nil
+}
+
+func (c *UnfinalizedChain) Genesis() beacon.GenesisInfo {
+	return c.genesis
+}
+
+func (c *UnfinalizedChain) AddBlock(ctx context.Context, benv *common.BeaconBlockEnvelope) error {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.blockSlots[benv.BlockRoot]; ok {
+		return nil
+	}
+	parentSlot, ok := c.blockSlots[benv.ParentRoot]
+	if !ok {
+		return fmt.Errorf("unknown parent block %s", benv.ParentRoot)
+	}
+	if parentSlot >= benv.Slot {
+		return fmt.Errorf("block slot %d is not after parent slot %d", benv.Slot, parentSlot)
+	}
+	pre, err := c.towards(ctx, benv.ParentRoot, benv.Slot)
+	if err != nil {
+		return fmt.Errorf("failed to process slots up to block: %w", err)
+	}
+	state, err := pre.state.CopyState()
+	if err != nil {
+		return err
+	}
+	epc := pre.epc.Clone()
+	if err := common.PostSlotTransition(ctx, c.spec, epc, state, benv, true); err != nil {
+		return fmt.Errorf("failed to process block %s: %w", benv.BlockRoot, err)
+	}
+	justified, err := state.CurrentJustifiedCheckpoint()
+	if err != nil {
+		return err
+	}
+	finalized, err := state.FinalizedCheckpoint()
+	if err != nil {
+		return err
+	}
+	unrealizedJustified, unrealizedFinalized, err := beacon.ComputeUnrealizedCheckpoints(ctx, c.spec, epc, state)
+	if err != nil {
+		return fmt.Errorf("failed to compute unrealized checkpoints of block %s: %w", benv.BlockRoot, err)
+	}
+	// The block is only registered once the forkchoice accepted it, a rejected block can be retried.
+	if !c.fc.ProcessBlock(benv.ParentRoot, benv.BlockRoot, benv.Slot, justified.Epoch, finalized.Epoch,
+		unrealizedJustified, unrealizedFinalized) {
+		return fmt.Errorf("forkchoice rejected block %s", benv.BlockRoot)
+	}
+	c.putEntry(&HotEntry{
+		step:       common.AsStep(benv.Slot, true),
+		blockRoot:  benv.BlockRoot,
+		parentRoot: benv.ParentRoot,
+		stateRoot:  benv.StateRoot,
+		epc:        epc,
+		state:      state,
+		block:      benv,
+	})
+	c.blockSlots[benv.BlockRoot] = benv.Slot
+	currentSlot := c.currentSlot()
+	c.fc.BlockArrival(benv.BlockRoot, currentSlot, c.intoSlot())
+	if err := c.updateCheckpoints(ctx, benv.BlockRoot, justified, finalized); err != nil {
+		return err
+	}
+	// Blocks from previous epochs are pulled up immediately.
+	if c.spec.SlotToEpoch(benv.Slot) < c.spec.SlotToEpoch(currentSlot) {
+		return c.updateCheckpoints(ctx, benv.BlockRoot, unrealizedJustified, unrealizedFinalized)
+	}
+	return nil
+}
+
+// OnTick moves the chain to the given time: the forkchoice is updated when the time reaches a new slot.
+// Blocks are registered as arriving at the time of the last tick, to apply the proposer boost to timely blocks.
+func (c *UnfinalizedChain) OnTick(ctx context.Context, now time.Time) error {
+	c.Lock()
+	defer c.Unlock()
+	if !now.After(c.now) {
+		return nil
+	}
+	prevSlot := c.currentSlot()
+	c.now = now
+	currentSlot := c.currentSlot()
+	if currentSlot <= prevSlot {
+		return nil
+	}
+	// At the start of an epoch the forkchoice may pull up the justified checkpoint.
+	// Its state is prepared before the tick, since slot processing updates the forkchoice.
+	var cp common.Checkpoint
+	var balances []common.Gwei
+	if c.spec.SlotToEpoch(currentSlot) > c.spec.SlotToEpoch(prevSlot) {
+		if cp = c.fc.UnrealizedJustified(); cp.Epoch > c.fc.Justified().Epoch {
+			startSlot, err := c.spec.EpochStartSlot(cp.Epoch)
+			if err != nil {
+				return err
+			}
+			cpEntry, err := c.towards(ctx, cp.Root, startSlot)
+			if err != nil {
+				return fmt.Errorf("failed to get unrealized justified checkpoint state: %w", err)
+			}
+			balances = activeBalances(cpEntry.epc)
+		}
+	}
+	return c.fc.ProcessTick(ctx, currentSlot, func(justified common.Checkpoint) ([]common.Gwei, error) {
+		if balances == nil || justified != cp {
+			return nil, fmt.Errorf("no balances prepared for justified checkpoint %s", justified)
+		}
+		return balances, nil
+	})
+}
+
+func (c *UnfinalizedChain) currentSlot() common.Slot {
+	t := common.Timestamp(c.now.Unix())
+	if t < c.genesis.Time {
+		return 0
+	}
+	return common.Slot((t - c.genesis.Time) / c.spec.SECONDS_PER_SLOT)
+}
+
+func (c *UnfinalizedChain) intoSlot() time.Duration {
+	slotStart := c.genesis.Time + common.Timestamp(c.currentSlot())*c.spec.SECONDS_PER_SLOT
+	return c.now.Sub(time.Unix(int64(slotStart), 0))
+}
+
+// updateCheckpoints moves the forkchoice to the given checkpoints, if they are newer.
+// The caller must hold the lock for writing.
+func (c *UnfinalizedChain) updateCheckpoints(ctx context.Context, trigger common.Root, justified common.Checkpoint, finalized common.Checkpoint) error {
+	prevJustified, prevFinalized := c.fc.Justified(), c.fc.Finalized()
+	if justified.Epoch <= prevJustified.Epoch && finalized.Epoch <= prevFinalized.Epoch {
+		return nil
+	}
+	if justified.Epoch <= prevJustified.Epoch {
+		justified = prevJustified
+	}
+	if finalized.Epoch <= prevFinalized.Epoch {
+		finalized = prevFinalized
+	}
+	// The balances are computed before updating the forkchoice,
+	// since the checkpoint state may need slot processing, which updates the forkchoice.
+	startSlot, err := c.spec.EpochStartSlot(justified.Epoch)
+	if err != nil {
+		return err
+	}
+	cpEntry, err := c.towards(ctx, justified.Root, startSlot)
+	if err != nil {
+		return fmt.Errorf("failed to get justified checkpoint state: %w", err)
+	}
+	balances := activeBalances(cpEntry.epc)
+	return c.fc.UpdateJustified(ctx, trigger, justified, finalized, func() ([]common.Gwei, error) {
+		return balances, nil
+	})
+}
+
+// activeBalances returns the effective balances of the active validators, inactive validators have a zero balance.
+func activeBalances(epc *common.EpochsContext) []common.Gwei {
+	balances := make([]common.Gwei, len(epc.EffectiveBalances))
+	for _, i := range epc.CurrentEpoch.ActiveIndices {
+		balances[i] = epc.EffectiveBalances[i]
+	}
+	return balances
+}
+
+func (c *UnfinalizedChain) AddVote(index common.ValidatorIndex, blockRoot common.Root, headSlot common.Slot, targetEpoch common.Epoch) (ok bool) {
+	return c.fc.ProcessAttestation(index, blockRoot, headSlot, targetEpoch)
+}
+
+type hotChainIter struct {
+	chain  *UnfinalizedChain
+	anchor common.NodeRef
+	start  common.Step
+	end    common.Step
+}
+
+func (it *hotChainIter) Start() common.Step {
+	return it.start
+}
+
+func (it *hotChainIter) End() common.Step {
+	return it.end
+}
+
+var errOutOfRange = errors.New("step out of range")
+
+func (it *hotChainIter) Entry(step common.Step) (entry beacon.ChainEntry, err error) {
+	if step < it.start || step >= it.end {
+		return nil, fmt.Errorf("%w: %s, range is [%s, %s)", errOutOfRange, step, it.start, it.end)
+	}
+	it.chain.RLock()
+	defer it.chain.RUnlock()
+	ref, err := it.chain.fc.CanonAtSlot(it.anchor.Root, step.Slot(), step.Block())
+	if err != nil {
+		return nil, err
+	}
+	if ref == (common.NodeRef{}) {
+		return nil, nil
+	}
+	e, ok := it.chain.entries[ref]
+	if !ok {
+		return nil, fmt.Errorf("entry %s was pruned", ref)
+	}
+	return e, nil
+}
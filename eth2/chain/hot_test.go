@@ -0,0 +1,233 @@
+package chain
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/zrnt/eth2/internal/beacontest"
+)
+
+type testChain struct {
+	t     *testing.T
+	spec  *common.Spec
+	gen   *beacontest.Genesis
+	chain *UnfinalizedChain
+}
+
+func newTestChain(t *testing.T, validatorCount uint64) *testChain {
+	spec := configs.Minimal
+	gen := beacontest.NewGenesis(t, spec, validatorCount)
+	ch, err := NewUnfinalizedChain(spec, gen.State, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testChain{t: t, spec: spec, gen: gen, chain: ch}
+}
+
+// buildBlock creates a valid block on top of the parent, the graffiti is used to create different blocks at the same slot.
+func (tc *testChain) buildBlock(parent common.Root, slot common.Slot, graffiti byte) *common.BeaconBlockEnvelope {
+	ctx := context.Background()
+	pre, err := tc.chain.Towards(ctx, parent, slot)
+	if err != nil {
+		tc.t.Fatal(err)
+	}
+	state, err := pre.State(ctx)
+	if err != nil {
+		tc.t.Fatal(err)
+	}
+	epc, err := pre.EpochsContext(ctx)
+	if err != nil {
+		tc.t.Fatal(err)
+	}
+	return tc.gen.BuildBlock(tc.t, state, epc, parent, slot, graffiti)
+}
+
+func (tc *testChain) addBlock(parent common.Root, slot common.Slot, graffiti byte) *common.BeaconBlockEnvelope {
+	benv := tc.buildBlock(parent, slot, graffiti)
+	if err := tc.chain.AddBlock(context.Background(), benv); err != nil {
+		tc.t.Fatal(err)
+	}
+	return benv
+}
+
+func TestUnfinalizedChain(t *testing.T) {
+	tc := newTestChain(t, 64)
+	ch := tc.chain
+
+	genesis, err := ch.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesisRoot, _ := genesis.BlockRoot()
+	if step := genesis.Step(); step != common.AsStep(0, true) {
+		t.Fatalf("unexpected genesis step: %s", step)
+	}
+
+	//  genesis - a - b
+	//         \
+	//          ------- c
+	a := tc.addBlock(genesisRoot, 1, 0)
+	b := tc.addBlock(a.BlockRoot, 2, 0)
+	c := tc.addBlock(genesisRoot, 3, 1)
+
+	if e, ok := ch.ByBlock(a.BlockRoot); !ok || e.Step() != common.AsStep(1, true) {
+		t.Fatal("expected block a")
+	}
+	if e, ok := ch.ByStateRoot(b.StateRoot); !ok || e.Step() != common.AsStep(2, true) {
+		t.Fatal("expected block b by state root")
+	}
+	if e, ok := ch.ByBlockSlot(genesisRoot, 2); !ok || e.Step() != common.AsStep(2, false) {
+		t.Fatal("expected empty slot 2 after genesis, on the branch of c")
+	} else if root, _ := e.BlockRoot(); root != genesisRoot {
+		t.Fatal("expected empty slot to replicate genesis block root")
+	}
+	if unknown, inSubtree := ch.InSubtree(a.BlockRoot, b.BlockRoot); unknown || !inSubtree {
+		t.Fatal("b is in subtree of a")
+	}
+	if unknown, inSubtree := ch.InSubtree(a.BlockRoot, c.BlockRoot); unknown || inSubtree {
+		t.Fatal("c is not in subtree of a")
+	}
+
+	// make c canonical, and b the best child of a
+	for i := common.ValidatorIndex(0); i < 10; i++ {
+		if !ch.AddVote(i, c.BlockRoot, 3, 0) {
+			t.Fatal("vote not accepted")
+		}
+	}
+	if !ch.AddVote(10, b.BlockRoot, 2, 0) {
+		t.Fatal("vote not accepted")
+	}
+	head, err := ch.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root, _ := head.BlockRoot(); root != c.BlockRoot {
+		t.Fatalf("expected c to be head, got %s", root)
+	}
+
+	heads, err := ch.Search(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(heads) != 2 {
+		t.Fatalf("expected 2 heads, got %d", len(heads))
+	}
+	for _, h := range heads {
+		root, _ := h.BlockRoot()
+		if h.Canonical != (root == c.BlockRoot) {
+			t.Fatalf("unexpected canonical status of head %s", root)
+		}
+	}
+
+	if e, ok := ch.ByCanonStep(common.AsStep(1, true)); !ok || e != nil {
+		t.Fatal("expected canonical slot 1 to be empty")
+	}
+	if e, ok := ch.ByCanonStep(common.AsStep(3, true)); !ok || e == nil || e.Step() != common.AsStep(3, true) {
+		t.Fatal("expected canonical block c at slot 3")
+	}
+	if e, ok := ch.ByCanonStep(common.AsStep(3, false)); !ok || e == nil || e.Step() != common.AsStep(3, false) {
+		t.Fatal("expected canonical pre-block node at slot 3")
+	}
+
+	iter, err := ch.Iter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iter.Start() != common.AsStep(0, true) || iter.End() != common.AsStep(3, true)+1 {
+		t.Fatalf("unexpected iter range [%s, %s)", iter.Start(), iter.End())
+	}
+	if e, err := iter.Entry(common.AsStep(2, false)); err != nil || e == nil {
+		t.Fatalf("expected entry at slot 2: %v", err)
+	}
+	if _, err := iter.Entry(common.AsStep(4, false)); err == nil {
+		t.Fatal("expected out of range error")
+	}
+
+	if e, ok := ch.Closest(b.BlockRoot, 10); !ok || e.Step() != common.AsStep(2, true) {
+		t.Fatal("expected closest entry to be b itself")
+	}
+	towards, err := ch.Towards(context.Background(), b.BlockRoot, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if towards.Step() != common.AsStep(10, false) {
+		t.Fatalf("unexpected step %s", towards.Step())
+	}
+	state, err := towards.State(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slot, _ := state.Slot(); slot != 10 {
+		t.Fatalf("unexpected state slot %d", slot)
+	}
+	if e, ok := ch.Closest(b.BlockRoot, 10); !ok || e.Step() != common.AsStep(10, false) {
+		t.Fatal("expected closest entry to be the processed empty slot")
+	}
+
+	// blocks that do not extend a known block, or have an invalid signature, are rejected
+	orphan := tc.buildBlock(b.BlockRoot, 11, 0)
+	orphan.ParentRoot = common.Root{0x42}
+	if err := ch.AddBlock(context.Background(), orphan); err == nil {
+		t.Fatal("expected unknown parent error")
+	}
+	invalid := tc.buildBlock(b.BlockRoot, 11, 0)
+	invalid.Signature = common.BLSSignature{}
+	if err := ch.AddBlock(context.Background(), invalid); err == nil {
+		t.Fatal("expected invalid signature error")
+	}
+}
+
+func TestUnfinalizedChainTick(t *testing.T) {
+	tc := newTestChain(t, 64)
+	ch := tc.chain
+	ctx := context.Background()
+	slotTime := func(slot common.Slot, intoSlot time.Duration) time.Time {
+		start := ch.Genesis().Time + common.Timestamp(slot)*tc.spec.SECONDS_PER_SLOT
+		return time.Unix(int64(start), 0).Add(intoSlot)
+	}
+
+	genesis, err := ch.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesisRoot, _ := genesis.BlockRoot()
+
+	// a late block is not boosted
+	if err := ch.OnTick(ctx, slotTime(1, 5*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	a := tc.addBlock(genesisRoot, 1, 0)
+	if root := ch.ForkChoice().ProposerBoostRoot(); root != (common.Root{}) {
+		t.Fatalf("unexpected proposer boost of late block: %s", root)
+	}
+
+	// a timely block is boosted, until the next slot
+	if err := ch.OnTick(ctx, slotTime(2, time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	b := tc.addBlock(a.BlockRoot, 2, 0)
+	if root := ch.ForkChoice().ProposerBoostRoot(); root != b.BlockRoot {
+		t.Fatalf("expected proposer boost of b, got %s", root)
+	}
+	if err := ch.OnTick(ctx, slotTime(3, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if root := ch.ForkChoice().ProposerBoostRoot(); root != (common.Root{}) {
+		t.Fatalf("expected proposer boost to be removed, got %s", root)
+	}
+
+	// ticking across an epoch boundary works without new justification
+	if err := ch.OnTick(ctx, slotTime(tc.spec.SLOTS_PER_EPOCH+1, 0)); err != nil {
+		t.Fatal(err)
+	}
+	head, err := ch.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root, _ := head.BlockRoot(); root != b.BlockRoot {
+		t.Fatalf("expected b to be head, got %s", root)
+	}
+}
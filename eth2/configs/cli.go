@@ -7,6 +7,7 @@ import (
 	"gopkg.in/yaml.v3"
 
 	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/kzg"
 )
 
 type SpecOptions struct {
@@ -20,8 +21,9 @@ type SpecOptions struct {
 	CapellaPreset   string `ask:"--preset-capella" help:"Eth2 capella spec preset, name or path to YAML"`
 	DenebPreset     string `ask:"--preset-deneb" help:"Eth2 deneb spec preset, name or path to YAML"`
 
+	TrustedSetup string `ask:"--trusted-setup" help:"KZG trusted setup, path to JSON. Optional, required for Deneb blob verification"`
+
 	// TODO: execution engine config for Bellatrix
-	// TODO: trusted setup config for Sharding
 }
 
 type LegacyConfig struct {
@@ -159,6 +161,19 @@ func (c *SpecOptions) Spec() (*common.Spec, error) {
 		}
 	}
 	spec.ExecutionEngine = nil
+
+	spec.KZG = nil
+	spec.DataAvailability = nil
+	if c.TrustedSetup != "" {
+		kzgCtx, err := kzg.LoadTrustedSetup(c.TrustedSetup)
+		if err != nil {
+			return nil, err
+		}
+		if x := kzgCtx.FieldElementsPerBlob(); x != uint64(spec.FIELD_ELEMENTS_PER_BLOB) {
+			return nil, fmt.Errorf("trusted setup has %d field elements per blob, but spec expects %d", x, spec.FIELD_ELEMENTS_PER_BLOB)
+		}
+		spec.KZG = kzgCtx
+	}
 	return &spec, nil
 }
 
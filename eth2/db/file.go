@@ -0,0 +1,106 @@
+package db
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// FileKV is a KV that stores every value in a separate file:
+// <dir>/<first byte of key, hex>/<key hex>
+// Values are written to a temporary file first, to not leave partial values behind.
+type FileKV struct {
+	dir string
+}
+
+var _ KV = (*FileKV)(nil)
+
+// NewFileKV opens the store in the given directory, and creates the directory if it does not exist yet.
+func NewFileKV(dir string) (*FileKV, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create kv dir: %w", err)
+	}
+	return &FileKV{dir: dir}, nil
+}
+
+func (f *FileKV) path(key []byte) string {
+	name := hex.EncodeToString(key)
+	if len(key) == 0 {
+		return filepath.Join(f.dir, "_")
+	}
+	return filepath.Join(f.dir, name[:2], name)
+}
+
+func (f *FileKV) Get(key []byte) (value []byte, ok bool, err error) {
+	data, err := ioutil.ReadFile(f.path(key))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+func (f *FileKV) Has(key []byte) (bool, error) {
+	_, err := os.Stat(f.path(key))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (f *FileKV) Put(key []byte, value []byte) error {
+	p := f.path(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	// Every writer gets its own temporary file, concurrent writes of the same key do not interfere.
+	tmp, err := ioutil.TempFile(filepath.Dir(p), filepath.Base(p)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if err := writeSynced(tmp, value); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// writeSynced writes the value to the file, and flushes it to disk before closing,
+// so the value is complete once the file is renamed into place.
+func writeSynced(f *os.File, value []byte) error {
+	if err := f.Chmod(0o644); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if _, err := f.Write(value); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (f *FileKV) Delete(key []byte) error {
+	if err := os.Remove(f.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (f *FileKV) Close() error {
+	return nil
+}
@@ -0,0 +1,65 @@
+package db
+
+import "sync"
+
+// KV is a minimal key-value store to persist consensus data in.
+// Implementations must be safe for concurrent use.
+type KV interface {
+	// Get retrieves the value of the key. Returns ok=false if the key does not exist.
+	Get(key []byte) (value []byte, ok bool, err error)
+	// Has checks if the key exists, without retrieving the value.
+	Has(key []byte) (bool, error)
+	// Put stores the value, overwriting any previous value of the key.
+	Put(key []byte, value []byte) error
+	// Delete removes the key. Deleting a key that does not exist is not an error.
+	Delete(key []byte) error
+	// Close releases any resources of the store.
+	Close() error
+}
+
+// MemoryKV is a KV that keeps everything in memory, mostly useful for testing.
+type MemoryKV struct {
+	sync.RWMutex
+	data map[string][]byte
+}
+
+var _ KV = (*MemoryKV)(nil)
+
+func NewMemoryKV() *MemoryKV {
+	return &MemoryKV{data: make(map[string][]byte)}
+}
+
+func (m *MemoryKV) Get(key []byte) (value []byte, ok bool, err error) {
+	m.RLock()
+	defer m.RUnlock()
+	v, ok := m.data[string(key)]
+	if !ok {
+		return nil, false, nil
+	}
+	return append([]byte(nil), v...), true, nil
+}
+
+func (m *MemoryKV) Has(key []byte) (bool, error) {
+	m.RLock()
+	defer m.RUnlock()
+	_, ok := m.data[string(key)]
+	return ok, nil
+}
+
+func (m *MemoryKV) Put(key []byte, value []byte) error {
+	m.Lock()
+	defer m.Unlock()
+	m.data[string(key)] = append([]byte(nil), value...)
+	return nil
+}
+
+func (m *MemoryKV) Delete(key []byte) error {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.data, string(key))
+	return nil
+}
+
+func (m *MemoryKV) Close() error {
+	return nil
+}
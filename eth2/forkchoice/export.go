@@ -0,0 +1,83 @@
+package forkchoice
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// DebugNodeRef refers to a node of the forkchoice graph, as encoded in the JSON of a DebugTree.
+type DebugNodeRef struct {
+	Slot Slot `json:"slot"`
+	Root Root `json:"root"`
+}
+
+// DebugTree is a view of the forkchoice graph for debugging,
+// encoded as JSON it is similar to the beacon-API debug fork-choice response.
+type DebugTree struct {
+	JustifiedCheckpoint Checkpoint   `json:"justified_checkpoint"`
+	FinalizedCheckpoint Checkpoint   `json:"finalized_checkpoint"`
+	Anchor              DebugNodeRef `json:"anchor"`
+	Head                DebugNodeRef `json:"head"`
+	Nodes               []DebugNode  `json:"fork_choice_nodes"`
+}
+
+// ExportTree describes the forkchoice graph, starting from the given anchor.
+// The head is left empty if there is no viable head.
+func ExportTree(fc Forkchoice, anchorRoot Root, anchorSlot Slot) (*DebugTree, error) {
+	nodes, err := fc.DebugNodes(anchorRoot, anchorSlot)
+	if err != nil {
+		return nil, err
+	}
+	tree := &DebugTree{
+		JustifiedCheckpoint: fc.Justified(),
+		FinalizedCheckpoint: fc.Finalized(),
+		Anchor:              DebugNodeRef{Root: anchorRoot, Slot: anchorSlot},
+		Nodes:               nodes,
+	}
+	// The head is the last canonical node, children come after their parents.
+	for _, n := range nodes {
+		if n.Canonical {
+			tree.Head = DebugNodeRef{Root: n.Root, Slot: n.Slot}
+		}
+	}
+	return tree, nil
+}
+
+// WriteDOT writes the tree as Graphviz DOT graph, with an edge from every node to its parent.
+// Empty slots are drawn as ellipses, canonical nodes are bold, and nodes that are not viable for the head are dashed.
+func (t *DebugTree) WriteDOT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	id := func(ref DebugNodeRef) string {
+		return fmt.Sprintf("%q", fmt.Sprintf("%s:%d", ref.Root, ref.Slot))
+	}
+	fmt.Fprintln(bw, "digraph forkchoice {")
+	fmt.Fprintln(bw, "\trankdir=RL;")
+	fmt.Fprintf(bw, "\tlabel=\"justified %s, finalized %s\";\n", &t.JustifiedCheckpoint, &t.FinalizedCheckpoint)
+	for _, n := range t.Nodes {
+		ref := DebugNodeRef{Root: n.Root, Slot: n.Slot}
+		shape := "box"
+		if n.Gap {
+			shape = "ellipse"
+		}
+		var style string
+		switch {
+		case n.Canonical && !n.Viable:
+			style = "bold,dashed"
+		case n.Canonical:
+			style = "bold"
+		case !n.Viable:
+			style = "dashed"
+		default:
+			style = "solid"
+		}
+		label := fmt.Sprintf("slot %d\\n%x\\nweight %d\\njustified %d, finalized %d\\n%s",
+			n.Slot, n.Root[:4], n.Weight, n.JustifiedEpoch, n.FinalizedEpoch, n.ExecutionStatus)
+		fmt.Fprintf(bw, "\t%s [shape=%s, style=%q, label=\"%s\"];\n", id(ref), shape, style, label)
+		if n.Parent != nil {
+			fmt.Fprintf(bw, "\t%s -> %s;\n", id(ref), id(*n.Parent))
+		}
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
 )
 
 type ProtoForkChoice struct {
@@ -20,6 +22,25 @@ type ProtoForkChoice struct {
 	justified Checkpoint
 	finalized Checkpoint
 	spec      *common.Spec
+
+	// The best unrealized checkpoints of any block, to pull up to at the next epoch.
+	unrealizedJustified Checkpoint
+	unrealizedFinalized Checkpoint
+	currentSlot         Slot
+
+	// The timely block that should receive the proposer boost, zero if none.
+	boostRef NodeRef
+	// The boost that is currently applied to the graph, zero if none.
+	appliedBoost proposerBoost
+	// True if the boost or the current epoch changed, and the graph needs to be updated.
+	changed bool
+	// Blocks that arrived in their own slot, before the attestation deadline.
+	timely map[Root]struct{}
+}
+
+type proposerBoost struct {
+	ref   NodeRef
+	score Gwei
 }
 
 var _ Forkchoice = (*ProtoForkChoice)(nil)
@@ -34,6 +55,11 @@ func NewForkChoice(spec *common.Spec, finalized Checkpoint, justified Checkpoint
 		justified:  justified,
 		finalized:  finalized,
 		spec:       spec,
+
+		unrealizedJustified: justified,
+		unrealizedFinalized: finalized,
+		currentSlot:         anchorSlot,
+		timely:              make(map[Root]struct{}),
 	}
 	if err := fc.SetPin(anchorRoot, anchorSlot); err != nil {
 		return nil, err
@@ -69,21 +95,32 @@ func (fc *ProtoForkChoice) SetPin(root Root, slot Slot) error {
 
 // UpdateJustified updates what is recognized as justified and finalized checkpoint,
 // and adjusts justified balances for vote weights.
+// Each checkpoint is only updated if it has a higher epoch than the current checkpoint.
 // If the finalized checkpoint changes, it triggers pruning.
-// Note that pruning can prune the pre-block node of the start slot of the finalized epoch, if it is not a gap slot.
-// And the finalizing node with the block will remain.
+// Pruning keeps the node of the finalized block, and prunes everything before it.
 // The justification/finalization trigger must be within the pinned subtree (if any).
 func (fc *ProtoForkChoice) UpdateJustified(ctx context.Context, trigger Root, justified Checkpoint, finalized Checkpoint,
 	justifiedStateBalances func() ([]Gwei, error)) error {
 	fc.mu.Lock()
 	defer fc.mu.Unlock()
+	return fc.updateCheckpoints(ctx, trigger, justified, finalized, justifiedStateBalances)
+}
+
+func (fc *ProtoForkChoice) updateCheckpoints(ctx context.Context, trigger Root, justified Checkpoint, finalized Checkpoint,
+	justifiedStateBalances func() ([]Gwei, error)) error {
+	if justified.Epoch <= fc.justified.Epoch {
+		justified = fc.justified
+	}
+	if finalized.Epoch <= fc.finalized.Epoch {
+		finalized = fc.finalized
+	}
 	// Old/same data? Ignore the change.
-	if fc.justified.Epoch >= justified.Epoch && fc.finalized.Epoch >= finalized.Epoch {
+	if justified == fc.justified && finalized == fc.finalized {
 		return nil
 	}
 	if fc.pin != nil && trigger != fc.pin.Root {
 		// check trigger against pin, to ensure no justification/finalization of data that conflicts with the pin.
-		if unknown, inSubtree := fc.InSubtree(fc.pin.Root, trigger); unknown {
+		if unknown, inSubtree := fc.protoArray.InSubtree(fc.pin.Root, trigger); unknown {
 			return fmt.Errorf("cannot justify/finalize with unknown trigger when forkchoice is pinned")
 		} else if !inSubtree {
 			return fmt.Errorf("cannot justify/finalize outside of pinned forkchoice tree")
@@ -92,17 +129,26 @@ func (fc *ProtoForkChoice) UpdateJustified(ctx context.Context, trigger Root, ju
 
 	prevFinalized := fc.finalized
 
-	if err := fc.updateJustified(justified, finalized, justifiedStateBalances); err != nil {
+	if err := fc.updateJustified(finalized, justified, justifiedStateBalances); err != nil {
 		return err
 	}
 
 	// prune if we finalized something, and undo the pin.
 	if prevFinalized != finalized {
 		fc.pin = nil
-		finSlot, _ := fc.spec.EpochStartSlot(finalized.Epoch)
+		// Prune up to the finalized block itself, to keep the forkchoice subtree of the block.
+		finSlot, ok := fc.protoArray.GetSlot(finalized.Root)
+		if !ok {
+			return fmt.Errorf("unknown finalized block %s", finalized.Root)
+		}
 		if err := fc.protoArray.OnPrune(ctx, finalized.Root, finSlot); err != nil {
 			return err
 		}
+		for root := range fc.timely {
+			if _, ok := fc.protoArray.GetSlot(root); !ok {
+				delete(fc.timely, root)
+			}
+		}
 	}
 	return nil
 }
@@ -115,7 +161,7 @@ func (fc *ProtoForkChoice) updateJustified(finalized Checkpoint, justified Check
 
 	// check if new finalized checkpoint is valid
 	if fc.finalized != finalized {
-		if unknown, inSubtree := fc.InSubtree(fc.finalized.Root, finalized.Root); unknown {
+		if unknown, inSubtree := fc.protoArray.InSubtree(fc.finalized.Root, finalized.Root); unknown {
 			return fmt.Errorf("unknown finalized checkpoint: %s", finalized)
 		} else if !inSubtree || fc.finalized.Epoch > finalized.Epoch {
 			return fmt.Errorf("new finalized checkpoint %s is outside of finalized subtree: %s",
@@ -123,7 +169,7 @@ func (fc *ProtoForkChoice) updateJustified(finalized Checkpoint, justified Check
 		}
 	}
 	if fc.justified != justified {
-		if unknown, inSubtree := fc.InSubtree(fc.finalized.Root, justified.Root); unknown {
+		if unknown, inSubtree := fc.protoArray.InSubtree(fc.finalized.Root, justified.Root); unknown {
 			return fmt.Errorf("unknown justified checkpoint: %s", justified)
 		} else if !inSubtree || fc.finalized.Epoch > justified.Epoch {
 			return fmt.Errorf("new justified checkpoint %s is outside of finalized subtree: %s",
@@ -137,9 +183,11 @@ func (fc *ProtoForkChoice) updateJustified(finalized Checkpoint, justified Check
 		return err
 	}
 
-	deltas := fc.voteStore.ComputeDeltas(fc.protoArray.Indices(), oldBals, newBals)
+	indices := fc.protoArray.Indices()
+	deltas := fc.voteStore.ComputeDeltas(indices, oldBals, newBals)
+	fc.boostDeltas(indices, deltas, newBals)
 
-	if err := fc.protoArray.ApplyScoreChanges(deltas, justified.Epoch, finalized.Epoch); err != nil {
+	if err := fc.protoArray.ApplyScoreChanges(deltas, justified.Epoch, finalized.Epoch, fc.currentSlot); err != nil {
 		return err
 	}
 
@@ -154,13 +202,166 @@ func (fc *ProtoForkChoice) updateJustified(finalized Checkpoint, justified Check
 //
 //	(if not bigger than previous difference between head-node contenders)
 func (fc *ProtoForkChoice) updateVotesMaybe() error {
-	if !fc.voteStore.HasChanges() {
+	if !fc.voteStore.HasChanges() && !fc.changed {
 		return nil
 	}
 
-	deltas := fc.voteStore.ComputeDeltas(fc.protoArray.Indices(), fc.balances, fc.balances)
+	indices := fc.protoArray.Indices()
+	deltas := fc.voteStore.ComputeDeltas(indices, fc.balances, fc.balances)
+	fc.boostDeltas(indices, deltas, fc.balances)
 
-	return fc.protoArray.ApplyScoreChanges(deltas, fc.justified.Epoch, fc.finalized.Epoch)
+	return fc.protoArray.ApplyScoreChanges(deltas, fc.justified.Epoch, fc.finalized.Epoch, fc.currentSlot)
+}
+
+// boostDeltas removes the currently applied proposer boost from the deltas, and adds the new boost, if any.
+// The boost is recomputed every time, since it depends on the justified balances.
+func (fc *ProtoForkChoice) boostDeltas(indices map[NodeRef]NodeIndex, deltas []SignedGwei, balances []Gwei) {
+	if fc.appliedBoost.ref != (NodeRef{}) {
+		// if the node was pruned, its weight is gone already.
+		if i, ok := indices[fc.appliedBoost.ref]; ok {
+			deltas[i] -= SignedGwei(fc.appliedBoost.score)
+		}
+		fc.appliedBoost = proposerBoost{}
+	}
+	if fc.boostRef != (NodeRef{}) {
+		if i, ok := indices[fc.boostRef]; ok {
+			score := fc.proposerScore(balances)
+			deltas[i] += SignedGwei(score)
+			fc.appliedBoost = proposerBoost{ref: fc.boostRef, score: score}
+		}
+	}
+	fc.changed = false
+}
+
+// proposerScore computes the weight of the proposer boost:
+// the weight of a committee, as share of the PROPOSER_SCORE_BOOST percentage.
+func (fc *ProtoForkChoice) proposerScore(balances []Gwei) Gwei {
+	return fc.committeeFraction(balances, uint64(fc.spec.PROPOSER_SCORE_BOOST))
+}
+
+// committeeFraction computes the given percentage of the weight of a committee.
+func (fc *ProtoForkChoice) committeeFraction(balances []Gwei, percentage uint64) Gwei {
+	total := Gwei(0)
+	for _, b := range balances {
+		total += b
+	}
+	committeeWeight := total / Gwei(fc.spec.SLOTS_PER_EPOCH)
+	return committeeWeight * Gwei(percentage) / 100
+}
+
+// BlockArrival registers the arrival of a block, at the given time into the current slot.
+// A block is timely if it arrives in its own slot, before the attestation deadline of the slot.
+// A timely block receives the proposer boost, until ProcessTick moves to a later slot.
+func (fc *ProtoForkChoice) BlockArrival(blockRoot Root, currentSlot Slot, intoSlot time.Duration) (timely bool) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	blockSlot, ok := fc.protoArray.GetSlot(blockRoot)
+	if !ok || blockSlot != currentSlot {
+		return false
+	}
+	deadline := time.Duration(fc.spec.SECONDS_PER_SLOT) * time.Second / common.INTERVALS_PER_SLOT
+	if intoSlot >= deadline {
+		return false
+	}
+	fc.timely[blockRoot] = struct{}{}
+	// Only the first timely block of the slot is boosted.
+	if fc.boostRef != (NodeRef{}) {
+		return true
+	}
+	fc.boostRef = NodeRef{Root: blockRoot, Slot: blockSlot}
+	fc.changed = true
+	return true
+}
+
+// ProcessTick moves the forkchoice to the current slot.
+// Any proposer boost of an earlier slot is removed.
+// At the start of a new epoch the justified and finalized checkpoints are pulled up to the best unrealized checkpoints,
+// the justified state balances are only requested if the justified checkpoint changes.
+func (fc *ProtoForkChoice) ProcessTick(ctx context.Context, currentSlot Slot,
+	justifiedStateBalances func(justified Checkpoint) ([]Gwei, error)) error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if currentSlot <= fc.currentSlot {
+		return nil
+	}
+	prevEpoch := fc.spec.SlotToEpoch(fc.currentSlot)
+	fc.currentSlot = currentSlot
+	if fc.boostRef != (NodeRef{}) && fc.boostRef.Slot < currentSlot {
+		fc.boostRef = NodeRef{}
+		fc.changed = true
+	}
+	if fc.spec.SlotToEpoch(currentSlot) > prevEpoch {
+		// The viability of nodes depends on the current epoch.
+		fc.changed = true
+		justified := fc.unrealizedJustified
+		if justified.Epoch <= fc.justified.Epoch {
+			justified = fc.justified
+		}
+		return fc.updateCheckpoints(ctx, justified.Root, justified, fc.unrealizedFinalized, func() ([]Gwei, error) {
+			return justifiedStateBalances(justified)
+		})
+	}
+	return nil
+}
+
+// ProposerBoostRoot returns the root of the block that receives the proposer boost, zero if none.
+func (fc *ProtoForkChoice) ProposerBoostRoot() Root {
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+	return fc.boostRef.Root
+}
+
+// ProposerHead implements the proposer re-org of a weak late head (spec get_proposer_head).
+// The parent of the head is returned if all of the following hold, the head itself otherwise:
+//   - the head was not timely, and no longer has the proposer boost
+//   - the proposal is not at the start of an epoch, where the shuffling may change
+//   - the head and parent have the same unrealized justification
+//   - finalization is not delayed by more than REORG_MAX_EPOCHS_SINCE_FINALIZATION
+//   - the proposal is on time: in the first half of the attestation interval
+//   - the head is one slot after its parent, and one slot before the proposal
+//   - the head weight is below REORG_HEAD_WEIGHT_THRESHOLD, and the parent weight above REORG_PARENT_WEIGHT_THRESHOLD,
+//     as percentage of the committee weight of the justified balances.
+func (fc *ProtoForkChoice) ProposerHead(headRoot Root, slot Slot, intoSlot time.Duration) (Root, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if err := fc.updateVotesMaybe(); err != nil {
+		return Root{}, err
+	}
+	head, ok := fc.protoArray.GetBlock(headRoot)
+	if !ok {
+		return Root{}, fmt.Errorf("unknown head block %s", headRoot)
+	}
+	parent, ok := fc.protoArray.GetBlock(head.ParentRoot)
+	if !ok {
+		// The parent was pruned, it cannot be re-orged to.
+		return headRoot, nil
+	}
+	if _, timely := fc.timely[headRoot]; timely || fc.boostRef.Root == headRoot {
+		return headRoot, nil
+	}
+	if slot%fc.spec.SLOTS_PER_EPOCH == 0 {
+		return headRoot, nil
+	}
+	if head.UnrealizedJustifiedEpoch != parent.UnrealizedJustifiedEpoch {
+		return headRoot, nil
+	}
+	if fc.spec.SlotToEpoch(slot) > fc.finalized.Epoch+fc.spec.REORG_MAX_EPOCHS_SINCE_FINALIZATION {
+		return headRoot, nil
+	}
+	cutoff := time.Duration(fc.spec.SECONDS_PER_SLOT) * time.Second / common.INTERVALS_PER_SLOT / 2
+	if intoSlot > cutoff {
+		return headRoot, nil
+	}
+	if parent.Ref.Slot+1 != head.Ref.Slot || head.Ref.Slot+1 != slot {
+		return headRoot, nil
+	}
+	if head.Weight >= fc.committeeFraction(fc.balances, uint64(fc.spec.REORG_HEAD_WEIGHT_THRESHOLD)) {
+		return headRoot, nil
+	}
+	if parent.Weight <= fc.committeeFraction(fc.balances, uint64(fc.spec.REORG_PARENT_WEIGHT_THRESHOLD)) {
+		return headRoot, nil
+	}
+	return head.ParentRoot, nil
 }
 
 func (fc *ProtoForkChoice) Justified() Checkpoint {
@@ -175,7 +376,7 @@ func (fc *ProtoForkChoice) Finalized() Checkpoint {
 	return fc.finalized
 }
 
-func (fc *ProtoForkChoice) ProcessAttestation(index ValidatorIndex, blockRoot Root, headSlot Slot) (ok bool) {
+func (fc *ProtoForkChoice) ProcessAttestation(index ValidatorIndex, blockRoot Root, headSlot Slot, targetEpoch Epoch) (ok bool) {
 	fc.mu.Lock()
 	defer fc.mu.Unlock()
 	// only add the vote if we can. Don't add if it's not within view.
@@ -183,7 +384,31 @@ func (fc *ProtoForkChoice) ProcessAttestation(index ValidatorIndex, blockRoot Ro
 	if !ok || blockSlot < headSlot {
 		return false
 	}
-	return fc.voteStore.ProcessAttestation(index, blockRoot, headSlot)
+	return fc.voteStore.ProcessAttestation(index, blockRoot, headSlot, targetEpoch)
+}
+
+// ProcessAttesterSlashing marks the intersecting validators of the attester slashing as equivocating.
+// The signatures of the slashing are not verified, this is up to the caller.
+func (fc *ProtoForkChoice) ProcessAttesterSlashing(slashing *phase0.AttesterSlashing) error {
+	att1 := &slashing.Attestation1
+	att2 := &slashing.Attestation2
+	if !phase0.IsSlashableAttestationData(&att1.Data, &att2.Data) {
+		return fmt.Errorf("attester slashing is not slashable")
+	}
+	indices1, err := phase0.ValidateIndexedAttestationIndicesSet(fc.spec, att1)
+	if err != nil {
+		return fmt.Errorf("invalid attestation 1 of attester slashing: %w", err)
+	}
+	indices2, err := phase0.ValidateIndexedAttestationIndicesSet(fc.spec, att2)
+	if err != nil {
+		return fmt.Errorf("invalid attestation 2 of attester slashing: %w", err)
+	}
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	indices1.ZigZagJoin(indices2, func(i ValidatorIndex) {
+		fc.voteStore.ProcessEquivocation(i)
+	}, nil)
+	return nil
 }
 
 func (fc *ProtoForkChoice) CanonicalChain(anchorRoot Root, anchorSlot Slot) ([]ExtendedNodeRef, error) {
@@ -198,10 +423,53 @@ func (fc *ProtoForkChoice) ProcessSlot(parentRoot Root, slot Slot, justifiedEpoc
 	fc.protoArray.ProcessSlot(parentRoot, slot, justifiedEpoch, finalizedEpoch)
 }
 
-func (fc *ProtoForkChoice) ProcessBlock(parentRoot Root, blockRoot Root, blockSlot Slot, justifiedEpoch Epoch, finalizedEpoch Epoch) (ok bool) {
+// ProcessBlock adds the block, and tracks its unrealized checkpoints to pull up to at the next epoch.
+// If the block is from an earlier epoch than the current epoch, the caller should pull up immediately,
+// with UpdateJustified and the unrealized checkpoints of the block.
+func (fc *ProtoForkChoice) ProcessBlock(parentRoot Root, blockRoot Root, blockSlot Slot, justifiedEpoch Epoch, finalizedEpoch Epoch,
+	unrealizedJustified Checkpoint, unrealizedFinalized Checkpoint) (ok bool) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if !fc.protoArray.ProcessBlock(parentRoot, blockRoot, blockSlot, justifiedEpoch, finalizedEpoch, unrealizedJustified, unrealizedFinalized) {
+		return false
+	}
+	if unrealizedJustified.Epoch > fc.unrealizedJustified.Epoch {
+		fc.unrealizedJustified = unrealizedJustified
+	}
+	if unrealizedFinalized.Epoch > fc.unrealizedFinalized.Epoch {
+		fc.unrealizedFinalized = unrealizedFinalized
+	}
+	return true
+}
+
+func (fc *ProtoForkChoice) ProcessPayload(blockRoot Root, blockHash Hash32, status ExecutionStatus) error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.protoArray.ProcessPayload(blockRoot, blockHash, status)
+}
+
+func (fc *ProtoForkChoice) ValidatePayload(blockRoot Root) error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return fc.protoArray.ValidatePayload(blockRoot)
+}
+
+func (fc *ProtoForkChoice) InvalidatePayload(blockRoot Root, latestValidHash Hash32) error {
 	fc.mu.Lock()
 	defer fc.mu.Unlock()
-	return fc.protoArray.ProcessBlock(parentRoot, blockRoot, blockSlot, justifiedEpoch, finalizedEpoch)
+	return fc.protoArray.InvalidatePayload(blockRoot, latestValidHash)
+}
+
+func (fc *ProtoForkChoice) UnrealizedJustified() Checkpoint {
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+	return fc.unrealizedJustified
+}
+
+func (fc *ProtoForkChoice) UnrealizedFinalized() Checkpoint {
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+	return fc.unrealizedFinalized
 }
 
 func (fc *ProtoForkChoice) InSubtree(anchor Root, root Root) (unknown bool, inSubtree bool) {
@@ -216,6 +484,15 @@ func (fc *ProtoForkChoice) Search(anchor NodeRef, parentRoot *Root, slot *Slot)
 	return fc.protoArray.Search(anchor, parentRoot, slot)
 }
 
+func (fc *ProtoForkChoice) DebugNodes(anchorRoot Root, anchorSlot Slot) ([]DebugNode, error) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if err := fc.updateVotesMaybe(); err != nil {
+		return nil, err
+	}
+	return fc.protoArray.DebugNodes(anchorRoot, anchorSlot)
+}
+
 func (fc *ProtoForkChoice) ClosestToSlot(anchor Root, slot Slot) (ref NodeRef, err error) {
 	fc.mu.Lock()
 	defer fc.mu.Unlock()
@@ -234,26 +511,44 @@ func (fc *ProtoForkChoice) GetSlot(root Root) (slot Slot, ok bool) {
 	return fc.protoArray.GetSlot(root)
 }
 
-func (fc *ProtoForkChoice) FindHead(anchorRoot Root, anchorSlot Slot) (NodeRef, error) {
+// GetBlock returns the forkchoice information of the block, with the weight as of the last head computation.
+func (fc *ProtoForkChoice) GetBlock(root Root) (node BlockNode, ok bool) {
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+	return fc.protoArray.GetBlock(root)
+}
+
+func (fc *ProtoForkChoice) FindHead(anchorRoot Root, anchorSlot Slot) (head NodeRef, optimistic bool, err error) {
 	fc.mu.Lock()
 	defer fc.mu.Unlock()
 	if err := fc.updateVotesMaybe(); err != nil {
-		return NodeRef{}, err
+		return NodeRef{}, false, err
 	}
 	return fc.protoArray.FindHead(anchorRoot, anchorSlot)
 }
 
+func (fc *ProtoForkChoice) IsOptimistic(blockRoot Root) bool {
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+	return fc.protoArray.IsOptimistic(blockRoot)
+}
+
 func (fc *ProtoForkChoice) Head() (NodeRef, error) {
 	fc.mu.Lock()
 	defer fc.mu.Unlock()
 	if err := fc.updateVotesMaybe(); err != nil {
 		return NodeRef{}, err
 	}
+	// Start from the justified block itself: the forkchoice children of a later empty slot do not include any blocks.
 	root := fc.justified.Root
-	slot, _ := fc.spec.EpochStartSlot(fc.justified.Epoch)
+	slot, ok := fc.protoArray.GetSlot(root)
+	if !ok {
+		return NodeRef{}, fmt.Errorf("unknown justified block %s", root)
+	}
 	if fc.pin != nil {
 		root = fc.pin.Root
 		slot = fc.pin.Slot
 	}
-	return fc.protoArray.FindHead(root, slot)
+	head, _, err := fc.protoArray.FindHead(root, slot)
+	return head, err
 }
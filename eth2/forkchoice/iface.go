@@ -2,8 +2,13 @@ package forkchoice
 
 import (
 	"context"
+	"fmt"
+	"time"
+
+	"github.com/protolambda/ztyp/codec"
 
 	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
 )
 
 type Root = common.Root
@@ -16,41 +21,136 @@ type NodeRef = common.NodeRef
 type ExtendedNodeRef = common.ExtendedNodeRef
 type SignedGwei int64
 type NodeIndex uint64
+type Hash32 = common.Hash32
+
+// ExecutionStatus is the status of the execution payload of a node, as reported by the execution engine.
+// Nodes without execution payload, e.g. before the merge, are VALID.
+type ExecutionStatus uint8
+
+const (
+	ExecutionValid ExecutionStatus = iota
+	// The execution engine did not validate the payload yet: the node is imported optimistically.
+	ExecutionSyncing
+	ExecutionInvalid
+)
+
+func (s ExecutionStatus) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s ExecutionStatus) String() string {
+	switch s {
+	case ExecutionValid:
+		return "VALID"
+	case ExecutionSyncing:
+		return "SYNCING"
+	case ExecutionInvalid:
+		return "INVALID"
+	default:
+		return fmt.Sprintf("ExecutionStatus(%d)", uint8(s))
+	}
+}
+
+// BlockNode is the forkchoice information of a block.
+type BlockNode struct {
+	Ref        NodeRef
+	ParentRoot Root
+	// The weight of the block and all its descendants.
+	Weight                   Gwei
+	ExecutionStatus          ExecutionStatus
+	JustifiedEpoch           Epoch
+	FinalizedEpoch           Epoch
+	UnrealizedJustifiedEpoch Epoch
+	UnrealizedFinalizedEpoch Epoch
+}
+
+// DebugNode describes a node of the forkchoice graph, for debugging.
+type DebugNode struct {
+	Slot       Slot `json:"slot"`
+	Root       Root `json:"root"`
+	ParentRoot Root `json:"parent_root"`
+	// True if the node is an empty slot, without block.
+	Gap bool `json:"gap"`
+	// The transition parent of the node, nil for the anchor.
+	Parent                   *DebugNodeRef   `json:"parent"`
+	Weight                   Gwei            `json:"weight"`
+	BestChild                *DebugNodeRef   `json:"best_child"`
+	BestDescendant           *DebugNodeRef   `json:"best_descendant"`
+	JustifiedEpoch           Epoch           `json:"justified_epoch"`
+	FinalizedEpoch           Epoch           `json:"finalized_epoch"`
+	UnrealizedJustifiedEpoch Epoch           `json:"unrealized_justified_epoch"`
+	UnrealizedFinalizedEpoch Epoch           `json:"unrealized_finalized_epoch"`
+	ExecutionStatus          ExecutionStatus `json:"execution_status"`
+	Viable                   bool            `json:"viable"`
+	Canonical                bool            `json:"canonical"`
+}
 
 type ForkchoiceView interface {
 	CanonicalChain(anchorRoot Root, anchorSlot Slot) ([]ExtendedNodeRef, error)
 	ClosestToSlot(anchor Root, slot Slot) (closest NodeRef, err error)
 	CanonAtSlot(anchor Root, slot Slot, withBlock bool) (at NodeRef, err error)
 	GetSlot(blockRoot Root) (slot Slot, ok bool)
-	FindHead(anchorRoot Root, anchorSlot Slot) (NodeRef, error)
+	GetBlock(blockRoot Root) (node BlockNode, ok bool)
+	// FindHead finds the best viable head in the subtree of the anchor.
+	// Nodes with an INVALID execution payload are not viable.
+	// The head is optimistic if its execution payload, or that of an ancestor, is not validated yet.
+	FindHead(anchorRoot Root, anchorSlot Slot) (head NodeRef, optimistic bool, err error)
+	// IsOptimistic returns true if the execution payload of the block is not validated yet.
+	IsOptimistic(blockRoot Root) bool
 	InSubtree(anchor Root, root Root) (unknown bool, inSubtree bool)
 	Search(anchor NodeRef, parentRoot *Root, slot *Slot) (nonCanon []NodeRef, canon []NodeRef, err error)
+	// DebugNodes describes the anchor and all nodes after it, parents before children.
+	// Without a viable head the nodes are described without canonical nodes.
+	DebugNodes(anchorRoot Root, anchorSlot Slot) ([]DebugNode, error)
 }
 
 type ForkchoiceNodeInput interface {
 	ProcessSlot(parent Root, slot Slot, justifiedEpoch Epoch, finalizedEpoch Epoch)
-	ProcessBlock(parent Root, blockRoot Root, blockSlot Slot, justifiedEpoch Epoch, finalizedEpoch Epoch) (ok bool)
+	// ProcessBlock adds a block, with the checkpoints of its post-state,
+	// and the unrealized checkpoints: those of the post-state after running justification and finalization.
+	ProcessBlock(parent Root, blockRoot Root, blockSlot Slot, justifiedEpoch Epoch, finalizedEpoch Epoch,
+		unrealizedJustified Checkpoint, unrealizedFinalized Checkpoint) (ok bool)
+	// ProcessPayload registers the execution block hash of a block, and the status of its payload,
+	// as reported when the block was imported.
+	// Blocks without registered payload are VALID, or SYNCING if their parent is SYNCING.
+	ProcessPayload(blockRoot Root, blockHash Hash32, status ExecutionStatus) error
+	// ValidatePayload marks the execution payload of the block, and those of all its ancestors, as VALID.
+	ValidatePayload(blockRoot Root) error
+	// InvalidatePayload marks the execution payload of the block, and those of all its descendants, as INVALID.
+	// If the latest valid hash is found in the ancestors, the blocks after it are INVALID too,
+	// and the latest valid block itself is VALID. Otherwise only the block and its descendants are invalidated.
+	InvalidatePayload(blockRoot Root, latestValidHash Hash32) error
 }
 
 type ForkchoiceGraph interface {
 	ForkchoiceView
 	ForkchoiceNodeInput
 	Indices() map[NodeRef]NodeIndex
-	ApplyScoreChanges(deltas []SignedGwei, justifiedEpoch Epoch, finalizedEpoch Epoch) error
+	ApplyScoreChanges(deltas []SignedGwei, justifiedEpoch Epoch, finalizedEpoch Epoch, currentSlot Slot) error
 	OnPrune(ctx context.Context, anchorRoot Root, anchorSlot Slot) error
+	// The graph can be encoded into, and restored from, a forkchoice snapshot.
+	codec.Serializable
+	codec.Deserializable
 }
 
 type VoteInput interface {
-	// ProcessAttestation overrides any previous vote, and applies voting weight to the new root/slot.
+	// ProcessAttestation overrides any previous vote with an older target epoch,
+	// and applies voting weight to the new root/slot.
 	// If the root/slot combination does not exist, no changes are made, and ok=false is returned.
 	// It is up to the caller if nodes should be added, to then process the attestation.
-	ProcessAttestation(index ValidatorIndex, blockRoot Root, headSlot Slot) (ok bool)
+	ProcessAttestation(index ValidatorIndex, blockRoot Root, headSlot Slot, targetEpoch Epoch) (ok bool)
 }
 
 type VoteStore interface {
 	VoteInput
+	// ProcessEquivocation marks the validator as equivocating: its vote weight is removed, and later votes are ignored.
+	ProcessEquivocation(index ValidatorIndex)
+	IsEquivocating(index ValidatorIndex) bool
 	HasChanges() bool
 	ComputeDeltas(indices map[NodeRef]NodeIndex, oldBalances []Gwei, newBalances []Gwei) []SignedGwei
+	// The vote store can be encoded into, and restored from, a forkchoice snapshot.
+	codec.Serializable
+	codec.Deserializable
 }
 
 type Forkchoice interface {
@@ -64,4 +164,20 @@ type Forkchoice interface {
 	Justified() Checkpoint
 	Finalized() Checkpoint
 	Head() (NodeRef, error)
+	// ProcessAttesterSlashing marks the validators that are slashable by the attester slashing as equivocating.
+	ProcessAttesterSlashing(slashing *phase0.AttesterSlashing) error
+	// BlockArrival registers when a block arrived, as time into the current slot,
+	// to apply the proposer boost to timely blocks.
+	BlockArrival(blockRoot Root, currentSlot Slot, intoSlot time.Duration) (timely bool)
+	// ProcessTick updates the forkchoice to the current slot, removing any outdated proposer boost,
+	// and pulling up the justified and finalized checkpoints at the start of an epoch.
+	ProcessTick(ctx context.Context, currentSlot Slot, justifiedStateBalances func(justified Checkpoint) ([]Gwei, error)) error
+	UnrealizedJustified() Checkpoint
+	UnrealizedFinalized() Checkpoint
+	ProposerBoostRoot() Root
+	// ProposerHead returns the block to build on for a proposal at the given slot, at the given time into the slot:
+	// the head, or the parent of the head if the head is a weak late block that can be re-orged.
+	ProposerHead(headRoot Root, slot Slot, intoSlot time.Duration) (Root, error)
+	// Serialize encodes a snapshot of the forkchoice, see RestoreForkChoice to load it.
+	codec.Serializable
 }
@@ -97,6 +97,7 @@ func LighthouseTestDef() *ForkChoiceTestDef {
 		ValidatorIndex: 0,
 		BlockRoot:      hash(2),
 		HeadSlot:       2,
+		TargetEpoch:    0,
 		CanAdd:         true,
 	})
 
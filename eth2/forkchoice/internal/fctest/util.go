@@ -118,7 +118,7 @@ type OpFindHead struct {
 }
 
 func (op *OpFindHead) Apply(ft *ForkChoiceTestTarget, fc forkchoice.Forkchoice) error {
-	head, err := fc.FindHead(op.AnchorRoot, op.AnchorSlot)
+	head, _, err := fc.FindHead(op.AnchorRoot, op.AnchorSlot)
 	if op.Ok && err != nil {
 		return fmt.Errorf("unexpected error: %v", err)
 	}
@@ -188,7 +188,9 @@ type OpProcessBlock struct {
 }
 
 func (op *OpProcessBlock) Apply(ft *ForkChoiceTestTarget, fc forkchoice.Forkchoice) error {
-	fc.ProcessBlock(op.Parent, op.BlockRoot, op.BlockSlot, op.JustifiedEpoch, op.FinalizedEpoch)
+	// The test blocks are not pulled up: the unrealized checkpoints match the realized checkpoints.
+	fc.ProcessBlock(op.Parent, op.BlockRoot, op.BlockSlot, op.JustifiedEpoch, op.FinalizedEpoch,
+		forkchoice.Checkpoint{Epoch: op.JustifiedEpoch}, forkchoice.Checkpoint{Epoch: op.FinalizedEpoch})
 	return nil
 }
 
@@ -196,11 +198,12 @@ type OpProcessAttestation struct {
 	ValidatorIndex forkchoice.ValidatorIndex
 	BlockRoot      forkchoice.Root
 	HeadSlot       forkchoice.Slot
+	TargetEpoch    forkchoice.Epoch
 	CanAdd         bool
 }
 
 func (op *OpProcessAttestation) Apply(ft *ForkChoiceTestTarget, fc forkchoice.Forkchoice) error {
-	res := fc.ProcessAttestation(op.ValidatorIndex, op.BlockRoot, op.HeadSlot)
+	res := fc.ProcessAttestation(op.ValidatorIndex, op.BlockRoot, op.HeadSlot, op.TargetEpoch)
 	if res != op.CanAdd {
 		return fmt.Errorf("processing attestation different result: canAdd %v <> %v", res, op.CanAdd)
 	}
@@ -226,7 +229,7 @@ type OpUpdateJustified struct {
 }
 
 func (op *OpUpdateJustified) Apply(ft *ForkChoiceTestTarget, fc forkchoice.Forkchoice) error {
-	err := fc.UpdateJustified(context.Background(), op.Trigger, op.Finalized, op.Justified, op.JustifiedStateBalances)
+	err := fc.UpdateJustified(context.Background(), op.Trigger, op.Justified, op.Finalized, op.JustifiedStateBalances)
 	if op.Ok && err != nil {
 		return fmt.Errorf("unexpected error: %v", err)
 	}
@@ -9,6 +9,14 @@ func NewProtoForkChoice(spec *common.Spec, finalized Checkpoint, justified Check
 	anchorRoot Root, anchorSlot Slot, anchorParent Root,
 	initialBalances []Gwei, sink NodeSink) (Forkchoice, error) {
 	return NewForkChoice(spec, finalized, justified, anchorRoot, anchorSlot,
-		NewProtoArray(anchorParent, anchorRoot, anchorSlot, justified.Epoch, finalized.Epoch, sink),
+		NewProtoArray(spec, anchorParent, anchorRoot, anchorSlot, justified.Epoch, finalized.Epoch, sink),
 		NewProtoVoteStore(spec), initialBalances)
 }
+
+// NewProtoStore creates a Store that drives a proto-array forkchoice, starting at the anchor state.
+func NewProtoStore(spec *common.Spec, clock Clock, anchor common.BeaconState, sink NodeSink) (*Store, error) {
+	return NewStore(spec, clock, anchor, func(finalized Checkpoint, justified Checkpoint,
+		anchorRoot Root, anchorSlot Slot, anchorParent Root, initialBalances []Gwei) (Forkchoice, error) {
+		return NewProtoForkChoice(spec, finalized, justified, anchorRoot, anchorSlot, anchorParent, initialBalances, sink)
+	})
+}
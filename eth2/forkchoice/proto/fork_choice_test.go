@@ -1,10 +1,20 @@
 package proto
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/protolambda/ztyp/codec"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/zrnt/eth2/configs"
 	"github.com/protolambda/zrnt/eth2/forkchoice"
 	"github.com/protolambda/zrnt/eth2/forkchoice/internal/fctest"
 )
@@ -30,3 +40,616 @@ func TestProtoArray(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestProtoArrayPrune(t *testing.T) {
+	root := func(i byte) (out forkchoice.Root) {
+		out[0] = i
+		return
+	}
+	var pruned []forkchoice.NodeRef
+	pr := NewProtoArray(configs.Minimal, root(0), root(0), 0, 0, 0, NodeSinkFn(func(ctx context.Context, ref forkchoice.NodeRef, canonical bool) error {
+		if !canonical {
+			return fmt.Errorf("unexpected non-canonical pruned node %s", ref)
+		}
+		pruned = append(pruned, ref)
+		return nil
+	}))
+	pr.ProcessBlock(root(0), root(1), 1, 0, 0, forkchoice.Checkpoint{}, forkchoice.Checkpoint{})
+	pr.ProcessBlock(root(1), root(2), 2, 0, 0, forkchoice.Checkpoint{}, forkchoice.Checkpoint{})
+	pr.ProcessBlock(root(0), root(3), 2, 0, 0, forkchoice.Checkpoint{}, forkchoice.Checkpoint{})
+	pr.ProcessBlock(root(2), root(4), 3, 0, 0, forkchoice.Checkpoint{}, forkchoice.Checkpoint{})
+	if err := pr.OnPrune(context.Background(), root(2), 2); err != nil {
+		t.Fatal(err)
+	}
+	expectedPruned := []forkchoice.NodeRef{{Root: root(0), Slot: 0}, {Root: root(0), Slot: 1}, {Root: root(1), Slot: 1}, {Root: root(1), Slot: 2}}
+	if fmt.Sprint(pruned) != fmt.Sprint(expectedPruned) {
+		t.Fatalf("unexpected pruned nodes: %v, expected %v", pruned, expectedPruned)
+	}
+	if _, ok := pr.GetSlot(root(1)); ok {
+		t.Fatal("pruned block is still known")
+	}
+	if err := pr.ApplyScoreChanges(make([]forkchoice.SignedGwei, len(pr.Indices())), 0, 0, 3); err != nil {
+		t.Fatal(err)
+	}
+	head, _, err := pr.FindHead(root(2), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (forkchoice.NodeRef{Root: root(4), Slot: 3}); head != expected {
+		t.Fatalf("unexpected head %s, expected %s", head, expected)
+	}
+	chain, err := pr.CanonicalChain(root(2), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain) != 3 || chain[2].NodeRef != (forkchoice.NodeRef{Root: root(2), Slot: 2}) {
+		t.Fatalf("unexpected canonical chain after pruning: %v", chain)
+	}
+}
+
+func TestVoteTargetEpoch(t *testing.T) {
+	root := func(i byte) (out forkchoice.Root) {
+		out[0] = i
+		return
+	}
+	spec := configs.Minimal
+	balances := make([]forkchoice.Gwei, 16)
+	for i := range balances {
+		balances[i] = 10
+	}
+	genesis := forkchoice.Checkpoint{Epoch: 0, Root: root(0)}
+	fc, err := NewProtoForkChoice(spec, genesis, genesis, root(0), 0, forkchoice.Root{}, balances, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//  0 - 1
+	//   \
+	//    2
+	fc.ProcessBlock(root(0), root(1), 1, 0, 0, forkchoice.Checkpoint{}, forkchoice.Checkpoint{})
+	fc.ProcessBlock(root(0), root(2), 2, 0, 0, forkchoice.Checkpoint{}, forkchoice.Checkpoint{})
+	expectHead := func(expected forkchoice.Root) {
+		t.Helper()
+		head, err := fc.Head()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if head.Root != expected {
+			t.Fatalf("unexpected head %s, expected %s", head, expected)
+		}
+	}
+	fc.ProcessAttestation(0, root(2), 2, 0)
+	expectHead(root(2))
+	// a vote in the next epoch for the older block is newer, although the block is in the same epoch
+	fc.ProcessAttestation(0, root(1), 1, 1)
+	expectHead(root(1))
+	// a vote with an older target epoch is ignored
+	fc.ProcessAttestation(0, root(2), 2, 0)
+	expectHead(root(1))
+}
+
+func TestDebugNodesNoViableHead(t *testing.T) {
+	root := func(i byte) (out forkchoice.Root) {
+		out[0] = i
+		return
+	}
+	pr := NewProtoArray(configs.Minimal, root(0xff), root(0), 0, 0, 0, nil)
+	pr.ProcessBlock(root(0), root(1), 1, 0, 0, forkchoice.Checkpoint{}, forkchoice.Checkpoint{})
+	// none of the nodes has the justified epoch
+	if err := pr.ApplyScoreChanges(make([]forkchoice.SignedGwei, len(pr.Indices())), 3, 2, 40); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := pr.FindHead(root(0), 0); !errors.Is(err, NoViableHeadErr) {
+		t.Fatalf("expected no viable head, got %v", err)
+	}
+	nodes, err := pr.DebugNodes(root(0), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// anchor, slot 1 without block, block 1
+	if len(nodes) != 3 {
+		t.Fatalf("unexpected number of nodes: %d", len(nodes))
+	}
+	for _, n := range nodes {
+		if n.Canonical || n.Viable {
+			t.Fatalf("unexpected canonical or viable node: %+v", n)
+		}
+	}
+}
+
+func TestProposerBoost(t *testing.T) {
+	root := func(i byte) (out forkchoice.Root) {
+		out[0] = i
+		return
+	}
+	spec := configs.Minimal
+	balances := make([]forkchoice.Gwei, 16)
+	for i := range balances {
+		balances[i] = 10
+	}
+	genesis := forkchoice.Checkpoint{Epoch: 0, Root: root(0)}
+	fc, err := NewProtoForkChoice(spec, genesis, genesis, root(0), 0, forkchoice.Root{}, balances, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Two competing blocks at slot 1, without boost the higher root wins the tie.
+	fc.ProcessBlock(root(0), root(1), 1, 0, 0, forkchoice.Checkpoint{}, forkchoice.Checkpoint{})
+	fc.ProcessBlock(root(0), root(2), 1, 0, 0, forkchoice.Checkpoint{}, forkchoice.Checkpoint{})
+	expectHead := func(expected forkchoice.Root) {
+		t.Helper()
+		head, err := fc.Head()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if head.Root != expected {
+			t.Fatalf("unexpected head %s, expected %s", head, expected)
+		}
+	}
+	expectHead(root(2))
+
+	deadline := time.Duration(spec.SECONDS_PER_SLOT) * time.Second / common.INTERVALS_PER_SLOT
+	if fc.BlockArrival(root(1), 1, deadline) {
+		t.Fatal("block after attestation deadline is not timely")
+	}
+	if fc.BlockArrival(root(1), 2, 0) {
+		t.Fatal("block in later slot is not timely")
+	}
+	if !fc.BlockArrival(root(1), 1, deadline-1) {
+		t.Fatal("expected timely block")
+	}
+	if fc.ProposerBoostRoot() != root(1) {
+		t.Fatal("expected boost for block 1")
+	}
+	expectHead(root(1))
+
+	// the boost (16*10/8*40/100 = 8) is smaller than a single vote
+	if !fc.ProcessAttestation(3, root(2), 1, 0) {
+		t.Fatal("vote not accepted")
+	}
+	expectHead(root(2))
+	if !fc.ProcessAttestation(4, root(1), 1, 0) {
+		t.Fatal("vote not accepted")
+	}
+	expectHead(root(1))
+
+	// the boost is removed in the next slot
+	if err := fc.ProcessTick(context.Background(), 2, nil); err != nil {
+		t.Fatal(err)
+	}
+	if fc.ProposerBoostRoot() != (forkchoice.Root{}) {
+		t.Fatal("expected boost to be removed")
+	}
+	expectHead(root(2))
+}
+
+func TestProposerHead(t *testing.T) {
+	root := func(i byte) (out forkchoice.Root) {
+		out[0] = i
+		return
+	}
+	spec := configs.Minimal
+	balances := make([]forkchoice.Gwei, 16)
+	for i := range balances {
+		balances[i] = 10
+	}
+	genesis := forkchoice.Checkpoint{Epoch: 0, Root: root(0)}
+	fc, err := NewProtoForkChoice(spec, genesis, genesis, root(0), 0, forkchoice.Root{}, balances, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	expectProposerHead := func(head forkchoice.Root, slot forkchoice.Slot, intoSlot time.Duration, expected forkchoice.Root) {
+		t.Helper()
+		got, err := fc.ProposerHead(head, slot, intoSlot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != expected {
+			t.Fatalf("unexpected proposer head %s, expected %s", got, expected)
+		}
+	}
+	// Block 1 is timely, and strong: a committee weighs 20, the parent threshold is 32.
+	fc.ProcessBlock(root(0), root(1), 1, 0, 0, forkchoice.Checkpoint{}, forkchoice.Checkpoint{})
+	fc.BlockArrival(root(1), 1, 0)
+	for i := forkchoice.ValidatorIndex(0); i < 4; i++ {
+		fc.ProcessAttestation(i, root(1), 1, 0)
+	}
+	if err := fc.ProcessTick(ctx, 2, nil); err != nil {
+		t.Fatal(err)
+	}
+	expectProposerHead(root(1), 2, 0, root(1))
+
+	// Block 2 is late, and weak: the head threshold is 4.
+	fc.ProcessBlock(root(1), root(2), 2, 0, 0, forkchoice.Checkpoint{}, forkchoice.Checkpoint{})
+	fc.BlockArrival(root(2), 2, 5*time.Second)
+	if err := fc.ProcessTick(ctx, 3, nil); err != nil {
+		t.Fatal(err)
+	}
+	expectProposerHead(root(2), 3, 0, root(1))
+	// too late into the slot to re-org
+	expectProposerHead(root(2), 3, 2*time.Second, root(2))
+	// not a single slot re-org
+	expectProposerHead(root(2), 4, 0, root(2))
+
+	if !fc.ProcessAttestation(4, root(2), 2, 0) {
+		t.Fatal("vote not accepted")
+	}
+	expectProposerHead(root(2), 3, 0, root(2))
+}
+
+func TestExecutionStatus(t *testing.T) {
+	root := func(i byte) (out forkchoice.Root) {
+		out[0] = i
+		return
+	}
+	hash := func(i byte) (out forkchoice.Hash32) {
+		out[0] = 0xee
+		out[1] = i
+		return
+	}
+	spec := configs.Minimal
+	balances := make([]forkchoice.Gwei, 16)
+	for i := range balances {
+		balances[i] = 10
+	}
+	genesis := forkchoice.Checkpoint{Epoch: 0, Root: root(0)}
+	fc, err := NewProtoForkChoice(spec, genesis, genesis, root(0), 0, forkchoice.Root{}, balances, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//  0 - 1 - 2 - 3
+	//       \
+	//        4 - 6
+	addBlock := func(parent byte, block byte, slot forkchoice.Slot) {
+		t.Helper()
+		if !fc.ProcessBlock(root(parent), root(block), slot, 0, 0, forkchoice.Checkpoint{}, forkchoice.Checkpoint{}) {
+			t.Fatalf("block %d not accepted", block)
+		}
+		if err := fc.ProcessPayload(root(block), hash(block), forkchoice.ExecutionSyncing); err != nil {
+			t.Fatal(err)
+		}
+	}
+	addBlock(0, 1, 1)
+	addBlock(1, 2, 2)
+	addBlock(2, 3, 3)
+	addBlock(1, 4, 2)
+	fc.ProcessAttestation(0, root(3), 3, 0)
+	expectHead := func(expected forkchoice.Root, expectedOptimistic bool) {
+		t.Helper()
+		head, optimistic, err := fc.FindHead(root(0), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if head.Root != expected || optimistic != expectedOptimistic {
+			t.Fatalf("unexpected head %s (optimistic: %v), expected %s (optimistic: %v)",
+				head, optimistic, expected, expectedOptimistic)
+		}
+	}
+	expectHead(root(3), true)
+
+	// a block without registered payload inherits the optimistic status of its parent
+	if !fc.ProcessBlock(root(4), root(6), 3, 0, 0, forkchoice.Checkpoint{}, forkchoice.Checkpoint{}) {
+		t.Fatal("block 6 not accepted")
+	}
+	if node, _ := fc.GetBlock(root(6)); node.ExecutionStatus != forkchoice.ExecutionSyncing {
+		t.Fatalf("expected block 6 to be syncing, got %s", node.ExecutionStatus)
+	}
+	if !fc.IsOptimistic(root(6)) {
+		t.Fatal("expected block 6 to be optimistic")
+	}
+
+	// the latest valid hash is that of block 1: block 2 is invalid too
+	if err := fc.InvalidatePayload(root(3), hash(1)); err != nil {
+		t.Fatal(err)
+	}
+	if fc.IsOptimistic(root(1)) {
+		t.Fatal("expected block 1 to be validated")
+	}
+	if node, _ := fc.GetBlock(root(2)); node.ExecutionStatus != forkchoice.ExecutionInvalid {
+		t.Fatalf("expected block 2 to be invalid, got %s", node.ExecutionStatus)
+	}
+	expectHead(root(6), true)
+	if fc.ProcessBlock(root(3), root(5), 4, 0, 0, forkchoice.Checkpoint{}, forkchoice.Checkpoint{}) {
+		t.Fatal("expected block on top of invalid payload to be rejected")
+	}
+	if err := fc.ValidatePayload(root(3)); err == nil {
+		t.Fatal("expected error when validating invalid payload")
+	}
+
+	if err := fc.ValidatePayload(root(4)); err != nil {
+		t.Fatal(err)
+	}
+	// validating the parent does not validate the child
+	expectHead(root(6), true)
+	if err := fc.ValidatePayload(root(6)); err != nil {
+		t.Fatal(err)
+	}
+	expectHead(root(6), false)
+	if err := fc.InvalidatePayload(root(4), forkchoice.Hash32{}); err == nil {
+		t.Fatal("expected error when invalidating valid payload")
+	}
+}
+
+func TestAttesterSlashing(t *testing.T) {
+	root := func(i byte) (out forkchoice.Root) {
+		out[0] = i
+		return
+	}
+	spec := configs.Minimal
+	balances := make([]forkchoice.Gwei, 16)
+	for i := range balances {
+		balances[i] = 10
+	}
+	genesis := forkchoice.Checkpoint{Epoch: 0, Root: root(0)}
+	fc, err := NewProtoForkChoice(spec, genesis, genesis, root(0), 0, forkchoice.Root{}, balances, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc.ProcessBlock(root(0), root(1), 1, 0, 0, forkchoice.Checkpoint{}, forkchoice.Checkpoint{})
+	fc.ProcessBlock(root(0), root(2), 1, 0, 0, forkchoice.Checkpoint{}, forkchoice.Checkpoint{})
+	for _, i := range []forkchoice.ValidatorIndex{0, 1} {
+		fc.ProcessAttestation(i, root(1), 1, 0)
+	}
+	fc.ProcessAttestation(2, root(2), 1, 0)
+	if head, err := fc.Head(); err != nil {
+		t.Fatal(err)
+	} else if head.Root != root(1) {
+		t.Fatalf("unexpected head %s", head)
+	}
+
+	slashing := &phase0.AttesterSlashing{
+		Attestation1: phase0.IndexedAttestation{
+			AttestingIndices: []common.ValidatorIndex{0, 1},
+			Data:             phase0.AttestationData{Slot: 1, BeaconBlockRoot: root(1)},
+		},
+		Attestation2: phase0.IndexedAttestation{
+			AttestingIndices: []common.ValidatorIndex{1, 5},
+			Data:             phase0.AttestationData{Slot: 1, BeaconBlockRoot: root(2)},
+		},
+	}
+	if err := fc.ProcessAttesterSlashing(slashing); err != nil {
+		t.Fatal(err)
+	}
+	// validator 1 loses its weight, the tie is broken by root.
+	if head, err := fc.Head(); err != nil {
+		t.Fatal(err)
+	} else if head.Root != root(2) {
+		t.Fatalf("unexpected head %s after slashing", head)
+	}
+	if fc.ProcessAttestation(1, root(1), 2, 0) {
+		t.Fatal("expected vote of equivocating validator to be ignored")
+	}
+
+	notSlashable := &phase0.AttesterSlashing{Attestation1: slashing.Attestation1, Attestation2: slashing.Attestation1}
+	if err := fc.ProcessAttesterSlashing(notSlashable); err == nil {
+		t.Fatal("expected error for equal attestations")
+	}
+}
+
+func TestUnrealizedJustification(t *testing.T) {
+	root := func(i byte) (out forkchoice.Root) {
+		out[0] = i
+		return
+	}
+	spec := configs.Minimal
+	balances := make([]forkchoice.Gwei, 16)
+	for i := range balances {
+		balances[i] = 10
+	}
+	// anchor at the start of epoch 2, with epoch 1 justified and finalized.
+	anchor := forkchoice.Checkpoint{Epoch: 1, Root: root(0)}
+	fc, err := NewProtoForkChoice(spec, anchor, anchor, root(0), 16, forkchoice.Root{}, balances, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// block 1 justifies epoch 2 when pulled up, block 2 does not.
+	pulledUp := forkchoice.Checkpoint{Epoch: 2, Root: root(0)}
+	fc.ProcessBlock(root(0), root(1), 17, 1, 1, pulledUp, anchor)
+	fc.ProcessBlock(root(0), root(2), 18, 1, 1, anchor, anchor)
+	if fc.UnrealizedJustified() != pulledUp {
+		t.Fatalf("unexpected unrealized justified checkpoint %s", fc.UnrealizedJustified())
+	}
+	for i := forkchoice.ValidatorIndex(0); i < 4; i++ {
+		fc.ProcessAttestation(i, root(2), 18, 2)
+	}
+	expectHead := func(expected forkchoice.Root) {
+		t.Helper()
+		head, err := fc.Head()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if head.Root != expected {
+			t.Fatalf("unexpected head %s, expected %s", head, expected)
+		}
+	}
+	expectHead(root(2))
+
+	// no pull-up within the epoch
+	if err := fc.ProcessTick(context.Background(), 23, nil); err != nil {
+		t.Fatal(err)
+	}
+	if fc.Justified() != anchor {
+		t.Fatal("unexpected pull-up within epoch")
+	}
+	var requested forkchoice.Checkpoint
+	if err := fc.ProcessTick(context.Background(), 24, func(justified forkchoice.Checkpoint) ([]forkchoice.Gwei, error) {
+		requested = justified
+		return balances, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if fc.Justified() != pulledUp || requested != pulledUp {
+		t.Fatalf("expected justified checkpoint to be pulled up, got %s", fc.Justified())
+	}
+	// block 2 has a voting source that is too old now.
+	expectHead(root(1))
+}
+
+func TestSnapshot(t *testing.T) {
+	root := func(i byte) (out forkchoice.Root) {
+		out[0] = i
+		return
+	}
+	spec := configs.Minimal
+	balances := make([]forkchoice.Gwei, 16)
+	for i := range balances {
+		balances[i] = 10
+	}
+	genesis := forkchoice.Checkpoint{Epoch: 0, Root: root(0)}
+	fc, err := NewProtoForkChoice(spec, genesis, genesis, root(0), 0, forkchoice.Root{}, balances, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fc.ProcessBlock(root(0), root(1), 1, 0, 0, forkchoice.Checkpoint{}, forkchoice.Checkpoint{})
+	fc.ProcessBlock(root(0), root(2), 1, 0, 0, forkchoice.Checkpoint{}, forkchoice.Checkpoint{})
+	fc.BlockArrival(root(2), 1, 0)
+	fc.ProcessBlock(root(1), root(3), 3, 0, 0, forkchoice.Checkpoint{}, forkchoice.Checkpoint{})
+	if err := fc.ProcessPayload(root(3), forkchoice.Hash32{0xee}, forkchoice.ExecutionSyncing); err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range []forkchoice.ValidatorIndex{0, 1, 2} {
+		fc.ProcessAttestation(i, root(3), 3, 0)
+	}
+	slashing := &phase0.AttesterSlashing{
+		Attestation1: phase0.IndexedAttestation{
+			AttestingIndices: []common.ValidatorIndex{4},
+			Data:             phase0.AttestationData{Slot: 1, BeaconBlockRoot: root(1)},
+		},
+		Attestation2: phase0.IndexedAttestation{
+			AttestingIndices: []common.ValidatorIndex{4},
+			Data:             phase0.AttestationData{Slot: 1, BeaconBlockRoot: root(2)},
+		},
+	}
+	if err := fc.ProcessAttesterSlashing(slashing); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fc.Head(); err != nil {
+		t.Fatal(err)
+	}
+	// a vote that is not applied yet
+	fc.ProcessAttestation(5, root(2), 1, 0)
+
+	var buf bytes.Buffer
+	if err := fc.Serialize(codec.NewEncodingWriter(&buf)); err != nil {
+		t.Fatal(err)
+	}
+	if size := fc.ByteLength(); size != uint64(buf.Len()) {
+		t.Fatalf("byte length %d does not match encoded size %d", size, buf.Len())
+	}
+	data := buf.Bytes()
+	restored, err := RestoreProtoForkChoice(spec, codec.NewDecodingReader(bytes.NewReader(data), uint64(len(data))), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var again bytes.Buffer
+	if err := restored.Serialize(codec.NewEncodingWriter(&again)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, again.Bytes()) {
+		t.Fatal("snapshot of restored forkchoice differs")
+	}
+	if restored.Justified() != fc.Justified() || restored.ProposerBoostRoot() != fc.ProposerBoostRoot() {
+		t.Fatal("checkpoints or proposer boost differ")
+	}
+	if !restored.IsOptimistic(root(3)) {
+		t.Fatal("expected block 3 to be optimistic")
+	}
+	if restored.ProcessAttestation(4, root(3), 3, 0) {
+		t.Fatal("expected equivocating validator to be restored")
+	}
+	for _, f := range []forkchoice.Forkchoice{fc, restored} {
+		f.ProcessAttestation(6, root(2), 1, 0)
+	}
+	head, err := fc.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restoredHead, err := restored.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head != restoredHead {
+		t.Fatalf("restored head %s differs from head %s", restoredHead, head)
+	}
+	chain, err := fc.CanonicalChain(root(0), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restoredChain, err := restored.CanonicalChain(root(0), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(chain) != fmt.Sprint(restoredChain) {
+		t.Fatalf("restored canonical chain %v differs from %v", restoredChain, chain)
+	}
+}
+
+func TestExportTree(t *testing.T) {
+	root := func(i byte) (out forkchoice.Root) {
+		out[0] = i
+		return
+	}
+	spec := configs.Minimal
+	balances := make([]forkchoice.Gwei, 16)
+	for i := range balances {
+		balances[i] = 10
+	}
+	genesis := forkchoice.Checkpoint{Epoch: 0, Root: root(0)}
+	// the zero root is not used for the anchor parent, it would equal the anchor root.
+	fc, err := NewProtoForkChoice(spec, genesis, genesis, root(0), 0, root(0xff), balances, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//  0 - 1 - gap - 3
+	//   \
+	//    2
+	fc.ProcessBlock(root(0), root(1), 1, 0, 0, forkchoice.Checkpoint{}, forkchoice.Checkpoint{})
+	fc.ProcessBlock(root(0), root(2), 1, 0, 0, forkchoice.Checkpoint{}, forkchoice.Checkpoint{})
+	fc.ProcessBlock(root(1), root(3), 3, 0, 0, forkchoice.Checkpoint{}, forkchoice.Checkpoint{})
+	fc.ProcessAttestation(0, root(3), 3, 0)
+
+	tree, err := forkchoice.ExportTree(fc, root(0), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (forkchoice.DebugNodeRef{Root: root(3), Slot: 3}); tree.Head != expected {
+		t.Fatalf("unexpected head %s, expected %s", tree.Head, expected)
+	}
+	// anchor, slot 1 without block, blocks 1 and 2, slots 2 and 3 without block, block 3
+	if len(tree.Nodes) != 7 {
+		t.Fatalf("unexpected number of nodes: %d", len(tree.Nodes))
+	}
+	var gaps, canonical int
+	for _, n := range tree.Nodes {
+		if n.Gap {
+			gaps++
+		}
+		if n.Canonical {
+			canonical++
+		}
+		if n.Root == root(2) && (n.Canonical || n.Weight != 0) {
+			t.Fatal("expected block 2 to be non-canonical, without weight")
+		}
+		if n.Root == root(1) && !n.Gap && (n.Weight != 10 || n.BestDescendant == nil || n.BestDescendant.Root != root(3)) {
+			t.Fatalf("unexpected block 1 node: %+v", n)
+		}
+	}
+	if gaps != 3 || canonical != 6 {
+		t.Fatalf("unexpected gaps %d and canonical nodes %d", gaps, canonical)
+	}
+	data, err := json.Marshal(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte(`"execution_status":"VALID"`)) ||
+		!bytes.Contains(data, []byte(`"head":{"slot":"3","root":"0x03`)) ||
+		!bytes.Contains(data, []byte(`"best_descendant":{"slot":"3","root":"0x03`)) {
+		t.Fatalf("unexpected json: %s", data)
+	}
+	var dot bytes.Buffer
+	if err := tree.WriteDOT(&dot); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(dot.String(), "digraph forkchoice {") || strings.Count(dot.String(), "->") != 6 {
+		t.Fatalf("unexpected dot output:\n%s", dot.String())
+	}
+}
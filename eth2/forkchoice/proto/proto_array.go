@@ -23,7 +23,13 @@ type ProtoNode struct {
 	ParentRoot     Root
 	JustifiedEpoch Epoch
 	FinalizedEpoch Epoch
-	Weight         SignedGwei
+	// The checkpoints of the node if justification would run on its state: the "pulled-up" epochs.
+	UnrealizedJustifiedEpoch Epoch
+	UnrealizedFinalizedEpoch Epoch
+	Weight                   SignedGwei
+	// The execution payload of the block, or of the last block before an empty slot.
+	ExecutionBlockHash Hash32
+	ExecutionStatus    ExecutionStatus
 	// Relative to ForkchoiceParent relations
 	BestChild NodeIndex
 	// Relative to ForkchoiceParent relations
@@ -45,11 +51,13 @@ type NodeSink interface {
 // Gap slots just have a single node.
 // There may be multiple nodes with the same parent but different blocks (i.e. double proposals, but slashable).
 type ProtoArray struct {
+	spec           *common.Spec
 	sink           NodeSink
-	indexOffset    NodeIndex
 	justifiedEpoch Epoch
 	finalizedEpoch Epoch
-	nodes          []ProtoNode
+	// The current slot, as last seen by ApplyScoreChanges, to determine the voting source of nodes.
+	currentSlot Slot
+	nodes       []ProtoNode
 	// maintains only nodes that are actually part of the tree starting from finalized point.
 	indices map[NodeRef]NodeIndex
 	// Tracks the first slot at or after the block root that the array knows of.
@@ -60,11 +68,12 @@ type ProtoArray struct {
 
 var _ ForkchoiceGraph = (*ProtoArray)(nil)
 
-func NewProtoArray(parent Root, blockRoot Root, blockSlot Slot, justifiedEpoch Epoch, finalizedEpoch Epoch, sink NodeSink) *ProtoArray {
+func NewProtoArray(spec *common.Spec, parent Root, blockRoot Root, blockSlot Slot, justifiedEpoch Epoch, finalizedEpoch Epoch, sink NodeSink) *ProtoArray {
 	blockRef := NodeRef{Root: blockRoot, Slot: blockSlot}
 	pr := ProtoArray{
+		spec:               spec,
+		currentSlot:        blockSlot,
 		sink:               sink,
-		indexOffset:        0,
 		justifiedEpoch:     justifiedEpoch,
 		finalizedEpoch:     finalizedEpoch,
 		nodes:              make([]ProtoNode, 0, 100),
@@ -81,9 +90,12 @@ func NewProtoArray(parent Root, blockRoot Root, blockSlot Slot, justifiedEpoch E
 		ParentRoot:       parent,
 		JustifiedEpoch:   justifiedEpoch,
 		FinalizedEpoch:   finalizedEpoch,
-		Weight:           0,
-		BestChild:        NONE,
-		BestDescendant:   NONE,
+		// The anchor is justified and finalized by itself, it does not get pulled up.
+		UnrealizedJustifiedEpoch: justifiedEpoch,
+		UnrealizedFinalizedEpoch: finalizedEpoch,
+		Weight:                   0,
+		BestChild:                NONE,
+		BestDescendant:           NONE,
 	})
 	return &pr
 }
@@ -91,14 +103,10 @@ func NewProtoArray(parent Root, blockRoot Root, blockSlot Slot, justifiedEpoch E
 var invalidIndexErr = errors.New("invalid index")
 
 func (pr *ProtoArray) getNode(index NodeIndex) (*ProtoNode, error) {
-	if index < pr.indexOffset {
+	if index >= NodeIndex(len(pr.nodes)) {
 		return nil, invalidIndexErr
 	}
-	i := index - pr.indexOffset
-	if i > NodeIndex(len(pr.nodes)) {
-		return nil, invalidIndexErr
-	}
-	return &pr.nodes[i], nil
+	return &pr.nodes[index], nil
 }
 
 func (pr *ProtoArray) Indices() map[NodeRef]NodeIndex {
@@ -108,13 +116,13 @@ func (pr *ProtoArray) Indices() map[NodeRef]NodeIndex {
 // From head back to anchor root (including the anchor itself, if present) and anchor slot.
 // Includes nodes with empty block, then followed up by a node with the block if there is any.
 func (pr *ProtoArray) CanonicalChain(anchorRoot Root, anchorSlot Slot) ([]ExtendedNodeRef, error) {
-	head, err := pr.FindHead(anchorRoot, anchorSlot)
+	head, _, err := pr.FindHead(anchorRoot, anchorSlot)
 	if err != nil {
 		return nil, err
 	}
 	chain := make([]ExtendedNodeRef, 0, len(pr.nodes))
 	index := pr.indices[head]
-	for index != NONE && index >= pr.indexOffset {
+	for index != NONE {
 		node, err := pr.getNode(index)
 		if err != nil {
 			return nil, err
@@ -125,6 +133,62 @@ func (pr *ProtoArray) CanonicalChain(anchorRoot Root, anchorSlot Slot) ([]Extend
 	return chain, nil
 }
 
+// DebugNodes describes the anchor and all nodes after it.
+// If there is no viable head, the nodes are still described, but none of them is canonical.
+func (pr *ProtoArray) DebugNodes(anchorRoot Root, anchorSlot Slot) ([]DebugNode, error) {
+	anchorIndex := pr.indices[NodeRef{Root: anchorRoot, Slot: anchorSlot}]
+	canonical := make(map[NodeIndex]struct{})
+	head, _, err := pr.FindHead(anchorRoot, anchorSlot)
+	if err == nil {
+		for i := pr.indices[head]; i != NONE && i >= anchorIndex; i = pr.nodes[i].TransitionParent {
+			canonical[i] = struct{}{}
+		}
+	} else if !errors.Is(err, NoViableHeadErr) {
+		return nil, err
+	}
+	ref := func(i NodeIndex) *DebugNodeRef {
+		if i == NONE {
+			return nil
+		}
+		r := &pr.nodes[i].Ref
+		return &DebugNodeRef{Slot: r.Slot, Root: r.Root}
+	}
+	// Parents are always added before their children: the subtree is found in a single pass.
+	inSubtree := map[NodeIndex]struct{}{anchorIndex: {}}
+	var out []DebugNode
+	for i := anchorIndex; i < NodeIndex(len(pr.nodes)); i++ {
+		node := &pr.nodes[i]
+		if i != anchorIndex {
+			if _, ok := inSubtree[node.TransitionParent]; !ok {
+				continue
+			}
+			inSubtree[i] = struct{}{}
+		}
+		_, canon := canonical[i]
+		dn := DebugNode{
+			Slot:                     node.Ref.Slot,
+			Root:                     node.Ref.Root,
+			ParentRoot:               node.ParentRoot,
+			Gap:                      node.Ref.Root == node.ParentRoot,
+			Weight:                   Gwei(node.Weight),
+			BestChild:                ref(node.BestChild),
+			BestDescendant:           ref(node.BestDescendant),
+			JustifiedEpoch:           node.JustifiedEpoch,
+			FinalizedEpoch:           node.FinalizedEpoch,
+			UnrealizedJustifiedEpoch: node.UnrealizedJustifiedEpoch,
+			UnrealizedFinalizedEpoch: node.UnrealizedFinalizedEpoch,
+			ExecutionStatus:          node.ExecutionStatus,
+			Viable:                   pr.isNodeViableForHead(node),
+			Canonical:                canon,
+		}
+		if i != anchorIndex {
+			dn.Parent = ref(node.TransitionParent)
+		}
+		out = append(out, dn)
+	}
+	return out, nil
+}
+
 // Returns the closest empty-slot node to the given slot. Nodes with blocks after the anchor are ignored.
 func (pr *ProtoArray) ClosestToSlot(anchor Root, slot Slot) (closest NodeRef, err error) {
 	max := NodeRef{Root: anchor, Slot: slot}
@@ -191,18 +255,19 @@ func (pr *ProtoArray) CanonAtSlot(anchor Root, slot Slot, withBlock bool) (at No
 		}
 		return ref, nil
 	}
-	head, err := pr.FindHead(anchor, anchorSlot)
+	head, _, err := pr.FindHead(anchor, anchorSlot)
 	if err != nil {
 		return NodeRef{}, err
 	}
 	// The head may be the closest we have.
-	if head.Slot <= slot {
+	// If the head is at the slot itself, walk back to distinguish the block node from the slot node.
+	if head.Slot < slot {
 		return head, nil
 	}
 	// Walk back the canonical chain, and stop as soon as we find the node at slot of interest.
 	index := pr.indices[head]
 	var node *ProtoNode
-	for index != NONE && index >= pr.indexOffset {
+	for index != NONE {
 		node, err = pr.getNode(index)
 		if err != nil {
 			return NodeRef{}, err
@@ -232,11 +297,45 @@ func (pr *ProtoArray) GetSlot(blockRoot Root) (Slot, bool) {
 	return slot, ok
 }
 
+// blockNode returns the node of the block. The block node may have been pruned, if only its later slot nodes are left.
+func (pr *ProtoArray) blockNode(blockRoot Root) (*ProtoNode, bool) {
+	slot, ok := pr.blockSlots[blockRoot]
+	if !ok {
+		return nil, false
+	}
+	index, ok := pr.indices[NodeRef{Root: blockRoot, Slot: slot}]
+	if !ok {
+		return nil, false
+	}
+	node := &pr.nodes[index]
+	if node.ParentRoot == blockRoot {
+		return nil, false
+	}
+	return node, true
+}
+
+func (pr *ProtoArray) GetBlock(blockRoot Root) (BlockNode, bool) {
+	node, ok := pr.blockNode(blockRoot)
+	if !ok {
+		return BlockNode{}, false
+	}
+	return BlockNode{
+		Ref:                      node.Ref,
+		ParentRoot:               node.ParentRoot,
+		Weight:                   Gwei(node.Weight),
+		ExecutionStatus:          node.ExecutionStatus,
+		JustifiedEpoch:           node.JustifiedEpoch,
+		FinalizedEpoch:           node.FinalizedEpoch,
+		UnrealizedJustifiedEpoch: node.UnrealizedJustifiedEpoch,
+		UnrealizedFinalizedEpoch: node.UnrealizedFinalizedEpoch,
+	}, true
+}
+
 // Searches the available nodes for blocks with a matching parent root and/or matching slot.
 // If no options are specified, the
 func (pr *ProtoArray) Search(anchor NodeRef, parentRoot *Root, slot *Slot) (nonCanon []NodeRef, canon []NodeRef, err error) {
 	// this also checks that the anchor exists and updates the node connections.
-	head, err := pr.FindHead(anchor.Root, anchor.Slot)
+	head, _, err := pr.FindHead(anchor.Root, anchor.Slot)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -296,26 +395,25 @@ var lengthMismatchErr = errors.New("length mismatch")
 // - Compare the current node with the parents best-child, updating it if the current node
 // should become the best child.
 // - If required, update the parents best-descendant with the current node or its best-descendant.
-func (pr *ProtoArray) ApplyScoreChanges(deltas []SignedGwei, justifiedEpoch Epoch, finalizedEpoch Epoch) error {
+func (pr *ProtoArray) ApplyScoreChanges(deltas []SignedGwei, justifiedEpoch Epoch, finalizedEpoch Epoch, currentSlot Slot) error {
 	if len(deltas) != len(pr.nodes) {
 		return lengthMismatchErr
 	}
-	if justifiedEpoch != pr.justifiedEpoch || finalizedEpoch != pr.finalizedEpoch {
-		pr.justifiedEpoch = justifiedEpoch
-		pr.finalizedEpoch = finalizedEpoch
-	}
+	pr.justifiedEpoch = justifiedEpoch
+	pr.finalizedEpoch = finalizedEpoch
+	pr.currentSlot = currentSlot
 	for i := len(pr.nodes) - 1; i >= 0; i-- {
 		delta := deltas[i]
 		node := &pr.nodes[i]
 		node.Weight += delta
 		if node.ForkchoiceParent != NONE {
-			deltas[node.ForkchoiceParent-pr.indexOffset] += delta
+			deltas[node.ForkchoiceParent] += delta
 		}
 	}
 	for i := len(pr.nodes) - 1; i >= 0; i-- {
 		node := &pr.nodes[i]
 		if node.ForkchoiceParent != NONE {
-			if err := pr.maybeUpdateBestChildAndDescendant(node.ForkchoiceParent, pr.indexOffset+NodeIndex(i)); err != nil {
+			if err := pr.maybeUpdateBestChildAndDescendant(node.ForkchoiceParent, NodeIndex(i)); err != nil {
 				return err
 			}
 		}
@@ -328,7 +426,7 @@ func (pr *ProtoArray) updateConnections() error {
 	for i := len(pr.nodes) - 1; i >= 0; i-- {
 		node := &pr.nodes[i]
 		if node.ForkchoiceParent != NONE {
-			if err := pr.maybeUpdateBestChildAndDescendant(node.ForkchoiceParent, pr.indexOffset+NodeIndex(i)); err != nil {
+			if err := pr.maybeUpdateBestChildAndDescendant(node.ForkchoiceParent, NodeIndex(i)); err != nil {
 				return err
 			}
 		}
@@ -362,7 +460,7 @@ func (pr *ProtoArray) ProcessSlot(parent Root, slot Slot, justifiedEpoch Epoch,
 				continue
 			}
 			// No node to represent space between parent slot and new slot yet, so we add it.
-			nodeIndex = pr.indexOffset + NodeIndex(len(pr.nodes))
+			nodeIndex = NodeIndex(len(pr.nodes))
 			pr.indices[nodeRef] = nodeIndex
 			pr.nodes = append(pr.nodes, ProtoNode{
 				Ref:              nodeRef,
@@ -371,16 +469,20 @@ func (pr *ProtoArray) ProcessSlot(parent Root, slot Slot, justifiedEpoch Epoch,
 				ParentRoot:       parent,
 				JustifiedEpoch:   justifiedEpoch,
 				FinalizedEpoch:   finalizedEpoch,
-				Weight:           0,
-				BestChild:        NONE,
-				BestDescendant:   NONE,
+				// Empty slots do not change the unrealized checkpoints.
+				UnrealizedJustifiedEpoch: justifiedEpoch,
+				UnrealizedFinalizedEpoch: finalizedEpoch,
+				Weight:                   0,
+				BestChild:                NONE,
+				BestDescendant:           NONE,
 			})
+			pr.inheritExecution(nodeIndex)
 			// remember the node as parent for the next
 			parentIndex = nodeIndex
 		}
 	}
 	// Add the node for the slot
-	nodeIndex := pr.indexOffset + NodeIndex(len(pr.nodes))
+	nodeIndex := NodeIndex(len(pr.nodes))
 	pr.indices[nodeRef] = nodeIndex
 	pr.nodes = append(pr.nodes, ProtoNode{
 		Ref:              nodeRef,
@@ -389,19 +491,38 @@ func (pr *ProtoArray) ProcessSlot(parent Root, slot Slot, justifiedEpoch Epoch,
 		ParentRoot:       parent,
 		JustifiedEpoch:   justifiedEpoch,
 		FinalizedEpoch:   finalizedEpoch,
-		Weight:           0,
-		BestChild:        NONE,
-		BestDescendant:   NONE,
+		// Empty slots do not change the unrealized checkpoints.
+		UnrealizedJustifiedEpoch: justifiedEpoch,
+		UnrealizedFinalizedEpoch: finalizedEpoch,
+		Weight:                   0,
+		BestChild:                NONE,
+		BestDescendant:           NONE,
 	})
+	pr.inheritExecution(nodeIndex)
 	// Connections are out of sync, i.e. array needs work before next find-head can return the proper head.
 	pr.updatedConnections = false
 }
 
+// inheritExecution copies the execution payload info of the transition parent to the empty slot node.
+func (pr *ProtoArray) inheritExecution(index NodeIndex) {
+	node := &pr.nodes[index]
+	if node.TransitionParent == NONE {
+		return
+	}
+	parent := &pr.nodes[node.TransitionParent]
+	node.ExecutionBlockHash = parent.ExecutionBlockHash
+	node.ExecutionStatus = parent.ExecutionStatus
+}
+
 // Register a block with the fork choice. Calls OnSlot to add any missing slot nodes.
 // If justified or finalized in-between, make sure to call OnSlot with accurate details first.
 //
+// The unrealized checkpoints are those of the post-block state after running justification and finalization,
+// only the epochs are tracked by the graph.
+//
 // The parent root of the genesis block should be zeroed.
-func (pr *ProtoArray) ProcessBlock(parent Root, blockRoot Root, blockSlot Slot, justifiedEpoch Epoch, finalizedEpoch Epoch) (ok bool) {
+func (pr *ProtoArray) ProcessBlock(parent Root, blockRoot Root, blockSlot Slot, justifiedEpoch Epoch, finalizedEpoch Epoch,
+	unrealizedJustified Checkpoint, unrealizedFinalized Checkpoint) (ok bool) {
 	blockRef := NodeRef{Root: blockRoot, Slot: blockSlot}
 	// If the block is already known, simply ignore it.
 	if _, ok := pr.indices[blockRef]; ok {
@@ -416,6 +537,11 @@ func (pr *ProtoArray) ProcessBlock(parent Root, blockRoot Root, blockSlot Slot,
 	if !ok || parentBlockSlot >= blockSlot {
 		return false
 	}
+	// Blocks on top of an invalid execution payload are invalid.
+	if parentIndex, ok := pr.indices[NodeRef{Slot: parentBlockSlot, Root: parent}]; ok &&
+		pr.nodes[parentIndex].ExecutionStatus == ExecutionInvalid {
+		return false
+	}
 	pr.ProcessSlot(parent, blockSlot, justifiedEpoch, finalizedEpoch)
 
 	// If the parent node is not known, we cannot add the block.
@@ -429,20 +555,26 @@ func (pr *ProtoArray) ProcessBlock(parent Root, blockRoot Root, blockSlot Slot,
 	if !ok {
 		panic("OnSlot failed to add node for block slot (transition parent)")
 	}
-	nodeIndex := pr.indexOffset + NodeIndex(len(pr.nodes))
+	nodeIndex := NodeIndex(len(pr.nodes))
 	pr.blockSlots[blockRoot] = blockSlot
 	pr.indices[blockRef] = nodeIndex
 	pr.nodes = append(pr.nodes, ProtoNode{
-		Ref:              blockRef,
-		TransitionParent: transitionParentIndex,
-		ForkchoiceParent: forkchoiceParentIndex,
-		ParentRoot:       parent,
-		JustifiedEpoch:   justifiedEpoch,
-		FinalizedEpoch:   finalizedEpoch,
-		Weight:           0,
-		BestChild:        NONE,
-		BestDescendant:   NONE,
+		Ref:                      blockRef,
+		TransitionParent:         transitionParentIndex,
+		ForkchoiceParent:         forkchoiceParentIndex,
+		ParentRoot:               parent,
+		JustifiedEpoch:           justifiedEpoch,
+		FinalizedEpoch:           finalizedEpoch,
+		UnrealizedJustifiedEpoch: unrealizedJustified.Epoch,
+		UnrealizedFinalizedEpoch: unrealizedFinalized.Epoch,
+		Weight:                   0,
+		BestChild:                NONE,
+		BestDescendant:           NONE,
 	})
+	// A block cannot be more valid than its parent: the payload of a child of an optimistic block is optimistic too.
+	if pr.nodes[transitionParentIndex].ExecutionStatus == ExecutionSyncing {
+		pr.nodes[nodeIndex].ExecutionStatus = ExecutionSyncing
+	}
 	// Connections are out of sync, i.e. array needs work before next find-head can return the proper head.
 	pr.updatedConnections = false
 	return true
@@ -459,20 +591,22 @@ var NoViableHeadErr = errors.New("not a viable head anymore, invalid forkchoice
 // been called without a subsequent `applyScoreChanges` call. This is because
 // `OnBlock` does not attempt to walk backwards through the tree and update the
 // best-child/best-descendant links.
-func (pr *ProtoArray) FindHead(anchorRoot Root, anchorSlot Slot) (NodeRef, error) {
+//
+// The head is optimistic if its execution payload is not validated yet.
+func (pr *ProtoArray) FindHead(anchorRoot Root, anchorSlot Slot) (head NodeRef, optimistic bool, err error) {
 	if !pr.updatedConnections {
 		if err := pr.updateConnections(); err != nil {
-			return NodeRef{}, err
+			return NodeRef{}, false, err
 		}
 	}
 	anchorRef := NodeRef{Root: anchorRoot, Slot: anchorSlot}
 	anchorIndex, ok := pr.indices[anchorRef]
 	if !ok {
-		return NodeRef{}, UnknownAnchorErr
+		return NodeRef{}, false, UnknownAnchorErr
 	}
 	anchorNode, err := pr.getNode(anchorIndex)
 	if err != nil {
-		return NodeRef{}, err
+		return NodeRef{}, false, err
 	}
 	bestDescIndex := anchorNode.BestDescendant
 	if bestDescIndex == NONE {
@@ -480,12 +614,116 @@ func (pr *ProtoArray) FindHead(anchorRoot Root, anchorSlot Slot) (NodeRef, error
 	}
 	bestNode, err := pr.getNode(bestDescIndex)
 	if err != nil {
-		return NodeRef{}, err
+		return NodeRef{}, false, err
 	}
 	if !pr.isNodeViableForHead(bestNode) {
-		return NodeRef{}, NoViableHeadErr
+		return NodeRef{}, false, NoViableHeadErr
+	}
+	return bestNode.Ref, bestNode.ExecutionStatus == ExecutionSyncing, nil
+}
+
+func (pr *ProtoArray) IsOptimistic(blockRoot Root) bool {
+	node, ok := pr.blockNode(blockRoot)
+	return ok && node.ExecutionStatus == ExecutionSyncing
+}
+
+// setExecutionStatus updates the status of the nodes of the given blocks,
+// including the empty slot nodes after the blocks.
+func (pr *ProtoArray) setExecutionStatus(roots map[Root]struct{}, status ExecutionStatus) {
+	for i := range pr.nodes {
+		node := &pr.nodes[i]
+		if _, ok := roots[node.Ref.Root]; ok {
+			node.ExecutionStatus = status
+		}
+	}
+	// The viability of nodes may have changed.
+	pr.updatedConnections = false
+}
+
+func (pr *ProtoArray) ProcessPayload(blockRoot Root, blockHash Hash32, status ExecutionStatus) error {
+	if _, ok := pr.blockNode(blockRoot); !ok {
+		return fmt.Errorf("unknown block %s", blockRoot)
+	}
+	for i := range pr.nodes {
+		if node := &pr.nodes[i]; node.Ref.Root == blockRoot {
+			node.ExecutionBlockHash = blockHash
+		}
+	}
+	switch status {
+	case ExecutionValid:
+		return pr.ValidatePayload(blockRoot)
+	case ExecutionInvalid:
+		return pr.InvalidatePayload(blockRoot, Hash32{})
+	case ExecutionSyncing:
+		pr.setExecutionStatus(map[Root]struct{}{blockRoot: {}}, ExecutionSyncing)
+		return nil
+	default:
+		return fmt.Errorf("unrecognized execution status: %s", status)
+	}
+}
+
+func (pr *ProtoArray) ValidatePayload(blockRoot Root) error {
+	node, ok := pr.blockNode(blockRoot)
+	if !ok {
+		return fmt.Errorf("unknown block %s", blockRoot)
 	}
-	return bestNode.Ref, nil
+	valid := make(map[Root]struct{})
+	// Stop at the first valid ancestor, or when the ancestors are pruned: those are final.
+	for ok && node.ExecutionStatus != ExecutionValid {
+		if node.ExecutionStatus == ExecutionInvalid {
+			return fmt.Errorf("cannot validate block %s, it has an invalid payload", node.Ref.Root)
+		}
+		valid[node.Ref.Root] = struct{}{}
+		node, ok = pr.blockNode(node.ParentRoot)
+	}
+	pr.setExecutionStatus(valid, ExecutionValid)
+	return nil
+}
+
+func (pr *ProtoArray) InvalidatePayload(blockRoot Root, latestValidHash Hash32) error {
+	node, ok := pr.blockNode(blockRoot)
+	if !ok {
+		return fmt.Errorf("unknown block %s", blockRoot)
+	}
+	if node.ExecutionStatus == ExecutionValid {
+		return fmt.Errorf("cannot invalidate block %s, it has a valid payload", blockRoot)
+	}
+	invalid := map[Root]struct{}{blockRoot: {}}
+	// Find the latest valid ancestor. The optimistic blocks after it are invalid too.
+	var latestValid *ProtoNode
+	if latestValidHash != (Hash32{}) {
+		var ancestors []Root
+		for n, ok := pr.blockNode(node.ParentRoot); ok; n, ok = pr.blockNode(n.ParentRoot) {
+			if n.ExecutionBlockHash == latestValidHash {
+				latestValid = n
+				break
+			}
+			if n.ExecutionStatus == ExecutionValid {
+				break
+			}
+			ancestors = append(ancestors, n.Ref.Root)
+		}
+		if latestValid != nil {
+			for _, root := range ancestors {
+				invalid[root] = struct{}{}
+			}
+		}
+	}
+	// Parents are always added before their children, descendants are found in a single pass.
+	for i := range pr.nodes {
+		n := &pr.nodes[i]
+		if n.Ref.Root == n.ParentRoot {
+			continue
+		}
+		if _, ok := invalid[n.ParentRoot]; ok {
+			invalid[n.Ref.Root] = struct{}{}
+		}
+	}
+	pr.setExecutionStatus(invalid, ExecutionInvalid)
+	if latestValid != nil {
+		return pr.ValidatePayload(latestValid.Ref.Root)
+	}
+	return nil
 }
 
 // InSubtree checks if root is in the subtree of the anchor.
@@ -564,15 +802,14 @@ func (pr *ProtoArray) inSubtree(anchorIndex NodeIndex, lookupIndex NodeIndex) (u
 
 var HeadUnknownErr = errors.New("array has invalid state, head has no index")
 
-type prunedNode struct {
-	canonical bool
-	node      *ProtoNode
-}
-
 // Update the tree with new finalization information (or alternatively another trusted root and slot)
 // The slot may point to a gap slot,
 // in which case the node with the anchor block of the anchor block-root is pruned,
 // and the next nodes, up to (and excl.) the anchorSlot.
+//
+// All nodes inserted before the anchor node are pruned, oldest first.
+// The nodes on the chain leading up to the anchor are passed to the sink (if any) as canonical.
+// If the sink fails, only the nodes that were successfully sent to the sink are pruned, and the error is returned.
 func (pr *ProtoArray) OnPrune(ctx context.Context, anchorRoot Root, anchorSlot Slot) error {
 	anchorRef := NodeRef{Root: anchorRoot, Slot: anchorSlot}
 	anchorIndex, ok := pr.indices[anchorRef]
@@ -580,49 +817,64 @@ func (pr *ProtoArray) OnPrune(ctx context.Context, anchorRoot Root, anchorSlot S
 		// if the anchor is unknown, then there is nothing to prune anyway.
 		return nil
 	}
-	if anchorIndex == pr.indexOffset {
+	if anchorIndex == 0 {
 		// nothing to do
 		return nil
 	}
-	// Get the head, it will help quickly determine if pruned nodes are canonical
-	head, err := pr.FindHead(anchorRoot, anchorSlot)
-	if err != nil {
-		return err
+	// The nodes on the chain towards the anchor are canonical, any others before the anchor are not.
+	canonical := make(map[NodeIndex]struct{})
+	for i := pr.nodes[anchorIndex].TransitionParent; i != NONE; i = pr.nodes[i].TransitionParent {
+		canonical[i] = struct{}{}
 	}
-	headIndex, ok := pr.indices[head]
-	if !ok {
-		return HeadUnknownErr
+	// Send pruned nodes to the node sink, in order. Continue until it fails.
+	// Only prune what we successfully sent to the sink.
+	var err error
+	prunedUpTo := anchorIndex
+	if pr.sink != nil {
+		for i := NodeIndex(0); i < anchorIndex; i++ {
+			_, canon := canonical[i]
+			if err = pr.sink.OnPrunedNode(ctx, pr.nodes[i].Ref, canon); err != nil {
+				prunedUpTo = i
+				break
+			}
+		}
 	}
-	// Remove the `self.indices` and `self.blockSlots` key/values for all the to-be-deleted nodes.
-	j := 0
-	var pruned []prunedNode
-	for i := pr.indexOffset; i < anchorIndex; i++ {
-		node := &pr.nodes[j]
-		if pr.sink != nil {
-			canonical := node.BestDescendant == headIndex
-			pruned = append(pruned, prunedNode{canonical, node})
+	if prunedUpTo == 0 {
+		return err
+	}
+	for i := NodeIndex(0); i < prunedUpTo; i++ {
+		delete(pr.indices, pr.nodes[i].Ref)
+	}
+	// Copy the remaining nodes, to not keep the pruned nodes around in the backing array.
+	remaining := make([]ProtoNode, NodeIndex(len(pr.nodes))-prunedUpTo, cap(pr.nodes))
+	copy(remaining, pr.nodes[prunedUpTo:])
+	pr.nodes = remaining
+	// Shift all indices, references to pruned nodes are removed.
+	shift := func(i NodeIndex) NodeIndex {
+		if i == NONE || i < prunedUpTo {
+			return NONE
 		}
+		return i - prunedUpTo
 	}
-	// Send pruned nodes to the node sink (empty if no sink). Continue until it fails.
-	// Only prune what we successfully sent to the sink.
-	prunedUpTo := 0
-	for _, p := range pruned {
-		if err = pr.sink.OnPrunedNode(ctx, p.node.Ref, p.canonical); err != nil {
-			break
-		}
-		prunedUpTo++
-	}
-	// adjust the slot we know for the anchor root, everything before it was pruned.
-	pr.blockSlots[anchorRoot] = anchorSlot
-	for _, p := range pruned[:prunedUpTo] {
-		delete(pr.indices, p.node.Ref)
-		// Remove the block-slots ref
-		delete(pr.blockSlots, p.node.Ref.Root)
-		// TODO: is this slicing bad for GC?
-		pr.nodes = pr.nodes[1:]
-		// update offset
-		pr.indexOffset++
+	for i := range pr.nodes {
+		node := &pr.nodes[i]
+		node.TransitionParent = shift(node.TransitionParent)
+		node.ForkchoiceParent = shift(node.ForkchoiceParent)
+		node.BestChild = shift(node.BestChild)
+		node.BestDescendant = shift(node.BestDescendant)
+	}
+	for ref, i := range pr.indices {
+		pr.indices[ref] = i - prunedUpTo
+	}
+	// Track the first remaining slot of each block root: a pruned block may still have later slot nodes.
+	pr.blockSlots = make(map[Root]Slot, len(pr.blockSlots))
+	for i := range pr.nodes {
+		ref := pr.nodes[i].Ref
+		if slot, ok := pr.blockSlots[ref.Root]; !ok || ref.Slot < slot {
+			pr.blockSlots[ref.Root] = ref.Slot
+		}
 	}
+	pr.updatedConnections = false
 	return err
 }
 
@@ -690,8 +942,14 @@ func (pr *ProtoArray) maybeUpdateBestChildAndDescendant(parentIndex NodeIndex, c
 				// The best child leads to a viable head, but the child doesn't.
 				// *No change*
 			} else if child.Weight == bestChild.Weight {
-				// Tie-breaker of equal weights by root. (smaller hash wins)
-				if bytes.Compare(child.Ref.Root[:], bestChild.Ref.Root[:]) > 0 {
+				childEmpty, bestChildEmpty := child.Ref.Root == child.ParentRoot, bestChild.Ref.Root == bestChild.ParentRoot
+				if childEmpty != bestChildEmpty {
+					// Tie-breaker of equal weights by block: an empty slot does not win over a block.
+					if bestChildEmpty {
+						changeToChild()
+					}
+				} else if bytes.Compare(child.Ref.Root[:], bestChild.Ref.Root[:]) > 0 {
+					// Tie-breaker of equal weights by root. (smaller hash wins)
 					changeToChild()
 				}
 				// otherwise *no change*
@@ -729,12 +987,36 @@ func (pr *ProtoArray) nodeLeadsToViableHead(node *ProtoNode) (bool, error) {
 	}
 }
 
-// This is the equivalent to the `filter_block_tree` function in the eth2 spec:
+// This is the equivalent to the `node_is_viable` check of `filter_block_tree` in the eth2 spec:
+//
+// https://github.com/ethereum/consensus-specs/blob/dev/specs/phase0/fork-choice.md#filter_block_tree
 //
-// https://github.com/ethereum/eth2.0-specs/blob/v0.11.1/specs/phase0/fork-choice.md#filter_block_tree
+// The voting source of a node from a previous epoch is its unrealized justified checkpoint,
+// since the justification of the node would have been pulled up at the epoch boundary.
+// A node with a voting source that is not the justified checkpoint is not viable,
+// unless the previous epoch is justified and the node justifies it recently enough.
 //
-// Any node that has a different finalized or justified epoch should not be viable for the head.
+// Nodes with an invalid execution payload are never viable.
+//
+// Checking the finalized checkpoint is not necessary: the graph is pruned at finalization,
+// and the head is searched from the justified node, which descends from the finalized node.
 func (pr *ProtoArray) isNodeViableForHead(node *ProtoNode) bool {
-	return (node.JustifiedEpoch == pr.justifiedEpoch || pr.justifiedEpoch == common.GENESIS_EPOCH) &&
-		(node.FinalizedEpoch == pr.finalizedEpoch || pr.finalizedEpoch == common.GENESIS_EPOCH)
+	if node.ExecutionStatus == ExecutionInvalid {
+		return false
+	}
+	if pr.justifiedEpoch == common.GENESIS_EPOCH {
+		return true
+	}
+	currentEpoch := pr.spec.SlotToEpoch(pr.currentSlot)
+	votingSource := node.JustifiedEpoch
+	if currentEpoch > pr.spec.SlotToEpoch(node.Ref.Slot) {
+		votingSource = node.UnrealizedJustifiedEpoch
+	}
+	if votingSource == pr.justifiedEpoch {
+		return true
+	}
+	if pr.justifiedEpoch+1 == currentEpoch {
+		return node.UnrealizedJustifiedEpoch >= pr.justifiedEpoch && votingSource+2 >= currentEpoch
+	}
+	return false
 }
@@ -0,0 +1,283 @@
+package proto
+
+import (
+	"sort"
+
+	"github.com/protolambda/ztyp/codec"
+	"github.com/protolambda/ztyp/view"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	. "github.com/protolambda/zrnt/eth2/forkchoice"
+)
+
+// RestoreProtoForkChoice loads a proto-array forkchoice from a snapshot, as encoded with Serialize.
+func RestoreProtoForkChoice(spec *common.Spec, dr *codec.DecodingReader, sink NodeSink) (Forkchoice, error) {
+	graph := &ProtoArray{spec: spec, sink: sink}
+	votes := &ProtoVoteStore{spec: spec}
+	return RestoreForkChoice(spec, graph, votes, dr)
+}
+
+const protoNodeSize = 40 + 8 + 8 + 32 + 4*8 + 8 + 32 + 1 + 8 + 8
+
+func (n *ProtoNode) Deserialize(dr *codec.DecodingReader) error {
+	if err := n.Ref.Deserialize(dr); err != nil {
+		return err
+	}
+	for _, v := range []*uint64{
+		(*uint64)(&n.TransitionParent), (*uint64)(&n.ForkchoiceParent),
+	} {
+		x, err := dr.ReadUint64()
+		if err != nil {
+			return err
+		}
+		*v = x
+	}
+	if err := n.ParentRoot.Deserialize(dr); err != nil {
+		return err
+	}
+	for _, v := range []*uint64{
+		(*uint64)(&n.JustifiedEpoch), (*uint64)(&n.FinalizedEpoch),
+		(*uint64)(&n.UnrealizedJustifiedEpoch), (*uint64)(&n.UnrealizedFinalizedEpoch),
+	} {
+		x, err := dr.ReadUint64()
+		if err != nil {
+			return err
+		}
+		*v = x
+	}
+	weight, err := dr.ReadUint64()
+	if err != nil {
+		return err
+	}
+	n.Weight = SignedGwei(weight)
+	if err := n.ExecutionBlockHash.Deserialize(dr); err != nil {
+		return err
+	}
+	status, err := dr.ReadByte()
+	if err != nil {
+		return err
+	}
+	n.ExecutionStatus = ExecutionStatus(status)
+	for _, v := range []*uint64{(*uint64)(&n.BestChild), (*uint64)(&n.BestDescendant)} {
+		x, err := dr.ReadUint64()
+		if err != nil {
+			return err
+		}
+		*v = x
+	}
+	return nil
+}
+
+func (n *ProtoNode) Serialize(w *codec.EncodingWriter) error {
+	if err := n.Ref.Serialize(w); err != nil {
+		return err
+	}
+	for _, v := range []uint64{uint64(n.TransitionParent), uint64(n.ForkchoiceParent)} {
+		if err := w.WriteUint64(v); err != nil {
+			return err
+		}
+	}
+	if err := n.ParentRoot.Serialize(w); err != nil {
+		return err
+	}
+	for _, v := range []uint64{
+		uint64(n.JustifiedEpoch), uint64(n.FinalizedEpoch),
+		uint64(n.UnrealizedJustifiedEpoch), uint64(n.UnrealizedFinalizedEpoch),
+		uint64(n.Weight),
+	} {
+		if err := w.WriteUint64(v); err != nil {
+			return err
+		}
+	}
+	if err := n.ExecutionBlockHash.Serialize(w); err != nil {
+		return err
+	}
+	if err := w.WriteByte(byte(n.ExecutionStatus)); err != nil {
+		return err
+	}
+	for _, v := range []uint64{uint64(n.BestChild), uint64(n.BestDescendant)} {
+		if err := w.WriteUint64(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (n *ProtoNode) ByteLength() uint64 {
+	return protoNodeSize
+}
+
+func (n *ProtoNode) FixedLength() uint64 {
+	return protoNodeSize
+}
+
+type protoNodes []ProtoNode
+
+func (li *protoNodes) Deserialize(dr *codec.DecodingReader) error {
+	return dr.List(func() codec.Deserializable {
+		i := len(*li)
+		*li = append(*li, ProtoNode{})
+		return &(*li)[i]
+	}, protoNodeSize, SnapshotListLimit)
+}
+
+func (li protoNodes) Serialize(w *codec.EncodingWriter) error {
+	return w.List(func(i uint64) codec.Serializable {
+		return &li[i]
+	}, protoNodeSize, uint64(len(li)))
+}
+
+func (li protoNodes) ByteLength() uint64 {
+	return uint64(len(li)) * protoNodeSize
+}
+
+func (li protoNodes) FixedLength() uint64 {
+	return 0
+}
+
+// Deserialize restores the nodes and the checkpoints of the graph. The node lookups are rebuilt from the nodes.
+func (pr *ProtoArray) Deserialize(dr *codec.DecodingReader) error {
+	var updated view.BoolView
+	var nodes protoNodes
+	if err := dr.Container(&pr.justifiedEpoch, &pr.finalizedEpoch, &pr.currentSlot, &updated, &nodes); err != nil {
+		return err
+	}
+	pr.updatedConnections = bool(updated)
+	pr.nodes = nodes
+	pr.indices = make(map[NodeRef]NodeIndex, len(nodes))
+	pr.blockSlots = make(map[Root]Slot, len(nodes))
+	for i := range pr.nodes {
+		ref := pr.nodes[i].Ref
+		pr.indices[ref] = NodeIndex(i)
+		if slot, ok := pr.blockSlots[ref.Root]; !ok || ref.Slot < slot {
+			pr.blockSlots[ref.Root] = ref.Slot
+		}
+	}
+	return nil
+}
+
+func (pr *ProtoArray) Serialize(w *codec.EncodingWriter) error {
+	return w.Container(pr.justifiedEpoch, pr.finalizedEpoch, pr.currentSlot,
+		view.BoolView(pr.updatedConnections), protoNodes(pr.nodes))
+}
+
+func (pr *ProtoArray) ByteLength() uint64 {
+	return 8 + 8 + 8 + 1 + codec.OFFSET_SIZE + protoNodes(pr.nodes).ByteLength()
+}
+
+func (pr *ProtoArray) FixedLength() uint64 {
+	return 0
+}
+
+const voteTrackerSize = 40 + 40 + 8 + 8
+
+func (v *VoteTracker) Deserialize(dr *codec.DecodingReader) error {
+	return dr.FixedLenContainer(&v.Current, &v.Next, &v.CurrentTargetEpoch, &v.NextTargetEpoch)
+}
+
+func (v *VoteTracker) Serialize(w *codec.EncodingWriter) error {
+	return w.FixedLenContainer(&v.Current, &v.Next, v.CurrentTargetEpoch, v.NextTargetEpoch)
+}
+
+func (v *VoteTracker) ByteLength() uint64 {
+	return voteTrackerSize
+}
+
+func (v *VoteTracker) FixedLength() uint64 {
+	return voteTrackerSize
+}
+
+type voteTrackers struct {
+	spec  *common.Spec
+	votes *[]VoteTracker
+}
+
+func (li voteTrackers) Deserialize(dr *codec.DecodingReader) error {
+	return dr.List(func() codec.Deserializable {
+		i := len(*li.votes)
+		*li.votes = append(*li.votes, VoteTracker{})
+		return &(*li.votes)[i]
+	}, voteTrackerSize, uint64(li.spec.VALIDATOR_REGISTRY_LIMIT))
+}
+
+func (li voteTrackers) Serialize(w *codec.EncodingWriter) error {
+	votes := *li.votes
+	return w.List(func(i uint64) codec.Serializable {
+		return &votes[i]
+	}, voteTrackerSize, uint64(len(votes)))
+}
+
+func (li voteTrackers) ByteLength() uint64 {
+	return uint64(len(*li.votes)) * voteTrackerSize
+}
+
+func (li voteTrackers) FixedLength() uint64 {
+	return 0
+}
+
+type validatorIndices struct {
+	spec    *common.Spec
+	indices *[]ValidatorIndex
+}
+
+func (li validatorIndices) Deserialize(dr *codec.DecodingReader) error {
+	return dr.List(func() codec.Deserializable {
+		i := len(*li.indices)
+		*li.indices = append(*li.indices, 0)
+		return &(*li.indices)[i]
+	}, 8, uint64(li.spec.VALIDATOR_REGISTRY_LIMIT))
+}
+
+func (li validatorIndices) Serialize(w *codec.EncodingWriter) error {
+	indices := *li.indices
+	return w.List(func(i uint64) codec.Serializable {
+		return indices[i]
+	}, 8, uint64(len(indices)))
+}
+
+func (li validatorIndices) ByteLength() uint64 {
+	return uint64(len(*li.indices)) * 8
+}
+
+func (li validatorIndices) FixedLength() uint64 {
+	return 0
+}
+
+// Deserialize restores the votes and the equivocating validators of the vote store.
+func (st *ProtoVoteStore) Deserialize(dr *codec.DecodingReader) error {
+	var changed view.BoolView
+	var votes []VoteTracker
+	var equivocating []ValidatorIndex
+	if err := dr.Container(voteTrackers{st.spec, &votes}, validatorIndices{st.spec, &equivocating}, &changed); err != nil {
+		return err
+	}
+	st.votes = votes
+	st.changed = bool(changed)
+	st.equivocating = make(map[ValidatorIndex]struct{}, len(equivocating))
+	for _, i := range equivocating {
+		st.equivocating[i] = struct{}{}
+	}
+	return nil
+}
+
+func (st *ProtoVoteStore) Serialize(w *codec.EncodingWriter) error {
+	equivocating := st.equivocatingIndices()
+	return w.Container(voteTrackers{st.spec, &st.votes}, validatorIndices{st.spec, &equivocating}, view.BoolView(st.changed))
+}
+
+func (st *ProtoVoteStore) equivocatingIndices() []ValidatorIndex {
+	out := make([]ValidatorIndex, 0, len(st.equivocating))
+	for i := range st.equivocating {
+		out = append(out, i)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+func (st *ProtoVoteStore) ByteLength() uint64 {
+	return 2*codec.OFFSET_SIZE + 1 + uint64(len(st.votes))*voteTrackerSize + uint64(len(st.equivocating))*8
+}
+
+func (st *ProtoVoteStore) FixedLength() uint64 {
+	return 0
+}
@@ -13,19 +13,25 @@ type VoteTracker struct {
 }
 
 type ProtoVoteStore struct {
-	spec    *common.Spec
-	votes   []VoteTracker
-	changed bool
+	spec  *common.Spec
+	votes []VoteTracker
+	// Validators that have been seen to equivocate: their weight is removed, and later votes are ignored.
+	equivocating map[ValidatorIndex]struct{}
+	changed      bool
 }
 
 var _ VoteStore = (*ProtoVoteStore)(nil)
 
 func NewProtoVoteStore(spec *common.Spec) VoteStore {
-	return &ProtoVoteStore{spec: spec, changed: true}
+	return &ProtoVoteStore{spec: spec, equivocating: make(map[ValidatorIndex]struct{}), changed: true}
 }
 
 // Process an attestation. (Note that the head slot may be for a gap slot after the block root)
-func (st *ProtoVoteStore) ProcessAttestation(index ValidatorIndex, blockRoot Root, headSlot Slot) (ok bool) {
+// The vote is only used if the target epoch is newer than that of the previous vote.
+func (st *ProtoVoteStore) ProcessAttestation(index ValidatorIndex, blockRoot Root, headSlot Slot, targetEpoch Epoch) (ok bool) {
+	if _, ok := st.equivocating[index]; ok {
+		return false
+	}
 	if index >= ValidatorIndex(len(st.votes)) {
 		if index < ValidatorIndex(cap(st.votes)) {
 			st.votes = st.votes[:index+1]
@@ -35,17 +41,30 @@ func (st *ProtoVoteStore) ProcessAttestation(index ValidatorIndex, blockRoot Roo
 		}
 	}
 	vote := &st.votes[index]
-	targetEpoch := st.spec.SlotToEpoch(headSlot)
 	// only update if it's a newer vote, or if it's genesis and no vote has happened yet.
 	if targetEpoch > vote.NextTargetEpoch || (targetEpoch == 0 && *vote == (VoteTracker{})) {
 		vote.NextTargetEpoch = targetEpoch
 		vote.Next = NodeRef{Root: blockRoot, Slot: headSlot}
 		st.changed = true
 	}
-	// TODO: maybe help detect slashable votes on the fly?
 	return true
 }
 
+// ProcessEquivocation marks the validator as equivocating.
+// The weight of its current vote is removed with the next ComputeDeltas, and later votes are ignored.
+func (st *ProtoVoteStore) ProcessEquivocation(index ValidatorIndex) {
+	if _, ok := st.equivocating[index]; ok {
+		return
+	}
+	st.equivocating[index] = struct{}{}
+	st.changed = true
+}
+
+func (st *ProtoVoteStore) IsEquivocating(index ValidatorIndex) bool {
+	_, ok := st.equivocating[index]
+	return ok
+}
+
 func (st *ProtoVoteStore) HasChanges() bool {
 	return st.changed
 }
@@ -68,6 +87,15 @@ func (st *ProtoVoteStore) ComputeDeltas(indices map[NodeRef]NodeIndex, oldBalanc
 		if i < len(oldBalances) {
 			oldBal = oldBalances[i]
 		}
+
+		// Equivocating validators lose the weight of their current vote, and do not vote again.
+		if _, ok := st.equivocating[ValidatorIndex(i)]; ok {
+			if currentIndex, ok := indices[vote.Current]; ok {
+				deltas[currentIndex] -= SignedGwei(oldBal)
+			}
+			*vote = VoteTracker{}
+			continue
+		}
 		newBal := Gwei(0)
 		if i < len(newBalances) {
 			newBal = newBalances[i]
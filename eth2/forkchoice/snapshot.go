@@ -0,0 +1,139 @@
+package forkchoice
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/protolambda/ztyp/codec"
+	"github.com/protolambda/ztyp/view"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+// SnapshotListLimit is the maximum number of nodes, or other non-validator items, of a list in a forkchoice snapshot.
+const SnapshotListLimit = 1 << 32
+
+// RestoreForkChoice loads a forkchoice from the snapshot, as encoded with Serialize.
+// The graph and vote store are restored from the snapshot too, and should not be used by anything else.
+func RestoreForkChoice(spec *common.Spec, graph ForkchoiceGraph, votes VoteStore, dr *codec.DecodingReader) (Forkchoice, error) {
+	fc := &ProtoForkChoice{
+		protoArray: graph,
+		voteStore:  votes,
+		spec:       spec,
+	}
+	if err := fc.Deserialize(dr); err != nil {
+		return nil, err
+	}
+	return fc, nil
+}
+
+// Serialize encodes the full forkchoice state as SSZ: the checkpoints, the pin, the proposer boost,
+// the justified balances, and the state of the graph and vote store.
+func (fc *ProtoForkChoice) Serialize(w *codec.EncodingWriter) error {
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+	changed := view.BoolView(fc.changed)
+	timely := make(rootList, 0, len(fc.timely))
+	for root := range fc.timely {
+		timely = append(timely, root)
+	}
+	sort.Slice(timely, func(i, j int) bool {
+		return bytes.Compare(timely[i][:], timely[j][:]) < 0
+	})
+	balances := common.GweiList(fc.balances)
+	return w.Container(&fc.justified, &fc.finalized, &fc.unrealizedJustified, &fc.unrealizedFinalized,
+		fc.currentSlot, &fc.boostRef, &fc.appliedBoost.ref, fc.appliedBoost.score, changed,
+		optionalNodeRef{&fc.pin}, fc.spec.Wrap(&balances), &timely, fc.protoArray, fc.voteStore)
+}
+
+func (fc *ProtoForkChoice) ByteLength() uint64 {
+	fc.mu.RLock()
+	defer fc.mu.RUnlock()
+	// 4 checkpoints, current slot, boost ref and score, changed flag, and 5 offsets
+	out := uint64(4*40+8+40+40+8+1+5*codec.OFFSET_SIZE) + uint64(len(fc.timely))*32 + uint64(len(fc.balances))*8
+	if fc.pin != nil {
+		out += 40
+	}
+	return out + fc.protoArray.ByteLength() + fc.voteStore.ByteLength()
+}
+
+func (fc *ProtoForkChoice) FixedLength() uint64 {
+	return 0
+}
+
+func (fc *ProtoForkChoice) Deserialize(dr *codec.DecodingReader) error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	var changed view.BoolView
+	var timely rootList
+	var balances common.GweiList
+	if err := dr.Container(&fc.justified, &fc.finalized, &fc.unrealizedJustified, &fc.unrealizedFinalized,
+		&fc.currentSlot, &fc.boostRef, &fc.appliedBoost.ref, &fc.appliedBoost.score, &changed,
+		optionalNodeRef{&fc.pin}, fc.spec.Wrap(&balances), &timely, fc.protoArray, fc.voteStore); err != nil {
+		return err
+	}
+	fc.changed = bool(changed)
+	fc.balances = balances
+	fc.timely = make(map[Root]struct{}, len(timely))
+	for _, root := range timely {
+		fc.timely[root] = struct{}{}
+	}
+	return nil
+}
+
+type rootList []Root
+
+func (li *rootList) Deserialize(dr *codec.DecodingReader) error {
+	return dr.List(func() codec.Deserializable {
+		i := len(*li)
+		*li = append(*li, Root{})
+		return &(*li)[i]
+	}, 32, SnapshotListLimit)
+}
+
+func (li rootList) Serialize(w *codec.EncodingWriter) error {
+	return w.List(func(i uint64) codec.Serializable {
+		return &li[i]
+	}, 32, uint64(len(li)))
+}
+
+func (li rootList) ByteLength() uint64 {
+	return uint64(len(li)) * 32
+}
+
+func (li *rootList) FixedLength() uint64 {
+	return 0
+}
+
+// optionalNodeRef encodes a node ref that may be nil, as a list of at most one node ref.
+type optionalNodeRef struct {
+	ref **NodeRef
+}
+
+func (o optionalNodeRef) Deserialize(dr *codec.DecodingReader) error {
+	*o.ref = nil
+	return dr.List(func() codec.Deserializable {
+		*o.ref = new(NodeRef)
+		return *o.ref
+	}, 40, 1)
+}
+
+func (o optionalNodeRef) Serialize(w *codec.EncodingWriter) error {
+	if *o.ref == nil {
+		return w.List(nil, 40, 0)
+	}
+	return w.List(func(i uint64) codec.Serializable {
+		return *o.ref
+	}, 40, 1)
+}
+
+func (o optionalNodeRef) ByteLength() uint64 {
+	if *o.ref == nil {
+		return 0
+	}
+	return 40
+}
+
+func (o optionalNodeRef) FixedLength() uint64 {
+	return 0
+}
@@ -0,0 +1,453 @@
+package forkchoice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/protolambda/ztyp/tree"
+
+	"github.com/protolambda/zrnt/eth2/beacon"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+)
+
+// Clock provides the current time to the Store.
+type Clock interface {
+	Now() time.Time
+}
+
+type ClockFn func() time.Time
+
+func (fn ClockFn) Now() time.Time {
+	return fn()
+}
+
+// NewForkchoiceFn creates the forkchoice of a Store, starting at the anchor block.
+type NewForkchoiceFn func(finalized Checkpoint, justified Checkpoint,
+	anchorRoot Root, anchorSlot Slot, anchorParent Root, initialBalances []Gwei) (Forkchoice, error)
+
+type storeBlock struct {
+	slot   Slot
+	parent Root
+	state  common.BeaconState
+	epc    *common.EpochsContext
+}
+
+// Store is the equivalent of the spec fork-choice Store: it validates and delays blocks and attestations
+// like the spec on_tick, on_block and on_attestation handlers, before applying them to the forkchoice.
+// The post-state of every block is kept until the block is pruned by finalization.
+type Store struct {
+	mu          sync.Mutex
+	spec        *common.Spec
+	clock       Clock
+	genesisTime common.Timestamp
+	now         time.Time
+	fc          Forkchoice
+	blocks      map[Root]*storeBlock
+	// The state of the checkpoint block, processed up to the start of the checkpoint epoch.
+	checkpointStates map[Checkpoint]*storeBlock
+	// Attestations that can only be applied once their slot has passed.
+	queued []*phase0.IndexedAttestation
+}
+
+// NewStore creates a Store that starts at the anchor state, with the latest block header of the state as anchor block.
+func NewStore(spec *common.Spec, clock Clock, anchor common.BeaconState, newFc NewForkchoiceFn) (*Store, error) {
+	epc, err := common.NewEpochsContext(spec, anchor)
+	if err != nil {
+		return nil, err
+	}
+	slot, err := anchor.Slot()
+	if err != nil {
+		return nil, err
+	}
+	genesisTime, err := anchor.GenesisTime()
+	if err != nil {
+		return nil, err
+	}
+	header, err := anchor.LatestBlockHeader()
+	if err != nil {
+		return nil, err
+	}
+	// The state root of the header is only filled in at the next slot.
+	if header.StateRoot == (Root{}) {
+		header.StateRoot = anchor.HashTreeRoot(tree.GetHashFn())
+	}
+	anchorRoot := header.HashTreeRoot(tree.GetHashFn())
+	anchorCp := Checkpoint{Epoch: spec.SlotToEpoch(slot), Root: anchorRoot}
+	b := &storeBlock{slot: header.Slot, parent: header.ParentRoot, state: anchor, epc: epc}
+	s := &Store{
+		spec:             spec,
+		clock:            clock,
+		genesisTime:      genesisTime,
+		now:              time.Unix(int64(genesisTime+common.Timestamp(slot)*spec.SECONDS_PER_SLOT), 0),
+		blocks:           map[Root]*storeBlock{anchorRoot: b},
+		checkpointStates: map[Checkpoint]*storeBlock{anchorCp: b},
+	}
+	balances, err := s.justifiedBalances(anchorCp)
+	if err != nil {
+		return nil, err
+	}
+	s.fc, err = newFc(anchorCp, anchorCp, anchorRoot, header.Slot, header.ParentRoot, balances)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Forkchoice returns the forkchoice that the store drives. Changes should go through the Store.
+func (s *Store) Forkchoice() Forkchoice {
+	return s.fc
+}
+
+func (s *Store) Head() (NodeRef, error) {
+	return s.fc.Head()
+}
+
+// CurrentSlot returns the slot of the store time, as of the last tick.
+func (s *Store) CurrentSlot() Slot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.currentSlot()
+}
+
+func (s *Store) currentSlot() Slot {
+	t := common.Timestamp(s.now.Unix())
+	if t < s.genesisTime {
+		return 0
+	}
+	return Slot((t - s.genesisTime) / s.spec.SECONDS_PER_SLOT)
+}
+
+func (s *Store) intoSlot() time.Duration {
+	slotStart := s.genesisTime + common.Timestamp(s.currentSlot())*s.spec.SECONDS_PER_SLOT
+	return s.now.Sub(time.Unix(int64(slotStart), 0))
+}
+
+// BlockState returns the post-state of a known block, and the epochs context of that state.
+// The state and epochs context must not be modified.
+func (s *Store) BlockState(root Root) (state common.BeaconState, epc *common.EpochsContext, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.blocks[root]
+	if !ok {
+		return nil, nil, false
+	}
+	return b.state, b.epc, true
+}
+
+// CheckpointState returns the state of the checkpoint block, processed up to the start of the checkpoint epoch,
+// and the epochs context of that state. The state and epochs context must not be modified.
+func (s *Store) CheckpointState(cp Checkpoint) (common.BeaconState, *common.EpochsContext, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := s.checkpointState(cp)
+	if err != nil {
+		return nil, nil, err
+	}
+	return b.state, b.epc, nil
+}
+
+func (s *Store) checkpointState(cp Checkpoint) (*storeBlock, error) {
+	if b, ok := s.checkpointStates[cp]; ok {
+		return b, nil
+	}
+	b, ok := s.blocks[cp.Root]
+	if !ok {
+		return nil, fmt.Errorf("unknown checkpoint block %s", cp.Root)
+	}
+	startSlot, err := s.spec.EpochStartSlot(cp.Epoch)
+	if err != nil {
+		return nil, err
+	}
+	state, err := b.state.CopyState()
+	if err != nil {
+		return nil, err
+	}
+	epc := b.epc.Clone()
+	if slot, err := state.Slot(); err != nil {
+		return nil, err
+	} else if slot < startSlot {
+		wrapped := &beacon.StandardUpgradeableBeaconState{BeaconState: state}
+		if err := common.ProcessSlots(context.Background(), s.spec, epc, wrapped, startSlot); err != nil {
+			return nil, err
+		}
+		state = wrapped.BeaconState
+	}
+	out := &storeBlock{slot: b.slot, parent: b.parent, state: state, epc: epc}
+	s.checkpointStates[cp] = out
+	return out, nil
+}
+
+// justifiedBalances returns the effective balances of the active and unslashed validators of the checkpoint state.
+func (s *Store) justifiedBalances(cp Checkpoint) ([]Gwei, error) {
+	b, err := s.checkpointState(cp)
+	if err != nil {
+		return nil, err
+	}
+	vals, err := b.state.Validators()
+	if err != nil {
+		return nil, err
+	}
+	flats, err := common.FlattenValidators(vals)
+	if err != nil {
+		return nil, err
+	}
+	balances := make([]Gwei, len(flats))
+	for i := range flats {
+		if flats[i].IsActive(cp.Epoch) && !flats[i].Slashed {
+			balances[i] = flats[i].EffectiveBalance
+		}
+	}
+	return balances, nil
+}
+
+// ancestor returns the root of the block at or before the given slot, in the chain of the block.
+func (s *Store) ancestor(root Root, slot Slot) (Root, error) {
+	for {
+		b, ok := s.blocks[root]
+		if !ok {
+			return Root{}, fmt.Errorf("unknown ancestor %s", root)
+		}
+		if b.slot <= slot {
+			return root, nil
+		}
+		root = b.parent
+	}
+}
+
+// OnTick moves the store to the current time of the clock.
+// Attestations that were delayed until the start of the current slot are applied.
+func (s *Store) OnTick(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tick(ctx)
+}
+
+func (s *Store) tick(ctx context.Context) error {
+	now := s.clock.Now()
+	if !now.After(s.now) {
+		return nil
+	}
+	prevSlot := s.currentSlot()
+	s.now = now
+	currentSlot := s.currentSlot()
+	if currentSlot <= prevSlot {
+		return nil
+	}
+	if err := s.fc.ProcessTick(ctx, currentSlot, s.justifiedBalances); err != nil {
+		return err
+	}
+	remaining := s.queued[:0]
+	for _, att := range s.queued {
+		if att.Data.Slot >= currentSlot {
+			remaining = append(remaining, att)
+			continue
+		}
+		// The block may have been pruned in the meantime, the attestation is outdated then.
+		if head, ok := s.blocks[att.Data.BeaconBlockRoot]; ok {
+			s.applyAttestation(att, head.slot)
+		}
+	}
+	s.queued = remaining
+	return nil
+}
+
+// OnBlock adds a block, with its post-state and the epochs context of the post-state.
+// The state transition is the responsibility of the caller, the store keeps the post-state and epochs context,
+// they must not be modified afterwards.
+// The attestations and attester slashings of the block should be applied after the block.
+func (s *Store) OnBlock(ctx context.Context, benv *common.BeaconBlockEnvelope,
+	post common.BeaconState, epc *common.EpochsContext) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.tick(ctx); err != nil {
+		return err
+	}
+	if _, ok := s.blocks[benv.BlockRoot]; ok {
+		return nil
+	}
+	if _, ok := s.blocks[benv.ParentRoot]; !ok {
+		return fmt.Errorf("unknown parent %s", benv.ParentRoot)
+	}
+	currentSlot := s.currentSlot()
+	if benv.Slot > currentSlot {
+		return fmt.Errorf("block slot %d is in the future, current slot is %d", benv.Slot, currentSlot)
+	}
+	finalized := s.fc.Finalized()
+	finSlot, err := s.spec.EpochStartSlot(finalized.Epoch)
+	if err != nil {
+		return err
+	}
+	if benv.Slot <= finSlot {
+		return fmt.Errorf("block slot %d is not after finalized slot %d", benv.Slot, finSlot)
+	}
+	if finAncestor, err := s.ancestor(benv.ParentRoot, finSlot); err != nil {
+		return err
+	} else if finAncestor != finalized.Root {
+		return errors.New("block does not descend from finalized checkpoint")
+	}
+	if stateRoot := post.HashTreeRoot(tree.GetHashFn()); stateRoot != benv.StateRoot {
+		return fmt.Errorf("post-state root %s does not match block state root %s", stateRoot, benv.StateRoot)
+	}
+	justified, err := post.CurrentJustifiedCheckpoint()
+	if err != nil {
+		return err
+	}
+	postFinalized, err := post.FinalizedCheckpoint()
+	if err != nil {
+		return err
+	}
+	unrealizedJustified, unrealizedFinalized, err := beacon.ComputeUnrealizedCheckpoints(ctx, s.spec, epc, post)
+	if err != nil {
+		return err
+	}
+	s.blocks[benv.BlockRoot] = &storeBlock{slot: benv.Slot, parent: benv.ParentRoot, state: post, epc: epc}
+	if !s.fc.ProcessBlock(benv.ParentRoot, benv.BlockRoot, benv.Slot, justified.Epoch, postFinalized.Epoch,
+		unrealizedJustified, unrealizedFinalized) {
+		delete(s.blocks, benv.BlockRoot)
+		return fmt.Errorf("forkchoice rejected block %s", benv.BlockRoot)
+	}
+	s.fc.BlockArrival(benv.BlockRoot, currentSlot, s.intoSlot())
+	if err := s.updateCheckpoints(ctx, benv.BlockRoot, justified, postFinalized); err != nil {
+		return err
+	}
+	// Blocks from previous epochs are pulled up immediately.
+	if s.spec.SlotToEpoch(benv.Slot) < s.spec.SlotToEpoch(currentSlot) {
+		if err := s.updateCheckpoints(ctx, benv.BlockRoot, unrealizedJustified, unrealizedFinalized); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) updateCheckpoints(ctx context.Context, trigger Root, justified Checkpoint, finalized Checkpoint) error {
+	// The balances are only requested if the justified checkpoint changes.
+	if current := s.fc.Justified(); justified.Epoch <= current.Epoch {
+		justified = current
+	}
+	prevFinalized := s.fc.Finalized()
+	if err := s.fc.UpdateJustified(ctx, trigger, justified, finalized, func() ([]Gwei, error) {
+		return s.justifiedBalances(justified)
+	}); err != nil {
+		return err
+	}
+	if fin := s.fc.Finalized(); fin != prevFinalized {
+		s.prune(fin)
+	}
+	return nil
+}
+
+// prune removes the blocks and checkpoint states that are no longer part of the forkchoice.
+func (s *Store) prune(finalized Checkpoint) {
+	for root := range s.blocks {
+		if _, ok := s.fc.GetSlot(root); !ok {
+			delete(s.blocks, root)
+		}
+	}
+	for cp := range s.checkpointStates {
+		if cp.Epoch < finalized.Epoch {
+			delete(s.checkpointStates, cp)
+		}
+	}
+}
+
+// OnAttestation validates the attestation, and applies the votes to the forkchoice.
+// Attestations from blocks may be from older epochs. Attestations are only applied once their slot has passed,
+// until then they are queued.
+func (s *Store) OnAttestation(ctx context.Context, att *phase0.IndexedAttestation, fromBlock bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.tick(ctx); err != nil {
+		return err
+	}
+	data := &att.Data
+	target := data.Target
+	currentSlot := s.currentSlot()
+	if !fromBlock {
+		currentEpoch := s.spec.SlotToEpoch(currentSlot)
+		if target.Epoch != currentEpoch && target.Epoch != currentEpoch.Previous() {
+			return fmt.Errorf("attestation target epoch %d is not current or previous epoch", target.Epoch)
+		}
+	}
+	if target.Epoch != s.spec.SlotToEpoch(data.Slot) {
+		return errors.New("attestation target epoch does not match slot")
+	}
+	if _, ok := s.blocks[target.Root]; !ok {
+		return fmt.Errorf("unknown target block %s", target.Root)
+	}
+	head, ok := s.blocks[data.BeaconBlockRoot]
+	if !ok {
+		return fmt.Errorf("unknown head block %s", data.BeaconBlockRoot)
+	}
+	if head.slot > data.Slot {
+		return errors.New("attestation is for a block after the attestation slot")
+	}
+	targetSlot, err := s.spec.EpochStartSlot(target.Epoch)
+	if err != nil {
+		return err
+	}
+	if targetAncestor, err := s.ancestor(data.BeaconBlockRoot, targetSlot); err != nil {
+		return err
+	} else if targetAncestor != target.Root {
+		return errors.New("attestation head does not match target")
+	}
+	cpState, err := s.checkpointState(target)
+	if err != nil {
+		return err
+	}
+	if err := phase0.ValidateIndexedAttestation(s.spec, cpState.epc, cpState.state, att); err != nil {
+		return err
+	}
+	if currentSlot < data.Slot+1 {
+		s.queued = append(s.queued, att)
+		return nil
+	}
+	s.applyAttestation(att, head.slot)
+	return nil
+}
+
+// applyAttestation applies the votes to the forkchoice, the latest vote is determined by the target epoch.
+func (s *Store) applyAttestation(att *phase0.IndexedAttestation, headSlot Slot) {
+	for _, i := range att.AttestingIndices {
+		s.fc.ProcessAttestation(i, att.Data.BeaconBlockRoot, headSlot, att.Data.Target.Epoch)
+	}
+}
+
+// OnAttesterSlashing validates the attester slashing against the justified state,
+// and marks the slashable validators as equivocating.
+func (s *Store) OnAttesterSlashing(ctx context.Context, slashing *phase0.AttesterSlashing) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.tick(ctx); err != nil {
+		return err
+	}
+	cpState, err := s.checkpointState(s.fc.Justified())
+	if err != nil {
+		return err
+	}
+	if err := phase0.ValidateIndexedAttestation(s.spec, cpState.epc, cpState.state, &slashing.Attestation1); err != nil {
+		return err
+	}
+	if err := phase0.ValidateIndexedAttestation(s.spec, cpState.epc, cpState.state, &slashing.Attestation2); err != nil {
+		return err
+	}
+	return s.fc.ProcessAttesterSlashing(slashing)
+}
+
+// ProposerHead returns the block to build on for a proposal at the given slot:
+// the head, or its parent if the head is a weak late block that can be re-orged.
+func (s *Store) ProposerHead(ctx context.Context, slot Slot) (Root, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.tick(ctx); err != nil {
+		return Root{}, err
+	}
+	head, err := s.fc.Head()
+	if err != nil {
+		return Root{}, err
+	}
+	return s.fc.ProposerHead(head.Root, slot, s.intoSlot())
+}
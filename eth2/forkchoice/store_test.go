@@ -0,0 +1,164 @@
+package forkchoice_test
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	blsu "github.com/protolambda/bls12-381-util"
+	"github.com/protolambda/ztyp/tree"
+
+	"github.com/protolambda/zrnt/eth2/beacon"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/zrnt/eth2/forkchoice"
+	"github.com/protolambda/zrnt/eth2/forkchoice/proto"
+	"github.com/protolambda/zrnt/eth2/internal/beacontest"
+)
+
+type testStore struct {
+	t     *testing.T
+	spec  *common.Spec
+	gen   *beacontest.Genesis
+	now   time.Time
+	store *forkchoice.Store
+}
+
+func newTestStore(t *testing.T, validatorCount uint64) *testStore {
+	spec := configs.Minimal
+	gen := beacontest.NewGenesis(t, spec, validatorCount)
+	ts := &testStore{t: t, spec: spec, gen: gen, now: time.Unix(int64(beacontest.GenesisTime), 0)}
+	var err error
+	ts.store, err = proto.NewProtoStore(spec, forkchoice.ClockFn(func() time.Time {
+		return ts.now
+	}), gen.State, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts
+}
+
+// setTime moves the clock to the given time into the slot, and ticks the store.
+func (ts *testStore) setTime(slot common.Slot, intoSlot time.Duration) {
+	ts.now = time.Unix(int64(beacontest.GenesisTime+common.Timestamp(slot)*ts.spec.SECONDS_PER_SLOT), 0).Add(intoSlot)
+	if err := ts.store.OnTick(context.Background()); err != nil {
+		ts.t.Fatal(err)
+	}
+}
+
+// buildBlock creates a block on top of the parent, and returns it with its post-state.
+func (ts *testStore) buildBlock(parent common.Root, slot common.Slot, graffiti byte) (*common.BeaconBlockEnvelope, common.BeaconState, *common.EpochsContext) {
+	pre, preEpc, ok := ts.store.BlockState(parent)
+	if !ok {
+		ts.t.Fatalf("unknown parent %s", parent)
+	}
+	state, err := pre.CopyState()
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	epc := preEpc.Clone()
+	if err := common.ProcessSlots(context.Background(), ts.spec, epc, &beacon.StandardUpgradeableBeaconState{BeaconState: state}, slot); err != nil {
+		ts.t.Fatal(err)
+	}
+	return ts.gen.BuildBlock(ts.t, state, epc, parent, slot, graffiti), state, epc
+}
+
+func (ts *testStore) addBlock(parent common.Root, slot common.Slot, graffiti byte) *common.BeaconBlockEnvelope {
+	benv, post, epc := ts.buildBlock(parent, slot, graffiti)
+	if err := ts.store.OnBlock(context.Background(), benv, post, epc); err != nil {
+		ts.t.Fatal(err)
+	}
+	return benv
+}
+
+// attest creates a signed attestation of the first committee of the slot.
+func (ts *testStore) attest(slot common.Slot, head common.Root, target common.Checkpoint) *phase0.IndexedAttestation {
+	_, epc, err := ts.store.CheckpointState(target)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	committee, err := epc.GetBeaconCommittee(slot, 0)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	data := phase0.AttestationData{Slot: slot, BeaconBlockRoot: head, Target: target}
+	dom := common.ComputeDomain(common.DOMAIN_BEACON_ATTESTER, ts.spec.ForkVersion(slot), ts.gen.ValidatorsRoot)
+	msg := common.ComputeSigningRoot(data.HashTreeRoot(tree.GetHashFn()), dom)
+	indices := append(common.CommitteeIndices(nil), committee...)
+	sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+	sigs := make([]*blsu.Signature, len(indices))
+	for i, index := range indices {
+		sigs[i] = blsu.Sign(ts.gen.Keys[index], msg[:])
+	}
+	sig, err := blsu.Aggregate(sigs)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	return &phase0.IndexedAttestation{AttestingIndices: indices, Data: data, Signature: sig.Serialize()}
+}
+
+func (ts *testStore) expectHead(root common.Root) {
+	head, err := ts.store.Head()
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	if head.Root != root {
+		ts.t.Fatalf("expected head %s, got %s", root, head.Root)
+	}
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	ts := newTestStore(t, 64)
+	genesis, err := ts.store.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesisRoot := genesis.Root
+
+	// blocks from the future are rejected
+	early, post, epc := ts.buildBlock(genesisRoot, 1, 0)
+	if err := ts.store.OnBlock(ctx, early, post, epc); err == nil {
+		t.Fatal("expected future block error")
+	}
+
+	// a is timely and boosted, b is late
+	ts.setTime(1, 0)
+	a := ts.addBlock(genesisRoot, 1, 0)
+	ts.setTime(1, 5*time.Second)
+	b := ts.addBlock(genesisRoot, 1, 1)
+	if boost := ts.store.Forkchoice().ProposerBoostRoot(); boost != a.BlockRoot {
+		t.Fatalf("expected boost for a, got %s", boost)
+	}
+	ts.expectHead(a.BlockRoot)
+
+	// the block must match its post-state
+	c, _, _ := ts.buildBlock(genesisRoot, 1, 2)
+	_, post, epc = ts.buildBlock(genesisRoot, 1, 3)
+	if err := ts.store.OnBlock(ctx, c, post, epc); err == nil {
+		t.Fatal("expected post-state mismatch error")
+	}
+
+	target := common.Checkpoint{Epoch: 0, Root: genesisRoot}
+	att := ts.attest(1, b.BlockRoot, target)
+	bad := *att
+	bad.Data.Target.Epoch = 1
+	if err := ts.store.OnAttestation(ctx, &bad, false); err == nil {
+		t.Fatal("expected target epoch error")
+	}
+	// the attestation is delayed until the next slot
+	if err := ts.store.OnAttestation(ctx, att, false); err != nil {
+		t.Fatal(err)
+	}
+	ts.expectHead(a.BlockRoot)
+	ts.setTime(2, 0)
+	if boost := ts.store.Forkchoice().ProposerBoostRoot(); boost != (common.Root{}) {
+		t.Fatalf("expected boost to be removed, got %s", boost)
+	}
+	ts.expectHead(b.BlockRoot)
+	if slot := ts.store.CurrentSlot(); slot != 2 {
+		t.Fatalf("expected slot 2, got %d", slot)
+	}
+}
@@ -0,0 +1,179 @@
+package forkchoice
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+)
+
+type deferredVote struct {
+	indices     []ValidatorIndex
+	slot        Slot
+	targetEpoch Epoch
+}
+
+// VoteCollector expands attestations from blocks and gossip into validator votes, and applies them to the forkchoice.
+//
+// A validator vote is only applied if it has a newer target epoch than the previous vote of the validator,
+// duplicate and older votes are ignored.
+// Votes for blocks that are not in the forkchoice yet are deferred until the block is added, see OnBlock.
+// Gossip attestations are deferred until the slot after the attestation slot, see OnTick.
+//
+// The attestations are expected to be validated already: only the committee bits are checked.
+type VoteCollector struct {
+	mu sync.Mutex
+
+	spec *common.Spec
+	fc   Forkchoice
+
+	currentSlot Slot
+	// latest target epoch per validator that voted
+	latest   map[ValidatorIndex]Epoch
+	deferred map[Root][]deferredVote
+}
+
+func NewVoteCollector(spec *common.Spec, fc Forkchoice) *VoteCollector {
+	return &VoteCollector{
+		spec:     spec,
+		fc:       fc,
+		latest:   make(map[ValidatorIndex]Epoch),
+		deferred: make(map[Root][]deferredVote),
+	}
+}
+
+// OnTick updates the current slot, applies deferred gossip votes that are no longer early,
+// and drops deferred votes with a target before the previous epoch.
+func (vc *VoteCollector) OnTick(currentSlot Slot) {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	vc.currentSlot = currentSlot
+	minEpoch := vc.spec.SlotToEpoch(currentSlot).Previous()
+	for root, votes := range vc.deferred {
+		kept := votes[:0]
+		for _, v := range votes {
+			if v.targetEpoch >= minEpoch {
+				kept = append(kept, v)
+			}
+		}
+		if len(kept) == 0 {
+			delete(vc.deferred, root)
+		} else {
+			vc.deferred[root] = kept
+		}
+		vc.flush(root)
+	}
+}
+
+// OnBlock applies the deferred votes for the block, call it after the block is added to the forkchoice.
+func (vc *VoteCollector) OnBlock(blockRoot Root) {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	vc.flush(blockRoot)
+}
+
+// DeferredCount returns the number of deferred attestations.
+func (vc *VoteCollector) DeferredCount() (count uint64) {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	for _, votes := range vc.deferred {
+		count += uint64(len(votes))
+	}
+	return
+}
+
+// OnAttestation applies the votes of a gossip attestation.
+// The epochs context must be able to provide the committee of the attestation slot.
+func (vc *VoteCollector) OnAttestation(epc *common.EpochsContext, att *phase0.Attestation) error {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	currentEpoch := vc.spec.SlotToEpoch(vc.currentSlot)
+	if target := att.Data.Target.Epoch; target != currentEpoch && target != currentEpoch.Previous() {
+		return fmt.Errorf("attestation target epoch %d is not current or previous epoch", target)
+	}
+	return vc.process(epc, att, true)
+}
+
+// OnAggregateAndProof applies the votes of the aggregate attestation of a gossip aggregate.
+func (vc *VoteCollector) OnAggregateAndProof(epc *common.EpochsContext, agg *phase0.SignedAggregateAndProof) error {
+	return vc.OnAttestation(epc, &agg.Message.Aggregate)
+}
+
+// OnBlockAttestations applies the votes of the attestations included in a block.
+// Unlike gossip attestations, these are not limited to recent epochs, and not delayed to the next slot.
+func (vc *VoteCollector) OnBlockAttestations(epc *common.EpochsContext, atts phase0.Attestations) error {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	for i := range atts {
+		if err := vc.process(epc, &atts[i], false); err != nil {
+			return fmt.Errorf("attestation %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (vc *VoteCollector) process(epc *common.EpochsContext, att *phase0.Attestation, gossip bool) error {
+	data := &att.Data
+	committee, err := epc.GetBeaconCommittee(data.Slot, data.Index)
+	if err != nil {
+		return err
+	}
+	indexed, err := att.ConvertToIndexed(vc.spec, committee)
+	if err != nil {
+		return err
+	}
+	v := deferredVote{slot: data.Slot, targetEpoch: data.Target.Epoch}
+	for _, i := range indexed.AttestingIndices {
+		if prev, ok := vc.latest[i]; !ok || prev < v.targetEpoch {
+			v.indices = append(v.indices, i)
+		}
+	}
+	if len(v.indices) == 0 {
+		return nil
+	}
+	headSlot, ok := vc.fc.GetSlot(data.BeaconBlockRoot)
+	if !ok || (gossip && vc.currentSlot <= data.Slot) {
+		vc.deferred[data.BeaconBlockRoot] = append(vc.deferred[data.BeaconBlockRoot], v)
+		return nil
+	}
+	vc.apply(v, data.BeaconBlockRoot, headSlot)
+	return nil
+}
+
+// flush applies the deferred votes for the block root, if the block is known and the votes are no longer early.
+func (vc *VoteCollector) flush(blockRoot Root) {
+	votes, ok := vc.deferred[blockRoot]
+	if !ok {
+		return
+	}
+	headSlot, ok := vc.fc.GetSlot(blockRoot)
+	if !ok {
+		return
+	}
+	kept := votes[:0]
+	for _, v := range votes {
+		if vc.currentSlot <= v.slot {
+			kept = append(kept, v)
+			continue
+		}
+		vc.apply(v, blockRoot, headSlot)
+	}
+	if len(kept) == 0 {
+		delete(vc.deferred, blockRoot)
+	} else {
+		vc.deferred[blockRoot] = kept
+	}
+}
+
+func (vc *VoteCollector) apply(v deferredVote, blockRoot Root, headSlot Slot) {
+	for _, i := range v.indices {
+		// a vote may have been superseded while it was deferred
+		if prev, ok := vc.latest[i]; ok && prev >= v.targetEpoch {
+			continue
+		}
+		if vc.fc.ProcessAttestation(i, blockRoot, headSlot, v.targetEpoch) {
+			vc.latest[i] = v.targetEpoch
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package forkchoice_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/zrnt/eth2/forkchoice"
+)
+
+// committeeAttestation creates an unsigned attestation of the full first committee of the slot.
+func (ts *testStore) committeeAttestation(epc *common.EpochsContext, slot common.Slot, head common.Root, target common.Checkpoint) *phase0.Attestation {
+	committee, err := epc.GetBeaconCommittee(slot, 0)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	n := uint64(len(committee))
+	bits := make(phase0.AttestationBits, n/8+1)
+	bits[n/8] |= 1 << (n % 8)
+	for i := uint64(0); i < n; i++ {
+		bits.SetBit(i, true)
+	}
+	return &phase0.Attestation{
+		AggregationBits: bits,
+		Data:            phase0.AttestationData{Slot: slot, BeaconBlockRoot: head, Target: target},
+	}
+}
+
+func TestVoteCollector(t *testing.T) {
+	ts := newTestStore(t, 64)
+	genesis, err := ts.store.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := common.Checkpoint{Epoch: 0, Root: genesis.Root}
+	_, epc, err := ts.store.CheckpointState(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vc := forkchoice.NewVoteCollector(ts.spec, ts.store.Forkchoice())
+
+	// both blocks are late, and not boosted
+	ts.setTime(1, 5*time.Second)
+	vc.OnTick(1)
+	b, bPost, bEpc := ts.buildBlock(genesis.Root, 1, 1)
+
+	// gossip votes for an unknown block of the current slot are deferred
+	att := ts.committeeAttestation(epc, 1, b.BlockRoot, target)
+	if err := vc.OnAggregateAndProof(epc, &phase0.SignedAggregateAndProof{
+		Message: phase0.AggregateAndProof{Aggregate: *att},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	bad := *att
+	bad.Data.Target.Epoch = 5
+	if err := vc.OnAttestation(epc, &bad); err == nil {
+		t.Fatal("expected target epoch error")
+	}
+	a := ts.addBlock(genesis.Root, 1, 0)
+	if err := ts.store.OnBlock(context.Background(), b, bPost, bEpc); err != nil {
+		t.Fatal(err)
+	}
+	vc.OnBlock(b.BlockRoot)
+	if count := vc.DeferredCount(); count != 1 {
+		t.Fatalf("expected 1 deferred attestation, got %d", count)
+	}
+	ts.expectHead(a.BlockRoot)
+
+	// the votes apply in the next slot
+	ts.setTime(2, 0)
+	vc.OnTick(2)
+	if count := vc.DeferredCount(); count != 0 {
+		t.Fatalf("expected no deferred attestations, got %d", count)
+	}
+	ts.expectHead(b.BlockRoot)
+
+	// a duplicate target epoch vote of the same validators is ignored
+	if err := vc.OnBlockAttestations(epc, phase0.Attestations{
+		*ts.committeeAttestation(epc, 1, a.BlockRoot, target),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	ts.expectHead(b.BlockRoot)
+}
@@ -0,0 +1,100 @@
+package beacontest
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+
+	blsu "github.com/protolambda/bls12-381-util"
+	"github.com/protolambda/ztyp/tree"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+)
+
+// GenesisTime is the genesis time of test genesis states.
+const GenesisTime = common.Timestamp(1564000000)
+
+// Genesis is a phase0 genesis state, of validators with deterministic keys, to build valid test chains with.
+type Genesis struct {
+	Spec           *common.Spec
+	Keys           []*blsu.SecretKey
+	State          *phase0.BeaconStateView
+	ValidatorsRoot common.Root
+}
+
+// NewGenesis creates a genesis state with the given number of validators.
+// The secret key of validator i is i+1.
+func NewGenesis(t testing.TB, spec *common.Spec, validatorCount uint64) *Genesis {
+	keys := make([]*blsu.SecretKey, validatorCount)
+	validators := make([]phase0.KickstartValidatorData, validatorCount)
+	for i := range keys {
+		var raw [32]byte
+		binary.BigEndian.PutUint64(raw[24:], uint64(i)+1)
+		var sk blsu.SecretKey
+		if err := sk.Deserialize(&raw); err != nil {
+			t.Fatal(err)
+		}
+		pub, err := blsu.SkToPk(&sk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[i] = &sk
+		validators[i] = phase0.KickstartValidatorData{
+			Pubkey:                pub.Serialize(),
+			WithdrawalCredentials: common.Root{0xbb},
+			Balance:               spec.MAX_EFFECTIVE_BALANCE,
+		}
+	}
+	state, _, err := phase0.KickStartState(spec, common.Root{123}, GenesisTime, validators)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valRoot, err := state.GenesisValidatorsRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Genesis{Spec: spec, Keys: keys, State: state, ValidatorsRoot: valRoot}
+}
+
+// Sign signs the root with the key of the validator, in the domain of the fork at the slot.
+func (g *Genesis) Sign(root common.Root, domainType common.BLSDomainType, slot common.Slot, index common.ValidatorIndex) common.BLSSignature {
+	dom := common.ComputeDomain(domainType, g.Spec.ForkVersion(slot), g.ValidatorsRoot)
+	msg := common.ComputeSigningRoot(root, dom)
+	return blsu.Sign(g.Keys[index], msg[:]).Serialize()
+}
+
+// BuildBlock creates a signed block on top of the parent, with the state of the parent processed up to the slot.
+// The state and epochs-context are transitioned into the post-state of the block.
+// The graffiti is used to create different blocks at the same slot.
+func (g *Genesis) BuildBlock(t testing.TB, state common.BeaconState, epc *common.EpochsContext,
+	parent common.Root, slot common.Slot, graffiti byte) *common.BeaconBlockEnvelope {
+	ctx := context.Background()
+	proposer, err := epc.GetBeaconProposer(slot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eth1Data, err := state.Eth1Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	epoch := g.Spec.SlotToEpoch(slot)
+	block := &phase0.SignedBeaconBlock{Message: phase0.BeaconBlock{
+		Slot:          slot,
+		ProposerIndex: proposer,
+		ParentRoot:    parent,
+		Body: phase0.BeaconBlockBody{
+			RandaoReveal: g.Sign(epoch.HashTreeRoot(tree.GetHashFn()), common.DOMAIN_RANDAO, slot, proposer),
+			Eth1Data:     eth1Data,
+			Graffiti:     common.Root{graffiti},
+		},
+	}}
+	digest := common.ComputeForkDigest(g.Spec.ForkVersion(slot), g.ValidatorsRoot)
+	if err := common.PostSlotTransition(ctx, g.Spec, epc, state, block.Envelope(g.Spec, digest), false); err != nil {
+		t.Fatal(err)
+	}
+	block.Message.StateRoot = state.HashTreeRoot(tree.GetHashFn())
+	benv := block.Envelope(g.Spec, digest)
+	block.Signature = g.Sign(benv.BlockRoot, common.DOMAIN_BEACON_PROPOSER, slot, proposer)
+	return block.Envelope(g.Spec, digest)
+}
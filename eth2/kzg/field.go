@@ -0,0 +1,105 @@
+package kzg
+
+import (
+	"crypto/sha256"
+	"errors"
+	"math/big"
+
+	kbls "github.com/kilic/bls12-381"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+// BLS_MODULUS is the order of the BLS12-381 scalar field
+var BLS_MODULUS, _ = new(big.Int).SetString("52435875175126190479447740508185965837690552500527637822603658699938581184513", 10)
+
+const PRIMITIVE_ROOT_OF_UNITY = 7
+
+var FIAT_SHAMIR_PROTOCOL_DOMAIN = []byte("FSBLOBVERIFY_V1_")
+
+var RANDOM_CHALLENGE_KZG_BATCH_DOMAIN = []byte("RCKZGBATCH___V1_")
+
+var errNonCanonicalField = errors.New("field element is not canonical, must be less than BLS_MODULUS")
+
+// bytesToBLSField converts a big-endian 32 byte field element, and checks it is canonical.
+func bytesToBLSField(b []byte) (*kbls.Fr, error) {
+	if new(big.Int).SetBytes(b).Cmp(BLS_MODULUS) >= 0 {
+		return nil, errNonCanonicalField
+	}
+	return kbls.NewFr().FromBytes(b), nil
+}
+
+// hashToBLSField hashes the data, and reduces the big-endian interpretation of the hash modulo BLS_MODULUS.
+func hashToBLSField(data []byte) *kbls.Fr {
+	h := sha256.Sum256(data)
+	v := new(big.Int).SetBytes(h[:])
+	v.Mod(v, BLS_MODULUS)
+	return kbls.NewFr().FromBytes(v.Bytes())
+}
+
+func blsFieldToBytes(v *kbls.Fr) (out common.Root) {
+	copy(out[:], v.ToBytes())
+	return
+}
+
+func frFromUint64(v uint64) *kbls.Fr {
+	return kbls.NewFr().FromBytes(new(big.Int).SetUint64(v).Bytes())
+}
+
+// computePowers returns [1, x, x**2, ..., x**(n-1)]
+func computePowers(x *kbls.Fr, n uint64) []kbls.Fr {
+	out := make([]kbls.Fr, n)
+	current := kbls.NewFr().One()
+	for i := uint64(0); i < n; i++ {
+		out[i].Set(current)
+		current.Mul(current, x)
+	}
+	return out
+}
+
+// computeRootsOfUnity returns the roots of unity of the given order, the order must be a power of 2.
+func computeRootsOfUnity(order uint64) []kbls.Fr {
+	exp := new(big.Int).Sub(BLS_MODULUS, big.NewInt(1))
+	exp.Div(exp, new(big.Int).SetUint64(order))
+	root := kbls.NewFr()
+	root.Exp(frFromUint64(PRIMITIVE_ROOT_OF_UNITY), exp)
+	return computePowers(root, order)
+}
+
+func isPowerOfTwo(v uint64) bool {
+	return v > 0 && v&(v-1) == 0
+}
+
+func reverseBits(v uint64, order uint64) uint64 {
+	out := uint64(0)
+	for order > 1 {
+		out = (out << 1) | (v & 1)
+		v >>= 1
+		order >>= 1
+	}
+	return out
+}
+
+// batchInverse inverts all the given elements in place, with a single field inversion.
+// Zero elements are left as zero.
+func batchInverse(elems []kbls.Fr) {
+	acc := make([]kbls.Fr, len(elems))
+	current := kbls.NewFr().One()
+	for i := range elems {
+		acc[i].Set(current)
+		if !elems[i].IsZero() {
+			current.Mul(current, &elems[i])
+		}
+	}
+	inv := kbls.NewFr()
+	inv.Inverse(current)
+	tmp := kbls.NewFr()
+	for i := len(elems) - 1; i >= 0; i-- {
+		if elems[i].IsZero() {
+			continue
+		}
+		tmp.Mul(inv, &acc[i])
+		inv.Mul(inv, &elems[i])
+		elems[i].Set(tmp)
+	}
+}
@@ -0,0 +1,329 @@
+package kzg
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/big"
+
+	kbls "github.com/kilic/bls12-381"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+// validateKZGG1 parses a compressed G1 point, and checks it is in the correct subgroup.
+// The point at infinity is valid.
+func validateKZGG1(b *[48]byte) (*kbls.PointG1, error) {
+	return kbls.NewG1().FromCompressed(b[:])
+}
+
+func (c *Context) blobToPolynomial(blob []byte) ([]kbls.Fr, error) {
+	if x := uint64(len(blob)); x != c.width*common.BYTES_PER_FIELD_ELEMENT {
+		return nil, fmt.Errorf("unexpected blob length: %d", x)
+	}
+	poly := make([]kbls.Fr, c.width)
+	for i := uint64(0); i < c.width; i++ {
+		v, err := bytesToBLSField(blob[i*common.BYTES_PER_FIELD_ELEMENT : (i+1)*common.BYTES_PER_FIELD_ELEMENT])
+		if err != nil {
+			return nil, fmt.Errorf("invalid blob field element %d: %w", i, err)
+		}
+		poly[i].Set(v)
+	}
+	return poly, nil
+}
+
+// computeChallenge computes the Fiat-Shamir challenge for the blob and its commitment.
+func (c *Context) computeChallenge(blob []byte, commitment *common.KZGCommitment) *kbls.Fr {
+	data := make([]byte, 0, len(FIAT_SHAMIR_PROTOCOL_DOMAIN)+16+len(blob)+common.KZGCommitmentSize)
+	data = append(data, FIAT_SHAMIR_PROTOCOL_DOMAIN...)
+	// degree of the polynomial, as 16 byte big-endian integer
+	var degree [16]byte
+	binary.BigEndian.PutUint64(degree[8:], c.width)
+	data = append(data, degree[:]...)
+	data = append(data, blob...)
+	data = append(data, commitment[:]...)
+	return hashToBLSField(data)
+}
+
+// evaluatePolynomialInEvaluationForm evaluates the polynomial, given in evaluation form
+// over the bit-reversed roots of unity, at the given point, with the barycentric formula.
+func (c *Context) evaluatePolynomialInEvaluationForm(poly []kbls.Fr, z *kbls.Fr) *kbls.Fr {
+	denominators := make([]kbls.Fr, c.width)
+	for i := uint64(0); i < c.width; i++ {
+		if z.Equal(&c.rootsOfUnityBRP[i]) {
+			// the point is in the evaluation domain, the value is known
+			return kbls.NewFr().Set(&poly[i])
+		}
+		denominators[i].Sub(z, &c.rootsOfUnityBRP[i])
+	}
+	batchInverse(denominators)
+	result := kbls.NewFr()
+	tmp := kbls.NewFr()
+	for i := uint64(0); i < c.width; i++ {
+		tmp.Mul(&poly[i], &c.rootsOfUnityBRP[i])
+		tmp.Mul(tmp, &denominators[i])
+		result.Add(result, tmp)
+	}
+	tmp.Exp(z, new(big.Int).SetUint64(c.width))
+	tmp.Sub(tmp, kbls.NewFr().One())
+	result.Mul(result, tmp)
+	result.Mul(result, c.inverseWidth)
+	return result
+}
+
+// g1LagrangeLincomb computes the linear combination of the Lagrange points of the trusted setup.
+func (c *Context) g1LagrangeLincomb(scalars []kbls.Fr) *kbls.PointG1 {
+	scalarRefs := make([]*kbls.Fr, len(scalars))
+	for i := range scalars {
+		scalarRefs[i] = &scalars[i]
+	}
+	g1 := kbls.NewG1()
+	out := g1.New()
+	// setup points are already in affine form, and thus not modified by the multi-exponentiation.
+	if _, err := g1.MultiExp(out, c.g1LagrangeBRP, scalarRefs); err != nil {
+		panic(err) // lengths are always equal
+	}
+	return out
+}
+
+// g1Lincomb computes a linear combination of a small number of points, some of which may be the point at infinity.
+func g1Lincomb(points []*kbls.PointG1, scalars []kbls.Fr) *kbls.PointG1 {
+	g1 := kbls.NewG1()
+	out := g1.Zero()
+	tmp := g1.New()
+	for i := range points {
+		g1.MulScalar(tmp, points[i], &scalars[i])
+		g1.Add(out, out, tmp)
+	}
+	return out
+}
+
+func (c *Context) verifyKZGProofImpl(commitment *kbls.PointG1, z *kbls.Fr, y *kbls.Fr, proof *kbls.PointG1) bool {
+	g1 := kbls.NewG1()
+	g2 := kbls.NewG2()
+	// [tau - z]_2
+	xMinusZ := g2.New()
+	g2.MulScalar(xMinusZ, g2.One(), z)
+	g2.Sub(xMinusZ, c.g2Tau, xMinusZ)
+	// [p(tau) - y]_1
+	pMinusY := g1.New()
+	g1.MulScalar(pMinusY, g1.One(), y)
+	g1.Sub(pMinusY, commitment, pMinusY)
+	// e(P - y, -G2) * e(proof, X - z) == 1
+	e := kbls.NewEngine()
+	e.AddPairInv(pMinusY, g2.One())
+	e.AddPair(kbls.NewG1().New().Set(proof), xMinusZ)
+	return e.Check()
+}
+
+func (c *Context) verifyKZGProofBatch(commitments []*kbls.PointG1, commitmentsBytes []common.KZGCommitment,
+	zs []kbls.Fr, ys []kbls.Fr, proofs []*kbls.PointG1, proofsBytes []common.KZGProof) bool {
+
+	n := uint64(len(commitments))
+	data := make([]byte, 0, uint64(len(RANDOM_CHALLENGE_KZG_BATCH_DOMAIN))+8+8+n*(48+32+32+48))
+	data = append(data, RANDOM_CHALLENGE_KZG_BATCH_DOMAIN...)
+	data = binary.BigEndian.AppendUint64(data, c.width)
+	data = binary.BigEndian.AppendUint64(data, n)
+	for i := uint64(0); i < n; i++ {
+		data = append(data, commitmentsBytes[i][:]...)
+		z := blsFieldToBytes(&zs[i])
+		data = append(data, z[:]...)
+		y := blsFieldToBytes(&ys[i])
+		data = append(data, y[:]...)
+		data = append(data, proofsBytes[i][:]...)
+	}
+	r := hashToBLSField(data)
+	rPowers := computePowers(r, n)
+
+	g1 := kbls.NewG1()
+	g2 := kbls.NewG2()
+
+	proofLincomb := g1Lincomb(proofs, rPowers)
+	zrPowers := make([]kbls.Fr, n)
+	for i := uint64(0); i < n; i++ {
+		zrPowers[i].Mul(&zs[i], &rPowers[i])
+	}
+	proofZLincomb := g1Lincomb(proofs, zrPowers)
+	cMinusYs := make([]*kbls.PointG1, n)
+	for i := uint64(0); i < n; i++ {
+		yG1 := g1.New()
+		g1.MulScalar(yG1, g1.One(), &ys[i])
+		cMinusYs[i] = g1.New()
+		g1.Sub(cMinusYs[i], commitments[i], yG1)
+	}
+	cMinusYLincomb := g1Lincomb(cMinusYs, rPowers)
+	rhs := g1.New()
+	g1.Add(rhs, cMinusYLincomb, proofZLincomb)
+
+	// e(proof_lincomb, -[tau]_2) * e(C_minus_y_lincomb + proof_z_lincomb, G2) == 1
+	e := kbls.NewEngine()
+	e.AddPairInv(proofLincomb, g2.New().Set(c.g2Tau))
+	e.AddPair(rhs, g2.One())
+	return e.Check()
+}
+
+// computeQuotientEvalWithinDomain computes the quotient evaluation at z, for z in the evaluation domain.
+func (c *Context) computeQuotientEvalWithinDomain(z *kbls.Fr, poly []kbls.Fr, y *kbls.Fr) *kbls.Fr {
+	denominators := make([]kbls.Fr, c.width)
+	for i := uint64(0); i < c.width; i++ {
+		omega := &c.rootsOfUnityBRP[i]
+		if omega.Equal(z) {
+			continue // left as zero, skipped
+		}
+		denominators[i].Sub(z, omega)
+		denominators[i].Mul(&denominators[i], z)
+	}
+	batchInverse(denominators)
+	result := kbls.NewFr()
+	tmp := kbls.NewFr()
+	for i := uint64(0); i < c.width; i++ {
+		omega := &c.rootsOfUnityBRP[i]
+		if omega.Equal(z) {
+			continue
+		}
+		tmp.Sub(&poly[i], y)
+		tmp.Mul(tmp, omega)
+		tmp.Mul(tmp, &denominators[i])
+		result.Add(result, tmp)
+	}
+	return result
+}
+
+func (c *Context) computeKZGProofImpl(poly []kbls.Fr, z *kbls.Fr) (*kbls.PointG1, *kbls.Fr) {
+	y := c.evaluatePolynomialInEvaluationForm(poly, z)
+	denominators := make([]kbls.Fr, c.width)
+	for i := uint64(0); i < c.width; i++ {
+		denominators[i].Sub(&c.rootsOfUnityBRP[i], z)
+	}
+	batchInverse(denominators)
+	quotient := make([]kbls.Fr, c.width)
+	for i := uint64(0); i < c.width; i++ {
+		if denominators[i].IsZero() {
+			quotient[i].Set(c.computeQuotientEvalWithinDomain(&c.rootsOfUnityBRP[i], poly, y))
+		} else {
+			quotient[i].Sub(&poly[i], y)
+			quotient[i].Mul(&quotient[i], &denominators[i])
+		}
+	}
+	return c.g1LagrangeLincomb(quotient), y
+}
+
+func g1ToKZGProof(p *kbls.PointG1) (out common.KZGProof) {
+	copy(out[:], kbls.NewG1().ToCompressed(p))
+	return
+}
+
+// BlobToKZGCommitment computes the KZG commitment of the blob.
+func (c *Context) BlobToKZGCommitment(blob []byte) (out common.KZGCommitment, err error) {
+	poly, err := c.blobToPolynomial(blob)
+	if err != nil {
+		return common.KZGCommitment{}, err
+	}
+	copy(out[:], kbls.NewG1().ToCompressed(c.g1LagrangeLincomb(poly)))
+	return out, nil
+}
+
+// ComputeKZGProof computes the KZG proof of the evaluation of the blob polynomial at z,
+// and returns the proof together with the evaluation y.
+func (c *Context) ComputeKZGProof(blob []byte, z common.Root) (common.KZGProof, common.Root, error) {
+	poly, err := c.blobToPolynomial(blob)
+	if err != nil {
+		return common.KZGProof{}, common.Root{}, err
+	}
+	zFr, err := bytesToBLSField(z[:])
+	if err != nil {
+		return common.KZGProof{}, common.Root{}, fmt.Errorf("invalid z: %w", err)
+	}
+	proof, y := c.computeKZGProofImpl(poly, zFr)
+	return g1ToKZGProof(proof), blsFieldToBytes(y), nil
+}
+
+// VerifyKZGProof verifies the claim that p(z) == y, given the commitment to p and the proof.
+func (c *Context) VerifyKZGProof(commitment common.KZGCommitment, z common.Root, y common.Root, proof common.KZGProof) (bool, error) {
+	commitmentG1, err := validateKZGG1((*[48]byte)(&commitment))
+	if err != nil {
+		return false, fmt.Errorf("invalid commitment: %w", err)
+	}
+	zFr, err := bytesToBLSField(z[:])
+	if err != nil {
+		return false, fmt.Errorf("invalid z: %w", err)
+	}
+	yFr, err := bytesToBLSField(y[:])
+	if err != nil {
+		return false, fmt.Errorf("invalid y: %w", err)
+	}
+	proofG1, err := validateKZGG1((*[48]byte)(&proof))
+	if err != nil {
+		return false, fmt.Errorf("invalid proof: %w", err)
+	}
+	return c.verifyKZGProofImpl(commitmentG1, zFr, yFr, proofG1), nil
+}
+
+// ComputeBlobKZGProof computes the KZG proof of the blob, to verify it against the commitment with.
+// The commitment is not checked to match the blob.
+func (c *Context) ComputeBlobKZGProof(blob []byte, commitment common.KZGCommitment) (common.KZGProof, error) {
+	if _, err := validateKZGG1((*[48]byte)(&commitment)); err != nil {
+		return common.KZGProof{}, fmt.Errorf("invalid commitment: %w", err)
+	}
+	poly, err := c.blobToPolynomial(blob)
+	if err != nil {
+		return common.KZGProof{}, err
+	}
+	challenge := c.computeChallenge(blob, &commitment)
+	proof, _ := c.computeKZGProofImpl(poly, challenge)
+	return g1ToKZGProof(proof), nil
+}
+
+// VerifyBlobKZGProof verifies the blob against the commitment, with the proof.
+// An error is returned if any of the inputs is malformed.
+func (c *Context) VerifyBlobKZGProof(blob []byte, commitment common.KZGCommitment, proof common.KZGProof) (bool, error) {
+	commitmentG1, err := validateKZGG1((*[48]byte)(&commitment))
+	if err != nil {
+		return false, fmt.Errorf("invalid commitment: %w", err)
+	}
+	poly, err := c.blobToPolynomial(blob)
+	if err != nil {
+		return false, err
+	}
+	challenge := c.computeChallenge(blob, &commitment)
+	y := c.evaluatePolynomialInEvaluationForm(poly, challenge)
+	proofG1, err := validateKZGG1((*[48]byte)(&proof))
+	if err != nil {
+		return false, fmt.Errorf("invalid proof: %w", err)
+	}
+	return c.verifyKZGProofImpl(commitmentG1, challenge, y, proofG1), nil
+}
+
+// VerifyBlobKZGProofBatch verifies multiple blobs against their commitments, with their proofs.
+// This is more efficient than verifying each blob individually.
+func (c *Context) VerifyBlobKZGProofBatch(blobs [][]byte, commitments []common.KZGCommitment, proofs []common.KZGProof) (bool, error) {
+	n := len(blobs)
+	if len(commitments) != n || len(proofs) != n {
+		return false, fmt.Errorf("mismatching input lengths: %d blobs, %d commitments, %d proofs", n, len(commitments), len(proofs))
+	}
+	commitmentsG1 := make([]*kbls.PointG1, n)
+	proofsG1 := make([]*kbls.PointG1, n)
+	zs := make([]kbls.Fr, n)
+	ys := make([]kbls.Fr, n)
+	for i := 0; i < n; i++ {
+		commitmentG1, err := validateKZGG1((*[48]byte)(&commitments[i]))
+		if err != nil {
+			return false, fmt.Errorf("invalid commitment %d: %w", i, err)
+		}
+		commitmentsG1[i] = commitmentG1
+		poly, err := c.blobToPolynomial(blobs[i])
+		if err != nil {
+			return false, fmt.Errorf("invalid blob %d: %w", i, err)
+		}
+		challenge := c.computeChallenge(blobs[i], &commitments[i])
+		zs[i].Set(challenge)
+		ys[i].Set(c.evaluatePolynomialInEvaluationForm(poly, challenge))
+		proofG1, err := validateKZGG1((*[48]byte)(&proofs[i]))
+		if err != nil {
+			return false, fmt.Errorf("invalid proof %d: %w", i, err)
+		}
+		proofsG1[i] = proofG1
+	}
+	return c.verifyKZGProofBatch(commitmentsG1, commitments, zs, ys, proofsG1, proofs), nil
+}
+
+var _ common.KZGVerifier = (*Context)(nil)
@@ -0,0 +1,116 @@
+package kzg
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+)
+
+const testSetupPath = "../configs/yamls/presets/mainnet/trusted_setups/trusted_setup_4096.json"
+
+var (
+	testCtx     *Context
+	testCtxErr  error
+	testCtxOnce sync.Once
+)
+
+func loadTestContext(t *testing.T) *Context {
+	testCtxOnce.Do(func() {
+		testCtx, testCtxErr = LoadTrustedSetup(testSetupPath)
+	})
+	if testCtxErr != nil {
+		t.Fatal(testCtxErr)
+	}
+	return testCtx
+}
+
+func randomBlob(rng *rand.Rand, width uint64) []byte {
+	blob := make([]byte, width*common.BYTES_PER_FIELD_ELEMENT)
+	rng.Read(blob)
+	for i := uint64(0); i < width; i++ {
+		// keep the field elements canonical
+		blob[i*common.BYTES_PER_FIELD_ELEMENT] = 0
+	}
+	return blob
+}
+
+func TestBlobKZGProof(t *testing.T) {
+	ctx := loadTestContext(t)
+	rng := rand.New(rand.NewSource(1234))
+	var blobs [][]byte
+	var commitments []common.KZGCommitment
+	var proofs []common.KZGProof
+	for i := 0; i < 3; i++ {
+		blob := randomBlob(rng, ctx.FieldElementsPerBlob())
+		commitment, err := ctx.BlobToKZGCommitment(blob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		proof, err := ctx.ComputeBlobKZGProof(blob, commitment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := ctx.VerifyBlobKZGProof(blob, commitment, proof); err != nil {
+			t.Fatal(err)
+		} else if !ok {
+			t.Fatalf("blob %d failed to verify", i)
+		}
+		blobs = append(blobs, blob)
+		commitments = append(commitments, commitment)
+		proofs = append(proofs, proof)
+	}
+	if ok, err := ctx.VerifyBlobKZGProofBatch(blobs, commitments, proofs); err != nil {
+		t.Fatal(err)
+	} else if !ok {
+		t.Fatal("batch failed to verify")
+	}
+	// swap the proofs of two blobs, the proofs should not verify anymore.
+	proofs[0], proofs[1] = proofs[1], proofs[0]
+	if ok, err := ctx.VerifyBlobKZGProof(blobs[0], commitments[0], proofs[0]); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Fatal("blob verified with wrong proof")
+	}
+	if ok, err := ctx.VerifyBlobKZGProofBatch(blobs, commitments, proofs); err != nil {
+		t.Fatal(err)
+	} else if ok {
+		t.Fatal("batch verified with wrong proofs")
+	}
+}
+
+func TestKZGProofWithinDomain(t *testing.T) {
+	ctx := loadTestContext(t)
+	rng := rand.New(rand.NewSource(5678))
+	blob := randomBlob(rng, ctx.FieldElementsPerBlob())
+	commitment, err := ctx.BlobToKZGCommitment(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// evaluating at a root of unity returns the blob field element at that position
+	z := blsFieldToBytes(&ctx.rootsOfUnityBRP[42])
+	proof, y, err := ctx.ComputeKZGProof(blob, z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := blob[42*32 : 43*32]; string(y[:]) != string(expected) {
+		t.Fatalf("unexpected evaluation: %x <> %x", y, expected)
+	}
+	if ok, err := ctx.VerifyKZGProof(commitment, z, y, proof); err != nil {
+		t.Fatal(err)
+	} else if !ok {
+		t.Fatal("proof within domain failed to verify")
+	}
+}
+
+func TestNonCanonicalBlob(t *testing.T) {
+	ctx := loadTestContext(t)
+	blob := make([]byte, ctx.FieldElementsPerBlob()*common.BYTES_PER_FIELD_ELEMENT)
+	for i := 0; i < 32; i++ {
+		blob[i] = 0xff
+	}
+	if _, err := ctx.BlobToKZGCommitment(blob); err == nil {
+		t.Fatal("expected error for non-canonical field element")
+	}
+}
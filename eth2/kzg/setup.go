@@ -0,0 +1,122 @@
+package kzg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	kbls "github.com/kilic/bls12-381"
+	"github.com/protolambda/ztyp/conv"
+)
+
+type G1Point [48]byte
+
+func (p G1Point) MarshalText() ([]byte, error) {
+	return conv.BytesMarshalText(p[:])
+}
+
+func (p *G1Point) UnmarshalText(text []byte) error {
+	if p == nil {
+		return errors.New("cannot decode into nil G1Point")
+	}
+	return conv.FixedBytesUnmarshalText(p[:], text)
+}
+
+type G2Point [96]byte
+
+func (p G2Point) MarshalText() ([]byte, error) {
+	return conv.BytesMarshalText(p[:])
+}
+
+func (p *G2Point) UnmarshalText(text []byte) error {
+	if p == nil {
+		return errors.New("cannot decode into nil G2Point")
+	}
+	return conv.FixedBytesUnmarshalText(p[:], text)
+}
+
+// TrustedSetup is the JSON format of the trusted setup, as published with the consensus specs.
+// The G1 points are in Lagrange form, in natural order, the G2 points in monomial form.
+type TrustedSetup struct {
+	G1Lagrange []G1Point `json:"g1_lagrange"`
+	G2Monomial []G2Point `json:"g2_monomial"`
+}
+
+// Context holds the parsed trusted setup and precomputed roots of unity,
+// to compute and verify KZG commitments and proofs with. It is safe for concurrent use.
+type Context struct {
+	// Number of field elements per blob
+	width uint64
+	// G1 Lagrange points, in bit-reversal permutation
+	g1LagrangeBRP []*kbls.PointG1
+	// [tau]_2
+	g2Tau *kbls.PointG2
+	// Roots of unity of the evaluation domain, in bit-reversal permutation
+	rootsOfUnityBRP []kbls.Fr
+	// 1 / width
+	inverseWidth *kbls.Fr
+}
+
+// NewContext parses and validates the trusted setup points.
+func NewContext(setup *TrustedSetup) (*Context, error) {
+	width := uint64(len(setup.G1Lagrange))
+	if !isPowerOfTwo(width) {
+		return nil, fmt.Errorf("trusted setup must have a power of 2 number of G1 points, got %d", width)
+	}
+	if len(setup.G2Monomial) < 2 {
+		return nil, fmt.Errorf("trusted setup must have at least 2 G2 points, got %d", len(setup.G2Monomial))
+	}
+	g1 := kbls.NewG1()
+	g1LagrangeBRP := make([]*kbls.PointG1, width)
+	for i := uint64(0); i < width; i++ {
+		p, err := g1.FromCompressed(setup.G1Lagrange[i][:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid G1 lagrange point %d: %w", i, err)
+		}
+		g1LagrangeBRP[reverseBits(i, width)] = p
+	}
+	g2Tau, err := kbls.NewG2().FromCompressed(setup.G2Monomial[1][:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid G2 monomial point 1: %w", err)
+	}
+	roots := computeRootsOfUnity(width)
+	rootsOfUnityBRP := make([]kbls.Fr, width)
+	for i := uint64(0); i < width; i++ {
+		rootsOfUnityBRP[i].Set(&roots[reverseBits(i, width)])
+	}
+	inverseWidth := kbls.NewFr()
+	inverseWidth.Inverse(frFromUint64(width))
+	return &Context{
+		width:           width,
+		g1LagrangeBRP:   g1LagrangeBRP,
+		g2Tau:           g2Tau,
+		rootsOfUnityBRP: rootsOfUnityBRP,
+		inverseWidth:    inverseWidth,
+	}, nil
+}
+
+// ParseTrustedSetup decodes the trusted setup from JSON, and parses it into a Context.
+func ParseTrustedSetup(r io.Reader) (*Context, error) {
+	var setup TrustedSetup
+	if err := json.NewDecoder(r).Decode(&setup); err != nil {
+		return nil, fmt.Errorf("failed to decode trusted setup: %w", err)
+	}
+	return NewContext(&setup)
+}
+
+// LoadTrustedSetup reads the trusted setup from a JSON file, and parses it into a Context.
+func LoadTrustedSetup(path string) (*Context, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trusted setup file: %w", err)
+	}
+	defer f.Close()
+	return ParseTrustedSetup(f)
+}
+
+// FieldElementsPerBlob is the number of field elements in a blob, as determined by the trusted setup.
+func (c *Context) FieldElementsPerBlob() uint64 {
+	return c.width
+}
@@ -1,14 +1,17 @@
 package pool
 
 import (
+	"container/heap"
 	"context"
 	"errors"
 	"fmt"
 	"sync"
 	"time"
 
+	"github.com/protolambda/zrnt/eth2/beacon/altair"
 	"github.com/protolambda/zrnt/eth2/beacon/common"
 	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/zrnt/eth2/util/math"
 	"github.com/protolambda/ztyp/tree"
 )
 
@@ -64,6 +67,7 @@ func NewAttestationPool(spec *common.Spec) *AttestationPool {
 		datas:              make(map[common.Root]*IndexedAttData),
 		individual:         make(map[Assignment]*AttRef),
 		aggregate:          make(map[common.Root]*MinAggregates),
+		aggPerValidator:    make(map[Assignment]common.Root),
 		maxExtraAggregates: 10, // TODO: worth tuning
 	}
 }
@@ -103,12 +107,12 @@ func (ap *AttestationPool) AddAttestation(ctx context.Context, att *phase0.Attes
 			}
 		}
 		ap.individual[key] = &AttRef{DataRoot: dataRoot, Sig: att.Signature}
-		return nil
+		return ap.addIndividual(dataRoot, key, att)
 	}
 
 	// aggregates: don't store more than we have to.
 	// Sometimes we find some different ones, keep those, every attester counts.
-	// No aggregation yet, we can put together the best version later.
+	// Individual attestations that the aggregate is missing are added to it.
 	if existing, ok := ap.aggregate[dataRoot]; ok {
 		if covers, err := existing.Participants.Covers(att.AggregationBits); err != nil {
 			return fmt.Errorf("could not compare aggregation bitfields: %v", err)
@@ -123,8 +127,12 @@ func (ap *AttestationPool) AddAttestation(ctx context.Context, att *phase0.Attes
 			return nil
 		} else {
 			// this aggregate adds additional participants compared to the total we had before, keep it!
-			existing.Aggregates = append(existing.Aggregates,
-				Aggregate{Participants: att.AggregationBits, Sig: att.Signature})
+			agg, err := ap.withIndividuals(dataRoot, att, committee)
+			if err != nil {
+				return err
+			}
+			existing.Aggregates = append(existing.Aggregates, agg)
+			existing.Participants.Or(agg.Participants)
 
 			// remember the participants attested this epoch
 			key := Assignment{Index: 0, Epoch: att.Data.Target.Epoch}
@@ -150,10 +158,14 @@ func (ap *AttestationPool) AddAttestation(ctx context.Context, att *phase0.Attes
 			}
 		}
 		if hasNewAttester {
+			agg, err := ap.withIndividuals(dataRoot, att, committee)
+			if err != nil {
+				return err
+			}
 			ap.aggregate[dataRoot] = &MinAggregates{
-				Aggregates: []Aggregate{{Participants: att.AggregationBits, Sig: att.Signature}},
+				Aggregates: []Aggregate{agg},
 				// copy, we mutate this bitfield later, while still using the original (stored in above array)
-				Participants: att.AggregationBits.Copy(),
+				Participants: agg.Participants.Copy(),
 			}
 		} else {
 			return fmt.Errorf("ignoring new attestation for different data:" +
@@ -163,6 +175,94 @@ func (ap *AttestationPool) AddAttestation(ctx context.Context, att *phase0.Attes
 	}
 }
 
+// addIndividual adds the individual attestation to every aggregate of the same data that is missing the participant,
+// or starts a new aggregate if there is none yet.
+func (ap *AttestationPool) addIndividual(dataRoot common.Root, key Assignment, att *phase0.Attestation) error {
+	if _, ok := ap.aggPerValidator[key]; !ok {
+		ap.aggPerValidator[key] = dataRoot
+	}
+	existing, ok := ap.aggregate[dataRoot]
+	if !ok {
+		ap.aggregate[dataRoot] = &MinAggregates{
+			Aggregates:   []Aggregate{{Participants: att.AggregationBits.Copy(), Sig: att.Signature}},
+			Participants: att.AggregationBits.Copy(),
+		}
+		return nil
+	}
+	if covers, err := existing.Participants.Covers(att.AggregationBits); err != nil {
+		return fmt.Errorf("could not compare aggregation bitfields: %v", err)
+	} else if !covers {
+		existing.Participants.Or(att.AggregationBits)
+	}
+	for i := range existing.Aggregates {
+		agg := &existing.Aggregates[i]
+		if covers, err := agg.Participants.Covers(att.AggregationBits); err != nil {
+			return fmt.Errorf("could not compare aggregation bitfields: %v", err)
+		} else if covers {
+			continue
+		}
+		sig, err := aggregateSignatures([]*common.BLSSignature{&agg.Sig, &att.Signature})
+		if err != nil {
+			return fmt.Errorf("failed to aggregate attestation signatures: %v", err)
+		}
+		// copy, the previous bitfield may still be in use by earlier search results
+		participants := agg.Participants.Copy()
+		participants.Or(att.AggregationBits)
+		*agg = Aggregate{Participants: participants, Sig: sig}
+	}
+	return nil
+}
+
+// withIndividuals creates an aggregate of the attestation and the individual attestations
+// of the same data that it does not include yet.
+func (ap *AttestationPool) withIndividuals(dataRoot common.Root, att *phase0.Attestation, committee common.CommitteeIndices) (Aggregate, error) {
+	participants := att.AggregationBits
+	sigs := []*common.BLSSignature{&att.Signature}
+	for i, vi := range committee {
+		if att.AggregationBits.GetBit(uint64(i)) {
+			continue
+		}
+		if ref, ok := ap.individual[Assignment{Index: vi, Epoch: att.Data.Target.Epoch}]; ok && ref.DataRoot == dataRoot {
+			if len(sigs) == 1 {
+				// copy, the attestation bitfield belongs to the caller
+				participants = participants.Copy()
+			}
+			participants.SetBit(uint64(i), true)
+			sigs = append(sigs, &ref.Sig)
+		}
+	}
+	if len(sigs) == 1 {
+		return Aggregate{Participants: participants, Sig: att.Signature}, nil
+	}
+	sig, err := aggregateSignatures(sigs)
+	if err != nil {
+		return Aggregate{}, fmt.Errorf("failed to aggregate attestation signatures: %v", err)
+	}
+	return Aggregate{Participants: participants, Sig: sig}, nil
+}
+
+// GetAggregate returns the aggregate with the most participants for the given attestation data root,
+// e.g. for an aggregator to publish it.
+func (ap *AttestationPool) GetAggregate(dataRoot common.Root) (*phase0.Attestation, bool) {
+	ap.RLock()
+	defer ap.RUnlock()
+	d, ok := ap.datas[dataRoot]
+	if !ok {
+		return nil, false
+	}
+	agg, ok := ap.aggregate[dataRoot]
+	if !ok || len(agg.Aggregates) == 0 {
+		return nil, false
+	}
+	best := &agg.Aggregates[0]
+	for i := 1; i < len(agg.Aggregates); i++ {
+		if a := &agg.Aggregates[i]; a.Participants.OnesCount() > best.Participants.OnesCount() {
+			best = a
+		}
+	}
+	return &phase0.Attestation{AggregationBits: best.Participants, Data: d.Data, Signature: best.Sig}, true
+}
+
 type attSearch struct {
 	slot *common.Slot
 	comm *common.CommitteeIndex
@@ -183,6 +283,8 @@ func WithCommittee(index common.CommitteeIndex) AttSearchOption {
 }
 
 func (ap *AttestationPool) Search(opts ...AttSearchOption) (out []*phase0.Attestation) {
+	ap.RLock()
+	defer ap.RUnlock()
 	var conf attSearch
 	for _, opt := range opts {
 		opt(&conf)
@@ -194,11 +296,14 @@ func (ap *AttestationPool) Search(opts ...AttSearchOption) (out []*phase0.Attest
 		if conf.comm != nil && d.Data.Index != *conf.comm {
 			continue
 		}
-		agg := ap.aggregate[k]
+		agg, ok := ap.aggregate[k]
+		if !ok {
+			continue
+		}
+		// individual attestations are included in the aggregates
 		for _, a := range agg.Aggregates {
 			out = append(out, &phase0.Attestation{AggregationBits: a.Participants, Data: d.Data, Signature: a.Sig})
 		}
-		// TODO: could add individual attestations
 	}
 	return out
 }
@@ -224,16 +329,185 @@ func (ap *AttestationPool) Prune(epoch common.Epoch) {
 	}
 }
 
-// Approximation of the optimal attestation packing.
-// Attestations must match source, get prioritized if the target is correct, and more if the head is correct.
-// Attestations may not be included if they already are (checked via included func).
-// Maximum attestation output and packing-time constraints apply.
+// packCandidate is an attestation that may be packed, with the reward weight of its new participants.
+type packCandidate struct {
+	att *phase0.Attestation
+	// participants of the attestation, as (validator, epoch) assignments
+	participants []Assignment
+	// reward weight of a single new participant, depends on the correctness of the target and head
+	weight common.Gwei
+	// score is the weight of all new participants, as of the last time it was computed
+	score common.Gwei
+}
+
+// packQueue is a max-heap of candidates, by score.
+type packQueue []*packCandidate
+
+func (q packQueue) Len() int           { return len(q) }
+func (q packQueue) Less(i, j int) bool { return q[i].score > q[j].score }
+func (q packQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+
+func (q *packQueue) Push(x interface{}) {
+	*q = append(*q, x.(*packCandidate))
+}
+
+func (q *packQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	x := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return x
+}
+
+// Approximation of the optimal attestation packing, for a block at the given slot.
+// Attestations must match source, and are prioritized by the participation flags they would earn,
+// like the state the attestations are included in computes them:
+// the source must be timely, the target must be canonical, and the head must be canonical and included right away.
+// Attestations of the epoch of the slot and the epoch before are considered, if they can be included at the slot.
+// Attestations of the epoch of the slot must match the source, the current justified checkpoint,
+// and those of the epoch before the previous source, the previous justified checkpoint.
+// The blockRootAt func returns the root of the canonical block at or before the given slot,
+// like common.GetBlockRootAtSlot does in the pre-state of the block, and is only called with slots before the given slot.
+// Attestations may not be included if they already are (checked via included func, which may be nil).
+// Maximum attestation output (capped to MAX_ATTESTATIONS, 0 for no other limit)
+// and packing-time (0 for no limit) constraints apply.
+//
+// The packing greedily solves the max-coverage problem: the attestation that adds the most reward weight
+// of validators that are not covered yet is picked first, until no attestation adds anything new.
 func (ap *AttestationPool) Packing(ctx context.Context,
-	source common.Checkpoint, target common.Checkpoint,
-	headRoot common.Root, headSlot common.Slot,
+	source common.Checkpoint, prevSource common.Checkpoint,
+	slot common.Slot, blockRootAt func(slot common.Slot) (common.Root, error),
 	maxCount uint64, maxTime time.Duration,
-	included func(epoch common.Epoch, index common.ValidatorIndex)) ([]phase0.Attestation, error) {
+	included func(epoch common.Epoch, index common.ValidatorIndex) bool) ([]phase0.Attestation, error) {
+
+	var deadline time.Time
+	if maxTime > 0 {
+		deadline = time.Now().Add(maxTime)
+	}
+	if max := uint64(ap.spec.MAX_ATTESTATIONS); maxCount == 0 || maxCount > max {
+		maxCount = max
+	}
+
+	ap.RLock()
+	defer ap.RUnlock()
 
-	// TODO find best attestations to pack for profit.
-	return nil, nil
+	covered := make(map[Assignment]struct{})
+	isNew := func(a Assignment) bool {
+		if _, ok := covered[a]; ok {
+			return false
+		}
+		return included == nil || !included(a.Epoch, a.Index)
+	}
+	score := func(c *packCandidate) (out common.Gwei) {
+		for _, a := range c.participants {
+			if isNew(a) {
+				out += c.weight
+			}
+		}
+		return out
+	}
+
+	spec := ap.spec
+	epoch := spec.SlotToEpoch(slot)
+	timelySource := common.Slot(math.IntegerSquareroot(uint64(spec.SLOTS_PER_EPOCH)))
+	var queue packQueue
+	for dataRoot, d := range ap.datas {
+		data := &d.Data
+		if data.Slot+spec.MIN_ATTESTATION_INCLUSION_DELAY > slot {
+			continue
+		}
+		// Before Deneb attestations can only be included within an epoch, which also keeps the target timely.
+		if epoch < spec.DENEB_FORK_EPOCH && data.Slot+spec.SLOTS_PER_EPOCH < slot {
+			continue
+		}
+		switch data.Target.Epoch {
+		case epoch:
+			if data.Source != source {
+				continue
+			}
+		case epoch.Previous():
+			if data.Source != prevSource {
+				continue
+			}
+		default:
+			continue
+		}
+		inclusionDelay := slot - data.Slot
+		var weight common.Gwei
+		if inclusionDelay <= timelySource {
+			weight += altair.TIMELY_SOURCE_WEIGHT
+		}
+		targetSlot, err := spec.EpochStartSlot(data.Target.Epoch)
+		if err != nil {
+			continue
+		}
+		targetRoot, err := blockRootAt(targetSlot)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get target block root of epoch %d: %v", data.Target.Epoch, err)
+		}
+		if data.Target.Root == targetRoot {
+			weight += altair.TIMELY_TARGET_WEIGHT
+			if inclusionDelay == spec.MIN_ATTESTATION_INCLUSION_DELAY {
+				headRoot, err := blockRootAt(data.Slot)
+				if err != nil {
+					return nil, fmt.Errorf("failed to get head block root of slot %d: %v", data.Slot, err)
+				}
+				if data.BeaconBlockRoot == headRoot {
+					weight += altair.TIMELY_HEAD_WEIGHT
+				}
+			}
+		}
+		if weight == 0 {
+			continue
+		}
+		addAggregate := func(agg *Aggregate) {
+			c := &packCandidate{
+				att:    &phase0.Attestation{AggregationBits: agg.Participants, Data: *data, Signature: agg.Sig},
+				weight: weight,
+			}
+			for _, vi := range agg.Participants.FilterParticipants(d.Committee) {
+				c.participants = append(c.participants, Assignment{Index: vi, Epoch: data.Target.Epoch})
+			}
+			queue = append(queue, c)
+		}
+		if agg, ok := ap.aggregate[dataRoot]; ok {
+			for i := range agg.Aggregates {
+				addAggregate(&agg.Aggregates[i])
+			}
+			for i := range agg.Extra {
+				addAggregate(&agg.Extra[i])
+			}
+		}
+	}
+	for _, c := range queue {
+		c.score = score(c)
+	}
+	heap.Init(&queue)
+
+	// Lazy greedy: scores only decrease as more validators are covered,
+	// so a candidate that is still the best after updating its score is the best overall.
+	var out []phase0.Attestation
+	for uint64(len(out)) < maxCount && queue.Len() > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			break
+		}
+		best := heap.Pop(&queue).(*packCandidate)
+		best.score = score(best)
+		if best.score == 0 {
+			continue
+		}
+		if queue.Len() > 0 && best.score < queue[0].score {
+			heap.Push(&queue, best)
+			continue
+		}
+		for _, a := range best.participants {
+			covered[a] = struct{}{}
+		}
+		out = append(out, *best.att)
+	}
+	return out, nil
 }
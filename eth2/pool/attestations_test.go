@@ -0,0 +1,334 @@
+package pool
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/protolambda/ztyp/tree"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/zrnt/eth2/configs"
+)
+
+type testAtt struct {
+	slot   common.Slot
+	index  common.CommitteeIndex
+	source common.Checkpoint
+	target common.Checkpoint
+	head   common.Root
+	// positions of the participants in the committee
+	bits []uint64
+}
+
+const testCommitteeSize = 8
+
+// testCommittee returns a committee that does not overlap with that of any other slot and index.
+func testCommittee(slot common.Slot, index common.CommitteeIndex) common.CommitteeIndices {
+	out := make(common.CommitteeIndices, testCommitteeSize)
+	for i := range out {
+		out[i] = common.ValidatorIndex((uint64(slot)*64+uint64(index))*testCommitteeSize + uint64(i))
+	}
+	return out
+}
+
+// attestation creates the attestation, the signature is not valid, and only identifies the attestation with the id.
+func (ta *testAtt) attestation(id int) *phase0.Attestation {
+	bits := make(phase0.AttestationBits, testCommitteeSize/8+1)
+	bits[testCommitteeSize/8] |= 1 << (testCommitteeSize % 8)
+	for _, b := range ta.bits {
+		bits.SetBit(b, true)
+	}
+	var sig common.BLSSignature
+	sig[0] = byte(id >> 8)
+	sig[1] = byte(id)
+	return &phase0.Attestation{
+		AggregationBits: bits,
+		Data: phase0.AttestationData{
+			Slot:            ta.slot,
+			Index:           ta.index,
+			BeaconBlockRoot: ta.head,
+			Source:          ta.source,
+			Target:          ta.target,
+		},
+		Signature: sig,
+	}
+}
+
+// testBlockRoot is the root of the canonical block at the slot, every slot has a block in the test chain.
+func testBlockRoot(slot common.Slot) common.Root {
+	return common.Root{0x80, byte(slot)}
+}
+
+func TestAttestationPacking(t *testing.T) {
+	spec := configs.Minimal
+	// the block is proposed in the last slot of epoch 3
+	slot := common.Slot(31)
+	blockRootAt := func(s common.Slot) (common.Root, error) {
+		if s >= slot {
+			return common.Root{}, fmt.Errorf("slot %d is not before the proposal slot", s)
+		}
+		return testBlockRoot(s), nil
+	}
+	prevSource := common.Checkpoint{Epoch: 1, Root: common.Root{1}}
+	source := common.Checkpoint{Epoch: 2, Root: common.Root{2}}
+	prevTarget := common.Checkpoint{Epoch: 2, Root: testBlockRoot(16)}
+	target := common.Checkpoint{Epoch: 3, Root: testBlockRoot(24)}
+	otherTarget := common.Checkpoint{Epoch: 3, Root: common.Root{0xee}}
+	otherRoot := common.Root{0xbb}
+	all := []uint64{0, 1, 2, 3, 4, 5, 6, 7}
+
+	many := make([]testAtt, spec.MAX_ATTESTATIONS+2)
+	for i := range many {
+		s := 24 + common.Slot(i/32)
+		many[i] = testAtt{slot: s, index: common.CommitteeIndex(i % 32),
+			source: source, target: target, head: testBlockRoot(s), bits: all}
+	}
+
+	cases := []struct {
+		name     string
+		atts     []testAtt
+		maxCount uint64
+		included func(epoch common.Epoch, index common.ValidatorIndex) bool
+		// ids of the expected attestations, in packing order
+		expected []int
+		// only the number of packed attestations is checked, if expected is nil
+		expectedCount int
+	}{
+		{
+			name: "greedy coverage",
+			atts: []testAtt{
+				{slot: 30, source: source, target: target, head: testBlockRoot(30), bits: []uint64{0, 1, 2, 3, 4, 5}},
+				{slot: 30, source: source, target: target, head: testBlockRoot(30), bits: []uint64{0, 1, 2}},
+				{slot: 30, source: source, target: target, head: testBlockRoot(30), bits: []uint64{4, 5, 6, 7}},
+			},
+			expected: []int{0, 2},
+		},
+		{
+			name: "target and head weight",
+			atts: []testAtt{
+				// 4 * (14 + 26) = 160
+				{slot: 30, index: 1, source: source, target: target, head: otherRoot, bits: []uint64{0, 1, 2, 3}},
+				// 3 * (14 + 26 + 14) = 162
+				{slot: 30, index: 0, source: source, target: target, head: testBlockRoot(30), bits: []uint64{0, 1, 2}},
+				// 8 * 26 = 208: the previous target is canonical, the source is not timely anymore
+				{slot: 23, index: 0, source: prevSource, target: prevTarget, head: testBlockRoot(23), bits: all},
+			},
+			expected: []int{2, 1, 0},
+		},
+		{
+			name: "head weight only when included right away",
+			atts: []testAtt{
+				// 8 * (14 + 26) = 320: included one slot late
+				{slot: 29, index: 0, source: source, target: target, head: testBlockRoot(29), bits: all},
+				// 6 * (14 + 26 + 14) = 324
+				{slot: 30, index: 0, source: source, target: target, head: testBlockRoot(30), bits: []uint64{0, 1, 2, 3, 4, 5}},
+			},
+			expected: []int{1, 0},
+		},
+		{
+			name: "head weight only for the block at the slot",
+			atts: []testAtt{
+				// 8 * (14 + 26) = 320: the head is canonical, but not the block at the slot
+				{slot: 30, index: 0, source: source, target: target, head: testBlockRoot(29), bits: all},
+				// 6 * (14 + 26 + 14) = 324
+				{slot: 30, index: 1, source: source, target: target, head: testBlockRoot(30), bits: []uint64{0, 1, 2, 3, 4, 5}},
+			},
+			expected: []int{1, 0},
+		},
+		{
+			name: "head weight only with correct target",
+			atts: []testAtt{
+				// 8 * 14 = 112
+				{slot: 30, index: 0, source: source, target: otherTarget, head: testBlockRoot(30), bits: all},
+				// 3 * (14 + 26 + 14) = 162
+				{slot: 30, index: 1, source: source, target: target, head: testBlockRoot(30), bits: []uint64{0, 1, 2}},
+			},
+			expected: []int{1, 0},
+		},
+		{
+			name: "timely source",
+			atts: []testAtt{
+				// no weight: the source is not timely, and the target is not canonical
+				{slot: 28, index: 0, source: source, target: otherTarget, head: testBlockRoot(28), bits: all},
+				// 8 * 14 = 112
+				{slot: 29, index: 0, source: source, target: otherTarget, head: testBlockRoot(29), bits: all},
+			},
+			expected: []int{1},
+		},
+		{
+			name: "source of target epoch",
+			atts: []testAtt{
+				{slot: 30, index: 0, source: prevSource, target: target, head: testBlockRoot(30), bits: all},
+				{slot: 23, index: 0, source: source, target: prevTarget, head: testBlockRoot(23), bits: all},
+				{slot: 23, index: 1, source: prevSource, target: prevTarget, head: testBlockRoot(23), bits: all},
+				{slot: 30, index: 1, source: source, target: target, head: testBlockRoot(30), bits: all},
+			},
+			expected: []int{3, 2},
+		},
+		{
+			name: "epochs and inclusion slot",
+			atts: []testAtt{
+				{slot: 15, index: 0, source: prevSource, target: common.Checkpoint{Epoch: 1, Root: testBlockRoot(8)}, head: testBlockRoot(15), bits: all},
+				// too old to be included before deneb
+				{slot: 22, index: 0, source: prevSource, target: prevTarget, head: testBlockRoot(22), bits: all},
+				// too new to be included
+				{slot: 31, index: 0, source: source, target: target, head: otherRoot, bits: all},
+				{slot: 30, index: 0, source: source, target: target, head: testBlockRoot(30), bits: all},
+			},
+			expected: []int{3},
+		},
+		{
+			name: "already included",
+			atts: []testAtt{
+				{slot: 30, source: source, target: target, head: testBlockRoot(30), bits: []uint64{0, 1, 2, 3, 4, 5}},
+				{slot: 30, source: source, target: target, head: testBlockRoot(30), bits: []uint64{4, 5, 6, 7}},
+			},
+			included: func(epoch common.Epoch, index common.ValidatorIndex) bool {
+				return epoch == target.Epoch && index < testCommittee(30, 0)[4]
+			},
+			expected: []int{1},
+		},
+		{
+			name:          "max count",
+			atts:          many[:10],
+			maxCount:      3,
+			expectedCount: 3,
+		},
+		{
+			name:          "max attestations",
+			atts:          many,
+			expectedCount: int(spec.MAX_ATTESTATIONS),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ap := NewAttestationPool(spec)
+			for i := range c.atts {
+				ta := &c.atts[i]
+				if err := ap.AddAttestation(context.Background(), ta.attestation(i), testCommittee(ta.slot, ta.index)); err != nil {
+					t.Fatalf("attestation %d: %v", i, err)
+				}
+			}
+			out, err := ap.Packing(context.Background(), source, prevSource, slot, blockRootAt,
+				c.maxCount, 0, c.included)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.expected == nil {
+				if len(out) != c.expectedCount {
+					t.Fatalf("expected %d attestations, got %d", c.expectedCount, len(out))
+				}
+				return
+			}
+			var ids []int
+			for _, att := range out {
+				ids = append(ids, int(att.Signature[0])<<8|int(att.Signature[1]))
+			}
+			if fmt.Sprint(ids) != fmt.Sprint(c.expected) {
+				t.Fatalf("expected attestations %v, got %v", c.expected, ids)
+			}
+		})
+	}
+}
+
+func TestAttestationAggregation(t *testing.T) {
+	spec := configs.Minimal
+	keys := newTestKeys(t, testCommitteeSize)
+	source := common.Checkpoint{Epoch: 2, Root: common.Root{2}}
+	target := common.Checkpoint{Epoch: 3, Root: common.Root{3}}
+	headRoot := common.Root{0xaa}
+	otherRoot := common.Root{0xbb}
+	type add struct {
+		// positions of the participants in the committee, every participant signs
+		bits []uint64
+		head common.Root
+	}
+	cases := []struct {
+		name string
+		adds []add
+		// participants of every aggregate of the head root
+		expected [][]uint64
+	}{
+		{
+			name:     "individuals start an aggregate",
+			adds:     []add{{bits: []uint64{0}}, {bits: []uint64{1}}},
+			expected: [][]uint64{{0, 1}},
+		},
+		{
+			name:     "individual added to aggregates missing it",
+			adds:     []add{{bits: []uint64{0, 1, 2}}, {bits: []uint64{2, 3}}, {bits: []uint64{4}}},
+			expected: [][]uint64{{0, 1, 2, 4}, {2, 3, 4}},
+		},
+		{
+			name:     "aggregate with earlier individuals",
+			adds:     []add{{bits: []uint64{5}}, {bits: []uint64{6}}, {bits: []uint64{0, 1}}},
+			expected: [][]uint64{{5, 6}, {0, 1, 5, 6}},
+		},
+		{
+			name:     "individuals of other data",
+			adds:     []add{{bits: []uint64{0}, head: otherRoot}, {bits: []uint64{1}}, {bits: []uint64{2, 3}}},
+			expected: [][]uint64{{1}, {1, 2, 3}},
+		},
+		{
+			name:     "individual already in aggregate",
+			adds:     []add{{bits: []uint64{0, 1}}, {bits: []uint64{1}}},
+			expected: [][]uint64{{0, 1}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ap := NewAttestationPool(spec)
+			var dataRoot common.Root
+			var atts []*phase0.Attestation
+			for i, a := range c.adds {
+				head := a.head
+				if head == (common.Root{}) {
+					head = headRoot
+				}
+				ta := &testAtt{slot: 24, source: source, target: target, head: head, bits: a.bits}
+				att := ta.attestation(i)
+				root := att.Data.HashTreeRoot(tree.GetHashFn())
+				if head == headRoot {
+					dataRoot = root
+				}
+				signers := make([]common.ValidatorIndex, 0, len(a.bits))
+				for _, b := range a.bits {
+					signers = append(signers, common.ValidatorIndex(b))
+				}
+				att.Signature = keys.aggregate(signers, root[:])
+				if err := ap.AddAttestation(context.Background(), att, testCommittee(ta.slot, ta.index)); err != nil {
+					t.Fatalf("attestation %d: %v", i, err)
+				}
+				atts = append(atts, att)
+			}
+			aggs := ap.aggregate[dataRoot].Aggregates
+			if len(aggs) != len(c.expected) {
+				t.Fatalf("expected %d aggregates, got %d", len(c.expected), len(aggs))
+			}
+			for i, expected := range c.expected {
+				var bits []uint64
+				var signers []common.ValidatorIndex
+				for b := uint64(0); b < testCommitteeSize; b++ {
+					if aggs[i].Participants.GetBit(b) {
+						bits = append(bits, b)
+						signers = append(signers, common.ValidatorIndex(b))
+					}
+				}
+				if fmt.Sprint(bits) != fmt.Sprint(expected) {
+					t.Fatalf("aggregate %d: expected participants %v, got %v", i, expected, bits)
+				}
+				if !keys.verify(signers, dataRoot[:], aggs[i].Sig) {
+					t.Fatalf("aggregate %d: signature does not verify", i)
+				}
+			}
+			// the bitfields of the added attestations are not modified
+			for i, att := range atts {
+				if count := att.AggregationBits.OnesCount(); count != uint64(len(c.adds[i].bits)) {
+					t.Fatalf("attestation %d: bitfield modified", i)
+				}
+			}
+		})
+	}
+}
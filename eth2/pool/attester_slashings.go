@@ -1,8 +1,10 @@
 package pool
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/protolambda/zrnt/eth2/beacon/common"
@@ -49,6 +51,132 @@ func (asp *AttesterSlashingPool) All() []*phase0.AttesterSlashing {
 // Pack n slashings, removes the slashings from the pool. A reward estimator is used to pick the best slashings.
 // Slashings with negative rewards will not be packed.
 func (asp *AttesterSlashingPool) Pack(estReward func(sl *phase0.AttesterSlashing) int, n uint) []*phase0.AttesterSlashing {
-	// TODO
-	return nil
+	asp.Lock()
+	defer asp.Unlock()
+	type ranked struct {
+		key    common.Root
+		reward int
+	}
+	candidates := make([]ranked, 0, len(asp.slashings))
+	for key, sl := range asp.slashings {
+		if reward := estReward(sl); reward >= 0 {
+			candidates = append(candidates, ranked{key: key, reward: reward})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].reward == candidates[j].reward {
+			return bytes.Compare(candidates[i].key[:], candidates[j].key[:]) < 0
+		}
+		return candidates[i].reward > candidates[j].reward
+	})
+	if uint(len(candidates)) > n {
+		candidates = candidates[:n]
+	}
+	out := make([]*phase0.AttesterSlashing, 0, len(candidates))
+	for _, c := range candidates {
+		out = append(out, asp.slashings[c.key])
+		delete(asp.slashings, c.key)
+	}
+	return out
+}
+
+// PackForState packs up to MAX_ATTESTER_SLASHINGS slashings that are valid in the given state.
+// The slashings are picked greedily by the whistleblower reward of the validators they slash,
+// not counting validators that are slashed by previously picked slashings:
+// overlapping slashings are only packed if they slash additional validators.
+// The exclude function, which may be nil, can exclude validators that are already slashed by other operations in the block.
+// Slashings that can never be included anymore, e.g. because all validators are already slashed, are removed from the pool.
+func (asp *AttesterSlashingPool) PackForState(state common.BeaconState, epc *common.EpochsContext,
+	exclude func(index common.ValidatorIndex) bool) ([]*phase0.AttesterSlashing, error) {
+	asp.Lock()
+	defer asp.Unlock()
+	vals, err := state.Validators()
+	if err != nil {
+		return nil, err
+	}
+	type candidate struct {
+		key     common.Root
+		rewards map[common.ValidatorIndex]common.Gwei
+	}
+	var candidates []*candidate
+	for key, sl := range asp.slashings {
+		sa1, sa2 := &sl.Attestation1, &sl.Attestation2
+		if !phase0.IsSlashableAttestationData(&sa1.Data, &sa2.Data) {
+			delete(asp.slashings, key)
+			continue
+		}
+		c := &candidate{key: key, rewards: make(map[common.ValidatorIndex]common.Gwei)}
+		pending := false
+		var errorAny error
+		common.ValidatorSet(sa1.AttestingIndices).ZigZagJoin(common.ValidatorSet(sa2.AttestingIndices), func(i common.ValidatorIndex) {
+			if errorAny != nil {
+				return
+			}
+			status, reward, err := slashableStatus(asp.spec, vals, i, epc.CurrentEpoch.Epoch)
+			if err != nil {
+				errorAny = err
+				return
+			}
+			switch status {
+			case opValid:
+				c.rewards[i] = reward
+			case opPending:
+				pending = true
+			}
+		}, nil)
+		if errorAny != nil {
+			return nil, errorAny
+		}
+		if len(c.rewards) == 0 {
+			if !pending {
+				delete(asp.slashings, key)
+			}
+			continue
+		}
+		if phase0.ValidateIndexedAttestation(asp.spec, epc, state, sa1) != nil ||
+			phase0.ValidateIndexedAttestation(asp.spec, epc, state, sa2) != nil {
+			delete(asp.slashings, key)
+			continue
+		}
+		candidates = append(candidates, c)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return bytes.Compare(candidates[i].key[:], candidates[j].key[:]) < 0
+	})
+
+	covered := make(map[common.ValidatorIndex]struct{})
+	marginal := func(c *candidate) (out common.Gwei, any bool) {
+		for i, reward := range c.rewards {
+			if _, ok := covered[i]; ok {
+				continue
+			}
+			if exclude != nil && exclude(i) {
+				continue
+			}
+			out += reward
+			any = true
+		}
+		return
+	}
+	var out []*phase0.AttesterSlashing
+	for uint64(len(out)) < uint64(asp.spec.MAX_ATTESTER_SLASHINGS) {
+		bestIndex := -1
+		var bestReward common.Gwei
+		for i, c := range candidates {
+			// a slashing must slash at least one validator to be valid, even if the reward is 0
+			if reward, any := marginal(c); any && (bestIndex < 0 || reward > bestReward) {
+				bestIndex, bestReward = i, reward
+			}
+		}
+		if bestIndex < 0 {
+			break
+		}
+		best := candidates[bestIndex]
+		for i := range best.rewards {
+			covered[i] = struct{}{}
+		}
+		out = append(out, asp.slashings[best.key])
+		candidates = append(candidates[:bestIndex], candidates[bestIndex+1:]...)
+	}
+	return out, nil
 }
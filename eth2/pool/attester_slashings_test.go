@@ -0,0 +1,109 @@
+package pool
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/protolambda/ztyp/tree"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/zrnt/eth2/configs"
+)
+
+func TestAttesterSlashingPackForState(t *testing.T) {
+	spec := configs.Minimal
+	keys := newTestKeys(t, 16)
+	state, epc := newTestState(t, spec, keys)
+	for _, v := range []common.ValidatorIndex{4, 5} {
+		if err := testValidator(t, state, v).MakeSlashed(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := testValidator(t, state, 9).SetActivationEpoch(testStateEpoch + 1); err != nil {
+		t.Fatal(err)
+	}
+
+	targetEpoch := testStateEpoch - 1
+	slot, _ := spec.EpochStartSlot(targetEpoch)
+	data := phase0.AttestationData{
+		Slot:            slot,
+		BeaconBlockRoot: common.Root{1},
+		Source:          common.Checkpoint{Epoch: targetEpoch - 1, Root: common.Root{2}},
+		Target:          common.Checkpoint{Epoch: targetEpoch, Root: common.Root{3}},
+	}
+	otherData := data
+	otherData.BeaconBlockRoot = common.Root{4}
+	indexed := func(indices []common.ValidatorIndex, data *phase0.AttestationData, badSig bool) phase0.IndexedAttestation {
+		msg := signingRoot(t, state, common.DOMAIN_BEACON_ATTESTER, targetEpoch, data.HashTreeRoot(tree.GetHashFn()))
+		signers := indices
+		if badSig {
+			signers = []common.ValidatorIndex{0}
+		}
+		return phase0.IndexedAttestation{
+			AttestingIndices: indices,
+			Data:             *data,
+			Signature:        keys.aggregate(signers, msg[:]),
+		}
+	}
+
+	cases := []struct {
+		name    string
+		indices []common.ValidatorIndex
+		// both attestations are the same
+		notSlashable bool
+		badSig       bool
+		kept         bool
+	}{
+		{name: "valid", indices: []common.ValidatorIndex{1, 2, 3}, kept: true},
+		{name: "covered by other slashing", indices: []common.ValidatorIndex{2, 3}, kept: true},
+		{name: "already slashed", indices: []common.ValidatorIndex{4, 5}},
+		{name: "already slashed and not active yet", indices: []common.ValidatorIndex{5, 9}, kept: true},
+		{name: "second most reward", indices: []common.ValidatorIndex{6, 7}, kept: true},
+		{name: "not slashable", indices: []common.ValidatorIndex{10, 11}, notSlashable: true},
+		{name: "bad signature", indices: []common.ValidatorIndex{12, 13}, badSig: true},
+		{name: "over the limit", indices: []common.ValidatorIndex{8}, kept: true},
+	}
+	asp := NewAttesterSlashingPool(spec)
+	slashings := make(map[*phase0.AttesterSlashing]string)
+	for _, c := range cases {
+		sl := &phase0.AttesterSlashing{Attestation1: indexed(c.indices, &data, c.badSig)}
+		if c.notSlashable {
+			sl.Attestation2 = indexed(c.indices, &data, c.badSig)
+		} else {
+			sl.Attestation2 = indexed(c.indices, &otherData, c.badSig)
+		}
+		if err := asp.AddAttesterSlashing(context.Background(), sl); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		slashings[sl] = c.name
+	}
+
+	pack := func(exclude func(index common.ValidatorIndex) bool, expected ...string) {
+		out, err := asp.PackForState(state, epc, exclude)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, sl := range out {
+			names = append(names, slashings[sl])
+		}
+		if fmt.Sprint(names) != fmt.Sprint(expected) {
+			t.Fatalf("expected slashings %q, got %q", expected, names)
+		}
+	}
+	pack(nil, "valid", "second most reward")
+
+	kept := make(map[string]bool)
+	for _, sl := range asp.All() {
+		kept[slashings[sl]] = true
+	}
+	for _, c := range cases {
+		if kept[c.name] != c.kept {
+			t.Fatalf("%s: expected kept %v, got %v", c.name, c.kept, kept[c.name])
+		}
+	}
+
+	pack(func(index common.ValidatorIndex) bool { return index == 6 || index == 7 }, "valid", "over the limit")
+}
@@ -0,0 +1,167 @@
+package pool
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	blsu "github.com/protolambda/bls12-381-util"
+	"github.com/protolambda/ztyp/tree"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/util/hashing"
+)
+
+type BLSToExecutionChangePool struct {
+	sync.RWMutex
+	spec                  *common.Spec
+	genesisValidatorsRoot common.Root
+	changes               map[common.ValidatorIndex]*common.SignedBLSToExecutionChange
+}
+
+func NewBLSToExecutionChangePool(spec *common.Spec, genesisValidatorsRoot common.Root) *BLSToExecutionChangePool {
+	return &BLSToExecutionChangePool{
+		spec:                  spec,
+		genesisValidatorsRoot: genesisValidatorsRoot,
+		changes:               make(map[common.ValidatorIndex]*common.SignedBLSToExecutionChange),
+	}
+}
+
+// AddBLSToExecutionChange verifies the change against the withdrawal credentials of the validator in the given state,
+// and the signature of the change, and adds it to the pool.
+// The change is signed with the genesis fork version, and thus valid across forks.
+// Only the first change per validator is kept: since it must match the BLS credentials,
+// only the owner of the withdrawal key can claim the validator.
+func (bp *BLSToExecutionChangePool) AddBLSToExecutionChange(ctx context.Context, state common.BeaconState, op *common.SignedBLSToExecutionChange) error {
+	change := &op.BLSToExecutionChange
+	bp.RLock()
+	existing, ok := bp.changes[change.ValidatorIndex]
+	bp.RUnlock()
+	if ok {
+		if *existing == *op {
+			return nil
+		}
+		return fmt.Errorf("already have bls to execution change for validator %d", change.ValidatorIndex)
+	}
+	validators, err := state.Validators()
+	if err != nil {
+		return err
+	}
+	if ok, err := validators.IsValidIndex(change.ValidatorIndex); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("bls to execution change for unknown validator %d", change.ValidatorIndex)
+	}
+	validator, err := validators.Validator(change.ValidatorIndex)
+	if err != nil {
+		return err
+	}
+	creds, err := validator.WithdrawalCredentials()
+	if err != nil {
+		return err
+	}
+	if creds[0] != common.BLS_WITHDRAWAL_PREFIX {
+		return fmt.Errorf("validator %d does not have BLS withdrawal credentials", change.ValidatorIndex)
+	}
+	if !matchesBLSCredentials(creds, change.FromBLSPubKey) {
+		return fmt.Errorf("bls to execution change pubkey does not match withdrawal credentials of validator %d", change.ValidatorIndex)
+	}
+	domain := common.ComputeDomain(common.DOMAIN_BLS_TO_EXECUTION_CHANGE, bp.spec.GENESIS_FORK_VERSION, bp.genesisValidatorsRoot)
+	sigRoot := common.ComputeSigningRoot(change.HashTreeRoot(tree.GetHashFn()), domain)
+	pubkey, err := change.FromBLSPubKey.Pubkey()
+	if err != nil {
+		return fmt.Errorf("invalid bls to execution change pubkey: %v", err)
+	}
+	sig, err := op.Signature.Signature()
+	if err != nil {
+		return fmt.Errorf("invalid bls to execution change signature: %v", err)
+	}
+	if !blsu.Verify(pubkey, sigRoot[:], sig) {
+		return errors.New("bls to execution change signature could not be verified")
+	}
+	bp.Lock()
+	defer bp.Unlock()
+	if _, ok := bp.changes[change.ValidatorIndex]; ok {
+		return fmt.Errorf("already have bls to execution change for validator %d", change.ValidatorIndex)
+	}
+	bp.changes[change.ValidatorIndex] = op
+	return nil
+}
+
+func (bp *BLSToExecutionChangePool) All() []*common.SignedBLSToExecutionChange {
+	bp.RLock()
+	defer bp.RUnlock()
+	out := make([]*common.SignedBLSToExecutionChange, 0, len(bp.changes))
+	for _, a := range bp.changes {
+		out = append(out, a)
+	}
+	return out
+}
+
+// Prune removes the changes that cannot be included anymore in the given head state:
+// changes of validators that have 0x01 withdrawal credentials already, or that do not match the BLS credentials.
+// Changes of validators that are not in the state yet are kept.
+func (bp *BLSToExecutionChangePool) Prune(state common.BeaconState) error {
+	bp.Lock()
+	defer bp.Unlock()
+	_, err := bp.prune(state)
+	return err
+}
+
+// PackForState prunes the pool with the given state, and packs up to MAX_BLS_TO_EXECUTION_CHANGES changes
+// that are valid in the state, ordered by validator index. Packed changes are kept until they are pruned.
+func (bp *BLSToExecutionChangePool) PackForState(state common.BeaconState) ([]*common.SignedBLSToExecutionChange, error) {
+	bp.Lock()
+	defer bp.Unlock()
+	valid, err := bp.prune(state)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(valid, func(i, j int) bool { return valid[i] < valid[j] })
+	if max := int(bp.spec.MAX_BLS_TO_EXECUTION_CHANGES); len(valid) > max {
+		valid = valid[:max]
+	}
+	out := make([]*common.SignedBLSToExecutionChange, 0, len(valid))
+	for _, i := range valid {
+		out = append(out, bp.changes[i])
+	}
+	return out, nil
+}
+
+// prune removes the changes that cannot be included anymore, and returns the validators with changes that are valid now.
+func (bp *BLSToExecutionChangePool) prune(state common.BeaconState) (valid []common.ValidatorIndex, err error) {
+	validators, err := state.Validators()
+	if err != nil {
+		return nil, err
+	}
+	for index, op := range bp.changes {
+		if ok, err := validators.IsValidIndex(index); err != nil {
+			return nil, err
+		} else if !ok {
+			continue
+		}
+		validator, err := validators.Validator(index)
+		if err != nil {
+			return nil, err
+		}
+		creds, err := validator.WithdrawalCredentials()
+		if err != nil {
+			return nil, err
+		}
+		if creds[0] != common.BLS_WITHDRAWAL_PREFIX || !matchesBLSCredentials(creds, op.BLSToExecutionChange.FromBLSPubKey) {
+			delete(bp.changes, index)
+			continue
+		}
+		valid = append(valid, index)
+	}
+	return valid, nil
+}
+
+// matchesBLSCredentials checks if the withdrawal credentials commit to the pubkey, ignoring the prefix.
+func matchesBLSCredentials(creds common.Root, pubkey common.BLSPubkey) bool {
+	pubkeyHash := hashing.Hash(pubkey[:])
+	return bytes.Equal(creds[1:], pubkeyHash[1:])
+}
@@ -0,0 +1,93 @@
+package pool
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/protolambda/ztyp/tree"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/configs"
+)
+
+func TestBLSToExecutionChangePool(t *testing.T) {
+	spec := configs.Minimal
+	keys := newTestKeys(t, 16)
+	state, _ := newTestState(t, spec, keys)
+	genesisValidatorsRoot, err := state.GenesisValidatorsRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	domain := common.ComputeDomain(common.DOMAIN_BLS_TO_EXECUTION_CHANGE, spec.GENESIS_FORK_VERSION, genesisValidatorsRoot)
+	// change creates the change of the validator, from the key of the signer, signed by the signer.
+	change := func(index common.ValidatorIndex, signer common.ValidatorIndex, addr byte) *common.SignedBLSToExecutionChange {
+		op := &common.SignedBLSToExecutionChange{BLSToExecutionChange: common.BLSToExecutionChange{
+			ValidatorIndex:     index,
+			FromBLSPubKey:      keys.pubs[signer].Serialize(),
+			ToExecutionAddress: common.Eth1Address{addr},
+		}}
+		msg := common.ComputeSigningRoot(op.BLSToExecutionChange.HashTreeRoot(tree.GetHashFn()), domain)
+		op.Signature = keys.sign(signer, msg[:])
+		return op
+	}
+	ctx := context.Background()
+	bp := NewBLSToExecutionChangePool(spec, genesisValidatorsRoot)
+
+	cases := []struct {
+		name  string
+		op    *common.SignedBLSToExecutionChange
+		added bool
+	}{
+		{name: "valid", op: change(1, 1, 0xaa), added: true},
+		{name: "duplicate", op: change(1, 1, 0xaa), added: true},
+		{name: "other change of same validator", op: change(1, 1, 0xbb)},
+		{name: "key of other validator", op: change(2, 3, 0xaa)},
+		{name: "valid after other key", op: change(2, 2, 0xbb), added: true},
+		{name: "bad signature", op: func() *common.SignedBLSToExecutionChange {
+			op := change(4, 4, 0xaa)
+			op.Signature = change(4, 4, 0xbb).Signature
+			return op
+		}()},
+		{name: "unknown validator", op: change(16, 5, 0xaa)},
+		{name: "valid to prune", op: change(6, 6, 0xaa), added: true},
+		{name: "valid to pack", op: change(7, 7, 0xaa), added: true},
+	}
+	for _, c := range cases {
+		err := bp.AddBLSToExecutionChange(ctx, state, c.op)
+		if c.added && err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if !c.added && err == nil {
+			t.Fatalf("%s: expected error", c.name)
+		}
+	}
+	if count := len(bp.All()); count != 4 {
+		t.Fatalf("expected 4 changes, got %d", count)
+	}
+
+	// validator 6 changes credentials already, and the credentials of validator 7 do not match anymore
+	if err := testValidator(t, state, 6).SetWithdrawalCredentials(common.Root{common.ETH1_ADDRESS_WITHDRAWAL_PREFIX}); err != nil {
+		t.Fatal(err)
+	}
+	if err := testValidator(t, state, 7).SetWithdrawalCredentials(common.Root{common.BLS_WITHDRAWAL_PREFIX}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bp.Prune(state); err != nil {
+		t.Fatal(err)
+	}
+	out, err := bp.PackForState(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var packed []string
+	for _, op := range out {
+		packed = append(packed, fmt.Sprintf("%d:%x", op.BLSToExecutionChange.ValidatorIndex, op.BLSToExecutionChange.ToExecutionAddress[0]))
+	}
+	if expected := []string{"1:aa", "2:bb"}; fmt.Sprint(packed) != fmt.Sprint(expected) {
+		t.Fatalf("expected changes %v, got %v", expected, packed)
+	}
+	if count := len(bp.All()); count != 2 {
+		t.Fatalf("expected 2 changes after pruning, got %d", count)
+	}
+}
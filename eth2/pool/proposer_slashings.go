@@ -3,6 +3,7 @@ package pool
 import (
 	"context"
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/protolambda/zrnt/eth2/beacon/common"
@@ -47,6 +48,124 @@ func (psp *ProposerSlashingPool) All() []*phase0.ProposerSlashing {
 // Pack n slashings, removes the slashings from the pool. A reward estimator is used to pick the best slashings.
 // Slashings with negative rewards will not be packed.
 func (psp *ProposerSlashingPool) Pack(estReward func(sl *phase0.ProposerSlashing) int, n uint) []*phase0.ProposerSlashing {
-	// TODO
-	return nil
+	psp.Lock()
+	defer psp.Unlock()
+	type ranked struct {
+		key    common.ValidatorIndex
+		reward int
+	}
+	candidates := make([]ranked, 0, len(psp.slashings))
+	for key, sl := range psp.slashings {
+		if reward := estReward(sl); reward >= 0 {
+			candidates = append(candidates, ranked{key: key, reward: reward})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].reward == candidates[j].reward {
+			return candidates[i].key < candidates[j].key
+		}
+		return candidates[i].reward > candidates[j].reward
+	})
+	if uint(len(candidates)) > n {
+		candidates = candidates[:n]
+	}
+	out := make([]*phase0.ProposerSlashing, 0, len(candidates))
+	for _, c := range candidates {
+		out = append(out, psp.slashings[c.key])
+		delete(psp.slashings, c.key)
+	}
+	return out
+}
+
+// PackForState packs up to MAX_PROPOSER_SLASHINGS slashings that are valid in the given state,
+// the slashings with the highest whistleblower reward first.
+// Slashings that can never be included anymore, e.g. because the proposer is already slashed, are removed from the pool.
+// Slashings of validators that are not active yet are kept, but not packed.
+func (psp *ProposerSlashingPool) PackForState(state common.BeaconState, epc *common.EpochsContext) ([]*phase0.ProposerSlashing, error) {
+	psp.Lock()
+	defer psp.Unlock()
+	vals, err := state.Validators()
+	if err != nil {
+		return nil, err
+	}
+	type ranked struct {
+		key    common.ValidatorIndex
+		reward common.Gwei
+	}
+	var candidates []ranked
+	for key, sl := range psp.slashings {
+		status, reward, err := slashableStatus(psp.spec, vals, key, epc.CurrentEpoch.Epoch)
+		if err != nil {
+			return nil, err
+		}
+		if status == opPending {
+			continue
+		}
+		if status == opStale || phase0.ValidateProposerSlashing(psp.spec, epc, state, sl) != nil {
+			delete(psp.slashings, key)
+			continue
+		}
+		candidates = append(candidates, ranked{key: key, reward: reward})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].reward == candidates[j].reward {
+			return candidates[i].key < candidates[j].key
+		}
+		return candidates[i].reward > candidates[j].reward
+	})
+	if max := int(psp.spec.MAX_PROPOSER_SLASHINGS); len(candidates) > max {
+		candidates = candidates[:max]
+	}
+	out := make([]*phase0.ProposerSlashing, 0, len(candidates))
+	for _, c := range candidates {
+		out = append(out, psp.slashings[c.key])
+	}
+	return out, nil
+}
+
+// opStatus is the status of an operation, relative to a state.
+type opStatus uint8
+
+const (
+	// opValid operations can be included now
+	opValid opStatus = iota
+	// opPending operations may become valid later, but cannot be included yet
+	opPending
+	// opStale operations are included already, or can never be included anymore
+	opStale
+)
+
+// slashableStatus checks if the validator can be slashed in the given epoch,
+// and returns the whistleblower reward of slashing it.
+func slashableStatus(spec *common.Spec, vals common.ValidatorRegistry, index common.ValidatorIndex, epoch common.Epoch) (opStatus, common.Gwei, error) {
+	if valid, err := vals.IsValidIndex(index); err != nil {
+		return opStale, 0, err
+	} else if !valid {
+		// the validator may still be deposited, but it cannot have proposed or attested yet
+		return opStale, 0, nil
+	}
+	v, err := vals.Validator(index)
+	if err != nil {
+		return opStale, 0, err
+	}
+	if slashed, err := v.Slashed(); err != nil {
+		return opStale, 0, err
+	} else if slashed {
+		return opStale, 0, nil
+	}
+	if withdrawable, err := v.WithdrawableEpoch(); err != nil {
+		return opStale, 0, err
+	} else if withdrawable <= epoch {
+		return opStale, 0, nil
+	}
+	if activation, err := v.ActivationEpoch(); err != nil {
+		return opStale, 0, err
+	} else if activation > epoch {
+		return opPending, 0, nil
+	}
+	effectiveBalance, err := v.EffectiveBalance()
+	if err != nil {
+		return opStale, 0, err
+	}
+	return opValid, effectiveBalance / common.Gwei(spec.WHISTLEBLOWER_REWARD_QUOTIENT), nil
 }
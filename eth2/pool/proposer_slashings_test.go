@@ -0,0 +1,145 @@
+package pool
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/protolambda/ztyp/tree"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/zrnt/eth2/util/hashing"
+)
+
+// testStateEpoch is the epoch of the test state: validators have been active long enough to exit.
+const testStateEpoch = common.Epoch(64)
+
+// newTestState creates a state at the start of testStateEpoch, with a validator for every key,
+// that has BLS withdrawal credentials.
+func newTestState(t *testing.T, spec *common.Spec, keys *testKeys) (*phase0.BeaconStateView, *common.EpochsContext) {
+	validators := make([]phase0.KickstartValidatorData, len(keys.pubs))
+	for i, pub := range keys.pubs {
+		validators[i].Pubkey = pub.Serialize()
+		validators[i].WithdrawalCredentials = hashing.Hash(validators[i].Pubkey[:])
+		validators[i].WithdrawalCredentials[0] = common.BLS_WITHDRAWAL_PREFIX
+		validators[i].Balance = spec.MAX_EFFECTIVE_BALANCE
+	}
+	state, _, err := phase0.KickStartState(spec, common.Root{123}, 1564000000, validators)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slot, err := spec.EpochStartSlot(testStateEpoch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := state.SetSlot(slot); err != nil {
+		t.Fatal(err)
+	}
+	epc, err := common.NewEpochsContext(spec, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state, epc
+}
+
+func testValidator(t *testing.T, state common.BeaconState, index common.ValidatorIndex) common.Validator {
+	vals, err := state.Validators()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := vals.Validator(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// signingRoot computes the message to sign for the object root, with the domain of the state at the given epoch.
+func signingRoot(t *testing.T, state common.BeaconState, domainType common.BLSDomainType, epoch common.Epoch, root common.Root) common.Root {
+	dom, err := common.GetDomain(state, domainType, epoch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return common.ComputeSigningRoot(root, dom)
+}
+
+func TestProposerSlashingPackForState(t *testing.T) {
+	spec := configs.Minimal
+	keys := newTestKeys(t, 16)
+	state, epc := newTestState(t, spec, keys)
+	slot, _ := state.Slot()
+
+	cases := []struct {
+		name  string
+		index common.ValidatorIndex
+		setup func(v common.Validator) error
+		// sign with the key of another validator
+		badSig bool
+		packed bool
+		kept   bool
+	}{
+		{name: "valid", index: 1, packed: true, kept: true},
+		{name: "slashed", index: 2, setup: func(v common.Validator) error { return v.MakeSlashed() }},
+		{name: "not active yet", index: 3, kept: true, setup: func(v common.Validator) error {
+			return v.SetActivationEpoch(testStateEpoch + 1)
+		}},
+		{name: "withdrawable", index: 4, setup: func(v common.Validator) error {
+			return v.SetWithdrawableEpoch(testStateEpoch)
+		}},
+		{name: "bad signature", index: 5, badSig: true},
+		{name: "lower reward", index: 6, packed: true, kept: true, setup: func(v common.Validator) error {
+			return v.SetEffectiveBalance(spec.MAX_EFFECTIVE_BALANCE / 2)
+		}},
+	}
+	psp := NewProposerSlashingPool(spec)
+	for _, c := range cases {
+		if c.setup != nil {
+			if err := c.setup(testValidator(t, state, c.index)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		signer := c.index
+		if c.badSig {
+			signer = 0
+		}
+		sl := &phase0.ProposerSlashing{}
+		for i, h := range []*common.SignedBeaconBlockHeader{&sl.SignedHeader1, &sl.SignedHeader2} {
+			h.Message = common.BeaconBlockHeader{Slot: slot, ProposerIndex: c.index, BodyRoot: common.Root{byte(i)}}
+			msg := signingRoot(t, state, common.DOMAIN_BEACON_PROPOSER, testStateEpoch, h.Message.HashTreeRoot(tree.GetHashFn()))
+			h.Signature = keys.sign(signer, msg[:])
+		}
+		if err := psp.AddProposerSlashing(nil, sl); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+	}
+	out, err := psp.PackForState(state, epc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var packed []common.ValidatorIndex
+	for _, sl := range out {
+		packed = append(packed, sl.SignedHeader1.Message.ProposerIndex)
+	}
+	var kept []common.ValidatorIndex
+	for _, sl := range psp.All() {
+		kept = append(kept, sl.SignedHeader1.Message.ProposerIndex)
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i] < kept[j] })
+	var expectedPacked, expectedKept []common.ValidatorIndex
+	for _, c := range cases {
+		if c.packed {
+			expectedPacked = append(expectedPacked, c.index)
+		}
+		if c.kept {
+			expectedKept = append(expectedKept, c.index)
+		}
+	}
+	if fmt.Sprint(packed) != fmt.Sprint(expectedPacked) {
+		t.Fatalf("expected packed slashings of %v, got %v", expectedPacked, packed)
+	}
+	if fmt.Sprint(kept) != fmt.Sprint(expectedKept) {
+		t.Fatalf("expected slashings of %v to be kept, got %v", expectedKept, kept)
+	}
+}
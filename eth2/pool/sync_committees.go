@@ -2,9 +2,14 @@ package pool
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
 	"sync"
 
+	blsu "github.com/protolambda/bls12-381-util"
+	"github.com/protolambda/ztyp/view"
+
 	"github.com/protolambda/zrnt/eth2/beacon/altair"
 	"github.com/protolambda/zrnt/eth2/beacon/common"
 )
@@ -17,8 +22,8 @@ type SyncCommitteeMessages map[common.ValidatorIndex]*altair.SyncCommitteeMessag
 func (msgs SyncCommitteeMessages) Select(root common.Root, members []common.ValidatorIndex) []*altair.SyncCommitteeMessage {
 	out := make([]*altair.SyncCommitteeMessage, 0, len(members))
 	for _, vi := range members {
-		msg := msgs[vi]
-		if msg.BeaconBlockRoot == root {
+		msg, ok := msgs[vi]
+		if ok && msg.BeaconBlockRoot == root {
 			out = append(out, msg)
 		}
 	}
@@ -102,18 +107,136 @@ func (sp *SyncCommitteePool) AddSyncCommitteeMessage(ctx context.Context, msg *a
 	return nil
 }
 
+// buffers returns the messages and contributions buffered for the given slot.
+func (sp *SyncCommitteePool) buffers(slot common.Slot) (SyncCommitteeMessages, SyncCommitteeContributions, error) {
+	if sp.currentSlot == slot+1 {
+		return sp.prevMsgs, sp.prevContribs, nil
+	} else if sp.currentSlot == slot {
+		return sp.currentMsgs, sp.currentContribs, nil
+	} else if sp.currentSlot+1 == slot {
+		return sp.nextMsgs, sp.nextContribs, nil
+	} else {
+		return nil, nil, fmt.Errorf("current sync committee pool is at slot %d, cannot pack for slot %d", sp.currentSlot, slot)
+	}
+}
+
+// aggregateSignatures aggregates the signatures, or returns the point at infinity if there are none.
+func aggregateSignatures(sigs []*common.BLSSignature) (common.BLSSignature, error) {
+	if len(sigs) == 0 {
+		return common.BLSSignature{0xc0}, nil
+	}
+	parsed := make([]*blsu.Signature, 0, len(sigs))
+	for _, sig := range sigs {
+		s, err := sig.Signature()
+		if err != nil {
+			return common.BLSSignature{}, err
+		}
+		parsed = append(parsed, s)
+	}
+	agg, err := blsu.Aggregate(parsed)
+	if err != nil {
+		return common.BLSSignature{}, err
+	}
+	return agg.Serialize(), nil
+}
+
+// PackContribution aggregates the messages of the subcommittee members for the given slot and block root.
+// A member that occurs multiple times in the subcommittee has its signature included for every position.
 func (sp *SyncCommitteePool) PackContribution(ctx context.Context, slot common.Slot, beaconBlockRoot common.Root, subnet uint64, subComm []common.ValidatorIndex) (*altair.SyncCommitteeContribution, error) {
 	sp.Lock()
 	defer sp.Unlock()
-	// TODO: select messages & aggregate a contribution
-	return nil, nil
+	if subnet >= common.SYNC_COMMITTEE_SUBNET_COUNT {
+		return nil, fmt.Errorf("invalid subnet %d", subnet)
+	}
+	size := uint64(sp.spec.SYNC_COMMITTEE_SIZE) / common.SYNC_COMMITTEE_SUBNET_COUNT
+	if uint64(len(subComm)) != size {
+		return nil, fmt.Errorf("expected subcommittee of %d members, got %d", size, len(subComm))
+	}
+	msgs, _, err := sp.buffers(slot)
+	if err != nil {
+		return nil, err
+	}
+	bits := make(altair.SyncCommitteeSubnetBits, (size+7)/8)
+	var sigs []*common.BLSSignature
+	for i, vi := range subComm {
+		if msg, ok := msgs[vi]; ok && msg.BeaconBlockRoot == beaconBlockRoot {
+			bits.SetBit(uint64(i), true)
+			sigs = append(sigs, &msg.Signature)
+		}
+	}
+	if len(sigs) == 0 {
+		return nil, errors.New("no sync committee messages to aggregate")
+	}
+	sig, err := aggregateSignatures(sigs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate sync committee messages: %w", err)
+	}
+	return &altair.SyncCommitteeContribution{
+		Slot:              slot,
+		BeaconBlockRoot:   beaconBlockRoot,
+		SubcommitteeIndex: view.Uint64View(subnet),
+		AggregationBits:   bits,
+		Signature:         sig,
+	}, nil
 }
 
+// PackAggregate creates a sync aggregate of the messages for the given slot and block root,
+// to include in a block of the next slot.
+// Per subnet the contributions with the most participants are merged, as long as they do not overlap,
+// and the remaining gaps are filled with individual messages.
+// The aggregate is empty, with the infinity signature, if there is nothing to pack.
 func (sp *SyncCommitteePool) PackAggregate(ctx context.Context, slot common.Slot, beaconBlockRoot common.Root, syncCommittee []common.ValidatorIndex) (*altair.SyncAggregate, error) {
 	sp.Lock()
 	defer sp.Unlock()
-	// TODO: select complimentary subnet contributions, fill gaps with any individual messages, and return it
-	return nil, nil
+	if uint64(len(syncCommittee)) != uint64(sp.spec.SYNC_COMMITTEE_SIZE) {
+		return nil, fmt.Errorf("expected sync committee of %d members, got %d", sp.spec.SYNC_COMMITTEE_SIZE, len(syncCommittee))
+	}
+	msgs, contribs, err := sp.buffers(slot)
+	if err != nil {
+		return nil, err
+	}
+	size := uint64(sp.spec.SYNC_COMMITTEE_SIZE) / common.SYNC_COMMITTEE_SUBNET_COUNT
+	bits := make(altair.SyncCommitteeBits, (uint64(sp.spec.SYNC_COMMITTEE_SIZE)+7)/8)
+	var sigs []*common.BLSSignature
+	subs := contribs[beaconBlockRoot]
+	for subnet := uint64(0); subnet < common.SYNC_COMMITTEE_SUBNET_COUNT; subnet++ {
+		offset := subnet * size
+		candidates := append([]*SubnetContrib(nil), subs[subnet]...)
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].AggregationBits.OnesCount() > candidates[j].AggregationBits.OnesCount()
+		})
+	candidatesLoop:
+		for _, c := range candidates {
+			if uint64(len(c.AggregationBits)) != (size+7)/8 {
+				continue
+			}
+			for i := uint64(0); i < size; i++ {
+				if c.AggregationBits.GetBit(i) && bits.GetBit(offset+i) {
+					continue candidatesLoop
+				}
+			}
+			for i := uint64(0); i < size; i++ {
+				if c.AggregationBits.GetBit(i) {
+					bits.SetBit(offset+i, true)
+				}
+			}
+			sigs = append(sigs, &c.Signature)
+		}
+		for i := uint64(0); i < size; i++ {
+			if bits.GetBit(offset + i) {
+				continue
+			}
+			if msg, ok := msgs[syncCommittee[offset+i]]; ok && msg.BeaconBlockRoot == beaconBlockRoot {
+				bits.SetBit(offset+i, true)
+				sigs = append(sigs, &msg.Signature)
+			}
+		}
+	}
+	sig, err := aggregateSignatures(sigs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate sync committee signatures: %w", err)
+	}
+	return &altair.SyncAggregate{SyncCommitteeBits: bits, SyncCommitteeSignature: sig}, nil
 }
 
 func (sp *SyncCommitteePool) Reset(slot common.Slot) {
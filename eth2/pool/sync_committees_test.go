@@ -0,0 +1,231 @@
+package pool
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+
+	blsu "github.com/protolambda/bls12-381-util"
+	"github.com/protolambda/ztyp/view"
+
+	"github.com/protolambda/zrnt/eth2/beacon/altair"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/configs"
+)
+
+type testKeys struct {
+	t    *testing.T
+	sks  []*blsu.SecretKey
+	pubs []*blsu.Pubkey
+}
+
+func newTestKeys(t *testing.T, count uint64) *testKeys {
+	keys := &testKeys{t: t}
+	for i := uint64(0); i < count; i++ {
+		var raw [32]byte
+		binary.BigEndian.PutUint64(raw[24:], i+1)
+		var sk blsu.SecretKey
+		if err := sk.Deserialize(&raw); err != nil {
+			t.Fatal(err)
+		}
+		pub, err := blsu.SkToPk(&sk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys.sks = append(keys.sks, &sk)
+		keys.pubs = append(keys.pubs, pub)
+	}
+	return keys
+}
+
+func (k *testKeys) sign(index common.ValidatorIndex, msg []byte) common.BLSSignature {
+	return blsu.Sign(k.sks[index], msg).Serialize()
+}
+
+// aggregate signs the message with every given validator, and aggregates the signatures.
+func (k *testKeys) aggregate(indices []common.ValidatorIndex, msg []byte) common.BLSSignature {
+	sigs := make([]*blsu.Signature, 0, len(indices))
+	for _, i := range indices {
+		sigs = append(sigs, blsu.Sign(k.sks[i], msg))
+	}
+	agg, err := blsu.Aggregate(sigs)
+	if err != nil {
+		k.t.Fatal(err)
+	}
+	return agg.Serialize()
+}
+
+// verify checks that the signature is the aggregate of the message signed by every given validator.
+func (k *testKeys) verify(indices []common.ValidatorIndex, msg []byte, sig common.BLSSignature) bool {
+	pubs := make([]*blsu.Pubkey, 0, len(indices))
+	for _, i := range indices {
+		pubs = append(pubs, k.pubs[i])
+	}
+	s, err := sig.Signature()
+	if err != nil {
+		k.t.Fatal(err)
+	}
+	return blsu.FastAggregateVerify(pubs, msg, s)
+}
+
+func TestSyncCommitteePackAggregate(t *testing.T) {
+	spec := configs.Minimal
+	keys := newTestKeys(t, 16)
+	slot := common.Slot(10)
+	root := common.Root{0xaa}
+	// every validator is a member twice: at position p and p+16, in subnet p/8 and p/8+2.
+	syncCommittee := make([]common.ValidatorIndex, spec.SYNC_COMMITTEE_SIZE)
+	for p := range syncCommittee {
+		syncCommittee[p] = common.ValidatorIndex(p % 16)
+	}
+	type contrib struct {
+		subnet uint64
+		// positions in the subcommittee, every member signs
+		bits []uint64
+	}
+	cases := []struct {
+		name     string
+		msgs     []common.ValidatorIndex
+		msgRoot  common.Root
+		contribs []contrib
+		// positions in the sync committee
+		expected []uint64
+	}{
+		{
+			name:     "messages of duplicate members",
+			msgs:     []common.ValidatorIndex{0, 1, 9},
+			expected: []uint64{0, 1, 9, 16, 17, 25},
+		},
+		{
+			name: "non-overlapping contributions and gap-fill",
+			msgs: []common.ValidatorIndex{4, 7},
+			contribs: []contrib{
+				{subnet: 0, bits: []uint64{0, 1, 2, 3}},
+				{subnet: 0, bits: []uint64{2, 3, 4}},
+				{subnet: 0, bits: []uint64{5, 6}},
+				{subnet: 3, bits: []uint64{0, 1}},
+			},
+			// subnet 0: both contributions without overlap, and the gaps of 4 and 7 filled with messages,
+			// subnet 2: the messages of 4 and 7, subnet 3: the contribution of 8 and 9.
+			expected: []uint64{0, 1, 2, 3, 4, 5, 6, 7, 20, 23, 24, 25},
+		},
+		{
+			name:    "other block root",
+			msgs:    []common.ValidatorIndex{0, 1},
+			msgRoot: common.Root{0xbb},
+		},
+	}
+	subSize := uint64(spec.SYNC_COMMITTEE_SIZE) / common.SYNC_COMMITTEE_SUBNET_COUNT
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			sp := NewSyncCommitteePool(spec)
+			sp.Reset(slot)
+			msgRoot := root
+			if c.msgRoot != (common.Root{}) {
+				msgRoot = c.msgRoot
+			}
+			for _, vi := range c.msgs {
+				if err := sp.AddSyncCommitteeMessage(ctx, &altair.SyncCommitteeMessage{
+					Slot:            slot,
+					BeaconBlockRoot: msgRoot,
+					ValidatorIndex:  vi,
+					Signature:       keys.sign(vi, msgRoot[:]),
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, cb := range c.contribs {
+				bits := make(altair.SyncCommitteeSubnetBits, (subSize+7)/8)
+				var signers []common.ValidatorIndex
+				for _, b := range cb.bits {
+					bits.SetBit(b, true)
+					signers = append(signers, syncCommittee[cb.subnet*subSize+b])
+				}
+				if err := sp.AddSyncCommitteeContribution(ctx, &altair.SyncCommitteeContribution{
+					Slot:              slot,
+					BeaconBlockRoot:   root,
+					SubcommitteeIndex: view.Uint64View(cb.subnet),
+					AggregationBits:   bits,
+					Signature:         keys.aggregate(signers, root[:]),
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			agg, err := sp.PackAggregate(ctx, slot, root, syncCommittee)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := make(map[uint64]bool)
+			for _, p := range c.expected {
+				expected[p] = true
+			}
+			var signers []common.ValidatorIndex
+			for p := uint64(0); p < uint64(spec.SYNC_COMMITTEE_SIZE); p++ {
+				if agg.SyncCommitteeBits.GetBit(p) != expected[p] {
+					t.Fatalf("unexpected bit %d: %v", p, !expected[p])
+				}
+				if expected[p] {
+					signers = append(signers, syncCommittee[p])
+				}
+			}
+			if len(signers) == 0 {
+				if agg.SyncCommitteeSignature != (common.BLSSignature{0xc0}) {
+					t.Fatal("expected infinity signature for empty aggregate")
+				}
+				return
+			}
+			if !keys.verify(signers, root[:], agg.SyncCommitteeSignature) {
+				t.Fatal("aggregate signature does not verify")
+			}
+		})
+	}
+
+	sp := NewSyncCommitteePool(spec)
+	sp.Reset(slot)
+	if _, err := sp.PackAggregate(context.Background(), slot+2, root, syncCommittee); err == nil {
+		t.Fatal("expected error for slot out of range")
+	}
+}
+
+func TestSyncCommitteePackContribution(t *testing.T) {
+	spec := configs.Minimal
+	keys := newTestKeys(t, 8)
+	ctx := context.Background()
+	slot := common.Slot(10)
+	root := common.Root{0xaa}
+	sp := NewSyncCommitteePool(spec)
+	sp.Reset(slot)
+	for _, vi := range []common.ValidatorIndex{1, 3} {
+		if err := sp.AddSyncCommitteeMessage(ctx, &altair.SyncCommitteeMessage{
+			Slot:            slot,
+			BeaconBlockRoot: root,
+			ValidatorIndex:  vi,
+			Signature:       keys.sign(vi, root[:]),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// validator 1 is a member twice
+	subComm := []common.ValidatorIndex{0, 1, 2, 3, 4, 5, 1, 7}
+	contrib, err := sp.PackContribution(ctx, slot, root, 1, subComm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var signers []common.ValidatorIndex
+	for i, vi := range subComm {
+		expected := vi == 1 || vi == 3
+		if contrib.AggregationBits.GetBit(uint64(i)) != expected {
+			t.Fatalf("unexpected bit %d", i)
+		}
+		if expected {
+			signers = append(signers, vi)
+		}
+	}
+	if !keys.verify(signers, root[:], contrib.Signature) {
+		t.Fatal("contribution signature does not verify")
+	}
+	if _, err := sp.PackContribution(ctx, slot, common.Root{0xbb}, 1, subComm); err == nil {
+		t.Fatal("expected error without messages for the block root")
+	}
+}
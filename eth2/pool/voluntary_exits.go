@@ -3,9 +3,11 @@ package pool
 import (
 	"context"
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
 	"github.com/protolambda/zrnt/eth2/beacon/phase0"
 )
 
@@ -46,6 +48,107 @@ func (vep *VoluntaryExitPool) All() []*phase0.SignedVoluntaryExit {
 // Pack n exits, removes the exits from the pool. A ranking function is used to pick the best exits.
 // Exits with negative rank function outputs will not be packed.
 func (vep *VoluntaryExitPool) Pack(rank func(sl *phase0.SignedVoluntaryExit) int, n uint) []*phase0.SignedVoluntaryExit {
-	// TODO
-	return nil
+	vep.Lock()
+	defer vep.Unlock()
+	type ranked struct {
+		key  common.ValidatorIndex
+		rank int
+	}
+	candidates := make([]ranked, 0, len(vep.exits))
+	for key, exit := range vep.exits {
+		if r := rank(exit); r >= 0 {
+			candidates = append(candidates, ranked{key: key, rank: r})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].rank == candidates[j].rank {
+			return candidates[i].key < candidates[j].key
+		}
+		return candidates[i].rank > candidates[j].rank
+	})
+	if uint(len(candidates)) > n {
+		candidates = candidates[:n]
+	}
+	out := make([]*phase0.SignedVoluntaryExit, 0, len(candidates))
+	for _, c := range candidates {
+		out = append(out, vep.exits[c.key])
+		delete(vep.exits, c.key)
+	}
+	return out
+}
+
+// PackForState packs up to MAX_VOLUNTARY_EXITS exits that are valid in the given state,
+// the exits that became valid the earliest first.
+// The exclude function, which may be nil, can exclude validators that already exit by other operations in the block,
+// e.g. validators that are slashed: their exit would be invalid.
+// Exits that can never be included anymore, e.g. because the validator already exited, are removed from the pool.
+// Exits that are not valid yet, e.g. because of the exit epoch or the minimum time to be active, are kept but not packed.
+func (vep *VoluntaryExitPool) PackForState(state common.BeaconState, epc *common.EpochsContext,
+	exclude func(index common.ValidatorIndex) bool) ([]*phase0.SignedVoluntaryExit, error) {
+	vep.Lock()
+	defer vep.Unlock()
+	vals, err := state.Validators()
+	if err != nil {
+		return nil, err
+	}
+	currentEpoch := epc.CurrentEpoch.Epoch
+	var candidates []*phase0.SignedVoluntaryExit
+	for key, exit := range vep.exits {
+		status, err := exitStatus(vep.spec, vals, &exit.Message, currentEpoch)
+		if err != nil {
+			return nil, err
+		}
+		if status == opPending || (exclude != nil && exclude(key)) {
+			continue
+		}
+		if status == opValid {
+			if currentEpoch >= vep.spec.DENEB_FORK_EPOCH {
+				err = deneb.ValidateVoluntaryExit(vep.spec, epc, state, exit)
+			} else {
+				err = phase0.ValidateVoluntaryExit(vep.spec, epc, state, exit)
+			}
+		}
+		if status == opStale || err != nil {
+			delete(vep.exits, key)
+			continue
+		}
+		candidates = append(candidates, exit)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := &candidates[i].Message, &candidates[j].Message
+		if a.Epoch == b.Epoch {
+			return a.ValidatorIndex < b.ValidatorIndex
+		}
+		return a.Epoch < b.Epoch
+	})
+	if max := int(vep.spec.MAX_VOLUNTARY_EXITS); len(candidates) > max {
+		candidates = candidates[:max]
+	}
+	return candidates, nil
+}
+
+// exitStatus checks if the validator can exit in the given epoch, without checking the signature.
+func exitStatus(spec *common.Spec, vals common.ValidatorRegistry, exit *phase0.VoluntaryExit, epoch common.Epoch) (opStatus, error) {
+	if valid, err := vals.IsValidIndex(exit.ValidatorIndex); err != nil {
+		return opStale, err
+	} else if !valid {
+		return opStale, nil
+	}
+	v, err := vals.Validator(exit.ValidatorIndex)
+	if err != nil {
+		return opStale, err
+	}
+	if exitEpoch, err := v.ExitEpoch(); err != nil {
+		return opStale, err
+	} else if exitEpoch != common.FAR_FUTURE_EPOCH {
+		return opStale, nil
+	}
+	activation, err := v.ActivationEpoch()
+	if err != nil {
+		return opStale, err
+	}
+	if epoch < exit.Epoch || activation > epoch || epoch < activation+spec.SHARD_COMMITTEE_PERIOD {
+		return opPending, nil
+	}
+	return opValid, nil
 }
@@ -0,0 +1,97 @@
+package pool
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/protolambda/ztyp/tree"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/zrnt/eth2/configs"
+)
+
+func TestVoluntaryExitPackForState(t *testing.T) {
+	spec := configs.Minimal
+	keys := newTestKeys(t, 16)
+	state, epc := newTestState(t, spec, keys)
+
+	cases := []struct {
+		name  string
+		index common.ValidatorIndex
+		epoch common.Epoch
+		setup func(v common.Validator) error
+		// sign with the key of another validator
+		badSig   bool
+		excluded bool
+		packed   bool
+		kept     bool
+	}{
+		{name: "later epoch", index: 1, epoch: 1, packed: true, kept: true},
+		{name: "valid", index: 6, epoch: 0, packed: true, kept: true},
+		{name: "future epoch", index: 2, epoch: testStateEpoch + 1, kept: true},
+		{name: "already exited", index: 3, setup: func(v common.Validator) error {
+			return v.SetExitEpoch(testStateEpoch + 4)
+		}},
+		{name: "not active long enough", index: 4, kept: true, setup: func(v common.Validator) error {
+			return v.SetActivationEpoch(10)
+		}},
+		{name: "bad signature", index: 5, badSig: true},
+		{name: "excluded", index: 7, excluded: true, kept: true},
+	}
+	vep := NewVoluntaryExitPool(spec)
+	for _, c := range cases {
+		if c.setup != nil {
+			if err := c.setup(testValidator(t, state, c.index)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		signer := c.index
+		if c.badSig {
+			signer = 0
+		}
+		exit := &phase0.SignedVoluntaryExit{Message: phase0.VoluntaryExit{Epoch: c.epoch, ValidatorIndex: c.index}}
+		msg := signingRoot(t, state, common.DOMAIN_VOLUNTARY_EXIT, c.epoch, exit.Message.HashTreeRoot(tree.GetHashFn()))
+		exit.Signature = keys.sign(signer, msg[:])
+		if err := vep.AddVoluntaryExit(context.Background(), exit); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+	}
+	out, err := vep.PackForState(state, epc, func(index common.ValidatorIndex) bool {
+		for _, c := range cases {
+			if c.index == index {
+				return c.excluded
+			}
+		}
+		return false
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var packed []common.ValidatorIndex
+	for _, exit := range out {
+		packed = append(packed, exit.Message.ValidatorIndex)
+	}
+	var kept []common.ValidatorIndex
+	for _, exit := range vep.All() {
+		kept = append(kept, exit.Message.ValidatorIndex)
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i] < kept[j] })
+	// exits are packed by epoch, then validator index
+	expectedPacked := []common.ValidatorIndex{6, 1}
+	var expectedKept []common.ValidatorIndex
+	for _, c := range cases {
+		if c.kept {
+			expectedKept = append(expectedKept, c.index)
+		}
+	}
+	sort.Slice(expectedKept, func(i, j int) bool { return expectedKept[i] < expectedKept[j] })
+	if fmt.Sprint(packed) != fmt.Sprint(expectedPacked) {
+		t.Fatalf("expected packed exits of %v, got %v", expectedPacked, packed)
+	}
+	if fmt.Sprint(kept) != fmt.Sprint(expectedKept) {
+		t.Fatalf("expected exits of %v to be kept, got %v", expectedKept, kept)
+	}
+}
@@ -0,0 +1,390 @@
+package fork_choice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/protolambda/ztyp/codec"
+	"gopkg.in/yaml.v3"
+
+	"github.com/protolambda/zrnt/eth2/beacon"
+	"github.com/protolambda/zrnt/eth2/beacon/altair"
+	"github.com/protolambda/zrnt/eth2/beacon/bellatrix"
+	"github.com/protolambda/zrnt/eth2/beacon/capella"
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
+	"github.com/protolambda/zrnt/eth2/beacon/phase0"
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/zrnt/eth2/execution"
+	"github.com/protolambda/zrnt/eth2/forkchoice"
+	"github.com/protolambda/zrnt/eth2/forkchoice/proto"
+	"github.com/protolambda/zrnt/eth2/kzg"
+	"github.com/protolambda/zrnt/tests/spec/test_util"
+)
+
+type HeadCheck struct {
+	Slot common.Slot `yaml:"slot"`
+	Root common.Root `yaml:"root"`
+}
+
+type Checks struct {
+	Time                *common.Timestamp  `yaml:"time"`
+	Head                *HeadCheck         `yaml:"head"`
+	JustifiedCheckpoint *common.Checkpoint `yaml:"justified_checkpoint"`
+	FinalizedCheckpoint *common.Checkpoint `yaml:"finalized_checkpoint"`
+	ProposerBoostRoot   *common.Root       `yaml:"proposer_boost_root"`
+}
+
+// Step is one of the steps of a fork_choice test.
+type Step struct {
+	Tick  *common.Timestamp `yaml:"tick"`
+	Block *string           `yaml:"block"`
+	// Optional, the blobs and proofs that are available for the block, since Deneb
+	Blobs            *string           `yaml:"blobs"`
+	Proofs           []common.KZGProof `yaml:"proofs"`
+	Attestation      *string           `yaml:"attestation"`
+	AttesterSlashing *string           `yaml:"attester_slashing"`
+	Checks           *Checks           `yaml:"checks"`
+	// Optional, steps are valid by default
+	Valid *bool `yaml:"valid"`
+
+	// Steps that are not supported: test cases with these are skipped
+	PowBlock      *yaml.Node `yaml:"pow_block"`
+	PayloadStatus *yaml.Node `yaml:"payload_status"`
+}
+
+// unsupported returns the kind of the step, if the runner does not support it.
+func (s *Step) unsupported() (kind string, ok bool) {
+	switch {
+	case s.PowBlock != nil:
+		return "pow_block", true
+	case s.PayloadStatus != nil:
+		return "payload_status", true
+	default:
+		return "", false
+	}
+}
+
+// stepBlobs checks data availability like the fork_choice tests do:
+// the blobs and proofs of the current block step are available, and must match the commitments of the block.
+type stepBlobs struct {
+	kzg    common.KZGVerifier
+	blobs  [][]byte
+	proofs []common.KZGProof
+}
+
+var _ common.DataAvailabilityChecker = (*stepBlobs)(nil)
+
+func (s *stepBlobs) IsDataAvailable(ctx context.Context, blockRoot common.Root, commitments []common.KZGCommitment) error {
+	if s.kzg == nil {
+		return errors.New("no KZG trusted setup available to verify blobs with")
+	}
+	if ok, err := s.kzg.VerifyBlobKZGProofBatch(s.blobs, commitments, s.proofs); err != nil {
+		return fmt.Errorf("failed to verify blobs of block %s: %w", blockRoot, err)
+	} else if !ok {
+		return fmt.Errorf("invalid blob KZG proofs for block %s", blockRoot)
+	}
+	return nil
+}
+
+// blobList decodes the SSZ list of blobs of a block step.
+type blobList struct {
+	spec  *common.Spec
+	blobs []deneb.Blob
+}
+
+func (l *blobList) Deserialize(dr *codec.DecodingReader) error {
+	return dr.List(func() codec.Deserializable {
+		i := len(l.blobs)
+		l.blobs = append(l.blobs, nil)
+		return l.spec.Wrap(&l.blobs[i])
+	}, uint64(l.spec.FIELD_ELEMENTS_PER_BLOB)*common.BYTES_PER_FIELD_ELEMENT, uint64(l.spec.MAX_BLOB_COMMITMENTS_PER_BLOCK))
+}
+
+func (l *blobList) FixedLength() uint64 {
+	return 0
+}
+
+// testStore drives the forkchoice Store with the time of the test steps.
+type testStore struct {
+	spec  *common.Spec
+	now   time.Time
+	store *forkchoice.Store
+}
+
+func newTestStore(spec *common.Spec, anchor common.BeaconState) (*testStore, error) {
+	slot, err := anchor.Slot()
+	if err != nil {
+		return nil, err
+	}
+	genesisTime, err := anchor.GenesisTime()
+	if err != nil {
+		return nil, err
+	}
+	s := &testStore{
+		spec: spec,
+		now:  time.Unix(int64(genesisTime+common.Timestamp(slot)*spec.SECONDS_PER_SLOT), 0),
+	}
+	s.store, err = proto.NewProtoStore(spec, forkchoice.ClockFn(func() time.Time {
+		return s.now
+	}), anchor, nil)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *testStore) onTick(t common.Timestamp) error {
+	if now := time.Unix(int64(t), 0); now.After(s.now) {
+		s.now = now
+	}
+	return s.store.OnTick(context.Background())
+}
+
+func (s *testStore) onBlock(benv *common.BeaconBlockEnvelope) error {
+	ctx := context.Background()
+	pre, preEpc, ok := s.store.BlockState(benv.ParentRoot)
+	if !ok {
+		return fmt.Errorf("unknown parent %s", benv.ParentRoot)
+	}
+	state, err := pre.CopyState()
+	if err != nil {
+		return err
+	}
+	epc := preEpc.Clone()
+	wrapped := &beacon.StandardUpgradeableBeaconState{BeaconState: state}
+	if err := common.StateTransition(ctx, s.spec, epc, wrapped, benv, true); err != nil {
+		return err
+	}
+	return s.store.OnBlock(ctx, benv, wrapped.BeaconState, epc)
+}
+
+func (s *testStore) onAttestation(att *phase0.Attestation, fromBlock bool) error {
+	data := &att.Data
+	// The store delays early attestations, the spec rejects them.
+	if !fromBlock && s.store.CurrentSlot() < data.Slot+1 {
+		return errors.New("attestation is too early")
+	}
+	_, epc, err := s.store.CheckpointState(data.Target)
+	if err != nil {
+		return err
+	}
+	committee, err := epc.GetBeaconCommittee(data.Slot, data.Index)
+	if err != nil {
+		return err
+	}
+	indexed, err := att.ConvertToIndexed(s.spec, committee)
+	if err != nil {
+		return err
+	}
+	return s.store.OnAttestation(context.Background(), indexed, fromBlock)
+}
+
+func (s *testStore) onAttesterSlashing(slashing *phase0.AttesterSlashing) error {
+	return s.store.OnAttesterSlashing(context.Background(), slashing)
+}
+
+func (s *testStore) check(checks *Checks) error {
+	fc := s.store.Forkchoice()
+	if checks.Time != nil && common.Timestamp(s.now.Unix()) != *checks.Time {
+		return fmt.Errorf("time %d does not match expected %d", s.now.Unix(), *checks.Time)
+	}
+	if checks.Head != nil {
+		ref, err := s.store.Head()
+		if err != nil {
+			return err
+		}
+		slot, ok := fc.GetSlot(ref.Root)
+		if !ok {
+			return fmt.Errorf("unknown head %s", ref)
+		}
+		if ref.Root != checks.Head.Root || slot != checks.Head.Slot {
+			return fmt.Errorf("head %s at slot %d does not match expected %s at slot %d",
+				ref.Root, slot, checks.Head.Root, checks.Head.Slot)
+		}
+	}
+	if checks.JustifiedCheckpoint != nil && fc.Justified() != *checks.JustifiedCheckpoint {
+		return fmt.Errorf("justified checkpoint %s does not match expected %s", fc.Justified(), *checks.JustifiedCheckpoint)
+	}
+	if checks.FinalizedCheckpoint != nil && fc.Finalized() != *checks.FinalizedCheckpoint {
+		return fmt.Errorf("finalized checkpoint %s does not match expected %s", fc.Finalized(), *checks.FinalizedCheckpoint)
+	}
+	if checks.ProposerBoostRoot != nil && fc.ProposerBoostRoot() != *checks.ProposerBoostRoot {
+		return fmt.Errorf("proposer boost root %s does not match expected %s", fc.ProposerBoostRoot(), *checks.ProposerBoostRoot)
+	}
+	return nil
+}
+
+func loadBlock(t *testing.T, forkName test_util.ForkName, name string, digest common.ForkDigest,
+	readPart test_util.TestPartReader) (*common.BeaconBlockEnvelope, []phase0.Attestation, []phase0.AttesterSlashing) {
+	spec := readPart.Spec()
+	switch forkName {
+	case "phase0":
+		dst := new(phase0.SignedBeaconBlock)
+		test_util.LoadSpecObj(t, name, dst, readPart)
+		return dst.Envelope(spec, digest), dst.Message.Body.Attestations, dst.Message.Body.AttesterSlashings
+	case "altair":
+		dst := new(altair.SignedBeaconBlock)
+		test_util.LoadSpecObj(t, name, dst, readPart)
+		return dst.Envelope(spec, digest), dst.Message.Body.Attestations, dst.Message.Body.AttesterSlashings
+	case "bellatrix":
+		dst := new(bellatrix.SignedBeaconBlock)
+		test_util.LoadSpecObj(t, name, dst, readPart)
+		return dst.Envelope(spec, digest), dst.Message.Body.Attestations, dst.Message.Body.AttesterSlashings
+	case "capella":
+		dst := new(capella.SignedBeaconBlock)
+		test_util.LoadSpecObj(t, name, dst, readPart)
+		return dst.Envelope(spec, digest), dst.Message.Body.Attestations, dst.Message.Body.AttesterSlashings
+	case "deneb":
+		dst := new(deneb.SignedBeaconBlock)
+		test_util.LoadSpecObj(t, name, dst, readPart)
+		return dst.Envelope(spec, digest), dst.Message.Body.Attestations, dst.Message.Body.AttesterSlashings
+	default:
+		t.Fatalf("unrecognized fork name: %s", forkName)
+		return nil, nil, nil
+	}
+}
+
+func runCase(t *testing.T, forkName test_util.ForkName, readPart test_util.TestPartReader) {
+	// copy the spec, to check data availability with the blobs of the steps of this test case
+	spec := *readPart.Spec()
+	available := &stepBlobs{kzg: spec.KZG}
+	spec.DataAvailability = available
+	anchor := test_util.LoadState(t, forkName, "anchor_state", readPart)
+	if anchor == nil {
+		t.Fatal("missing anchor state")
+	}
+	p := readPart.Part("steps.yaml")
+	var steps []Step
+	test_util.Check(t, yaml.NewDecoder(p).Decode(&steps))
+	test_util.Check(t, p.Close())
+	for i := range steps {
+		if kind, ok := steps[i].unsupported(); ok {
+			t.Skipf("step %d: unsupported %s step", i, kind)
+		}
+	}
+
+	fork, err := anchor.Fork()
+	test_util.Check(t, err)
+	valRoot, err := anchor.GenesisValidatorsRoot()
+	test_util.Check(t, err)
+	digest := common.ComputeForkDigest(fork.CurrentVersion, valRoot)
+
+	store, err := newTestStore(&spec, anchor)
+	test_util.Check(t, err)
+
+	for i, step := range steps {
+		var err error
+		switch {
+		case step.Tick != nil:
+			err = store.onTick(*step.Tick)
+		case step.Block != nil:
+			benv, atts, slashings := loadBlock(t, forkName, *step.Block, digest, readPart)
+			available.blobs, available.proofs = nil, step.Proofs
+			if step.Blobs != nil {
+				blobs := &blobList{spec: &spec}
+				test_util.LoadSSZ(t, *step.Blobs, blobs, readPart)
+				for _, b := range blobs.blobs {
+					available.blobs = append(available.blobs, b)
+				}
+			}
+			err = store.onBlock(benv)
+			// the operations of a valid block are applied to the forkchoice as well.
+			for j := 0; err == nil && j < len(atts); j++ {
+				err = store.onAttestation(&atts[j], true)
+			}
+			for j := 0; err == nil && j < len(slashings); j++ {
+				err = store.onAttesterSlashing(&slashings[j])
+			}
+		case step.Attestation != nil:
+			var att phase0.Attestation
+			test_util.LoadSpecObj(t, *step.Attestation, &att, readPart)
+			err = store.onAttestation(&att, false)
+		case step.AttesterSlashing != nil:
+			var slashing phase0.AttesterSlashing
+			test_util.LoadSpecObj(t, *step.AttesterSlashing, &slashing, readPart)
+			err = store.onAttesterSlashing(&slashing)
+		case step.Checks != nil:
+			if err := store.check(step.Checks); err != nil {
+				t.Fatalf("step %d: check failed: %v", i, err)
+			}
+			continue
+		default:
+			t.Fatalf("step %d: unrecognized step", i)
+		}
+		valid := step.Valid == nil || *step.Valid
+		if valid && err != nil {
+			t.Fatalf("step %d: unexpected error: %v", i, err)
+		}
+		if !valid && err == nil {
+			t.Fatalf("step %d: expected error", i)
+		}
+	}
+}
+
+var forks = []test_util.ForkName{"phase0", "altair", "bellatrix", "capella", "deneb"}
+
+var (
+	trustedSetupsLock sync.Mutex
+	trustedSetups     = make(map[string]*kzg.Context)
+)
+
+// trustedSetup loads the KZG trusted setup of the preset once, to verify the blobs of Deneb blocks with.
+func trustedSetup(t *testing.T, preset string) *kzg.Context {
+	trustedSetupsLock.Lock()
+	defer trustedSetupsLock.Unlock()
+	if ctx, ok := trustedSetups[preset]; ok {
+		return ctx
+	}
+	_, filename, _, _ := runtime.Caller(0)
+	root := filepath.Join(filepath.Dir(filename), "..", "..", "..", "..")
+	ctx, err := kzg.LoadTrustedSetup(filepath.Join(root, "eth2", "configs", "yamls", "presets", preset, "trusted_setups", "trusted_setup_4096.json"))
+	test_util.Check(t, err)
+	trustedSetups[preset] = ctx
+	return ctx
+}
+
+func runHandler(t *testing.T, handler string) {
+	caseRunner := test_util.HandleBLS(runCase)
+	for _, base := range []*common.Spec{configs.Minimal, configs.Mainnet} {
+		t.Run(base.PRESET_BASE, func(t *testing.T) {
+			spec := *base
+			spec.ExecutionEngine = &execution.NoOpExecutionEngine{}
+			for _, fork := range forks {
+				t.Run(string(fork), func(t *testing.T) {
+					spec := spec
+					if fork == "deneb" {
+						spec.KZG = trustedSetup(t, spec.PRESET_BASE)
+					}
+					test_util.RunHandler(t, "fork_choice/"+handler, caseRunner, &spec, fork)
+				})
+			}
+		})
+	}
+}
+
+func TestGetHead(t *testing.T) {
+	runHandler(t, "get_head")
+}
+
+func TestOnBlock(t *testing.T) {
+	runHandler(t, "on_block")
+}
+
+func TestExAnte(t *testing.T) {
+	runHandler(t, "ex_ante")
+}
+
+func TestReorg(t *testing.T) {
+	runHandler(t, "reorg")
+}
+
+func TestWithholding(t *testing.T) {
+	runHandler(t, "withholding")
+}
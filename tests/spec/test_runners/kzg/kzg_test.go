@@ -0,0 +1,212 @@
+package kzg
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/protolambda/ztyp/conv"
+	"gopkg.in/yaml.v3"
+
+	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/configs"
+	"github.com/protolambda/zrnt/eth2/kzg"
+	"github.com/protolambda/zrnt/tests/spec/test_util"
+)
+
+// hexBytes decodes any length of hex data, the length is checked when converting to the fixed-size types,
+// since test vectors with invalid lengths are expected to fail, not to be skipped.
+type hexBytes []byte
+
+func (b *hexBytes) UnmarshalText(text []byte) error {
+	return conv.DynamicBytesUnmarshalText((*[]byte)(b), text)
+}
+
+func (b hexBytes) root() (out common.Root, err error) {
+	if len(b) != len(out) {
+		return out, fmt.Errorf("expected %d bytes, got %d", len(out), len(b))
+	}
+	copy(out[:], b)
+	return
+}
+
+func (b hexBytes) commitment() (out common.KZGCommitment, err error) {
+	if len(b) != len(out) {
+		return out, fmt.Errorf("expected %d bytes, got %d", len(out), len(b))
+	}
+	copy(out[:], b)
+	return
+}
+
+func (b hexBytes) proof() (out common.KZGProof, err error) {
+	if len(b) != len(out) {
+		return out, fmt.Errorf("expected %d bytes, got %d", len(out), len(b))
+	}
+	copy(out[:], b)
+	return
+}
+
+func loadContext(t *testing.T) *kzg.Context {
+	_, filename, _, _ := runtime.Caller(0)
+	root := filepath.Join(filepath.Dir(filename), "..", "..", "..", "..")
+	ctx, err := kzg.LoadTrustedSetup(filepath.Join(root, "eth2", "configs", "yamls", "presets", "mainnet", "trusted_setups", "trusted_setup_4096.json"))
+	test_util.Check(t, err)
+	return ctx
+}
+
+type testCase[I any, O any] struct {
+	Input  I  `yaml:"input"`
+	Output *O `yaml:"output"`
+}
+
+func runKZGHandler[I any, O any](t *testing.T, handler string, run func(input *I) (O, error), equal func(a, b O) bool) {
+	spec := *configs.Mainnet
+	spec.PRESET_BASE = "general"
+	test_util.RunHandler(t, "kzg/"+handler,
+		func(t *testing.T, forkName test_util.ForkName, readPart test_util.TestPartReader) {
+			p := readPart.Part("data.yaml")
+			var c testCase[I, O]
+			test_util.Check(t, yaml.NewDecoder(p).Decode(&c))
+			test_util.Check(t, p.Close())
+			out, err := run(&c.Input)
+			if c.Output == nil {
+				if err == nil {
+					t.Fatalf("expected error, but got output: %v", out)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !equal(*c.Output, out) {
+				t.Fatalf("unexpected output: %v, expected: %v", out, *c.Output)
+			}
+		}, &spec, "deneb")
+}
+
+func TestKZG(t *testing.T) {
+	ctx := loadContext(t)
+
+	t.Run("blob_to_kzg_commitment", func(t *testing.T) {
+		type input struct {
+			Blob hexBytes `yaml:"blob"`
+		}
+		runKZGHandler(t, "blob_to_kzg_commitment", func(in *input) (common.KZGCommitment, error) {
+			return ctx.BlobToKZGCommitment(in.Blob)
+		}, func(a, b common.KZGCommitment) bool { return a == b })
+	})
+
+	t.Run("compute_kzg_proof", func(t *testing.T) {
+		type input struct {
+			Blob hexBytes `yaml:"blob"`
+			Z    hexBytes `yaml:"z"`
+		}
+		type proofAndY [2]hexBytes
+		runKZGHandler(t, "compute_kzg_proof", func(in *input) (proofAndY, error) {
+			z, err := in.Z.root()
+			if err != nil {
+				return proofAndY{}, err
+			}
+			proof, y, err := ctx.ComputeKZGProof(in.Blob, z)
+			if err != nil {
+				return proofAndY{}, err
+			}
+			return proofAndY{proof[:], y[:]}, nil
+		}, func(a, b proofAndY) bool {
+			return string(a[0]) == string(b[0]) && string(a[1]) == string(b[1])
+		})
+	})
+
+	t.Run("verify_kzg_proof", func(t *testing.T) {
+		type input struct {
+			Commitment hexBytes `yaml:"commitment"`
+			Z          hexBytes `yaml:"z"`
+			Y          hexBytes `yaml:"y"`
+			Proof      hexBytes `yaml:"proof"`
+		}
+		runKZGHandler(t, "verify_kzg_proof", func(in *input) (bool, error) {
+			commitment, err := in.Commitment.commitment()
+			if err != nil {
+				return false, err
+			}
+			z, err := in.Z.root()
+			if err != nil {
+				return false, err
+			}
+			y, err := in.Y.root()
+			if err != nil {
+				return false, err
+			}
+			proof, err := in.Proof.proof()
+			if err != nil {
+				return false, err
+			}
+			return ctx.VerifyKZGProof(commitment, z, y, proof)
+		}, func(a, b bool) bool { return a == b })
+	})
+
+	t.Run("compute_blob_kzg_proof", func(t *testing.T) {
+		type input struct {
+			Blob       hexBytes `yaml:"blob"`
+			Commitment hexBytes `yaml:"commitment"`
+		}
+		runKZGHandler(t, "compute_blob_kzg_proof", func(in *input) (common.KZGProof, error) {
+			commitment, err := in.Commitment.commitment()
+			if err != nil {
+				return common.KZGProof{}, err
+			}
+			return ctx.ComputeBlobKZGProof(in.Blob, commitment)
+		}, func(a, b common.KZGProof) bool { return a == b })
+	})
+
+	t.Run("verify_blob_kzg_proof", func(t *testing.T) {
+		type input struct {
+			Blob       hexBytes `yaml:"blob"`
+			Commitment hexBytes `yaml:"commitment"`
+			Proof      hexBytes `yaml:"proof"`
+		}
+		runKZGHandler(t, "verify_blob_kzg_proof", func(in *input) (bool, error) {
+			commitment, err := in.Commitment.commitment()
+			if err != nil {
+				return false, err
+			}
+			proof, err := in.Proof.proof()
+			if err != nil {
+				return false, err
+			}
+			return ctx.VerifyBlobKZGProof(in.Blob, commitment, proof)
+		}, func(a, b bool) bool { return a == b })
+	})
+
+	t.Run("verify_blob_kzg_proof_batch", func(t *testing.T) {
+		type input struct {
+			Blobs       []hexBytes `yaml:"blobs"`
+			Commitments []hexBytes `yaml:"commitments"`
+			Proofs      []hexBytes `yaml:"proofs"`
+		}
+		runKZGHandler(t, "verify_blob_kzg_proof_batch", func(in *input) (bool, error) {
+			blobs := make([][]byte, len(in.Blobs))
+			for i, b := range in.Blobs {
+				blobs[i] = b
+			}
+			commitments := make([]common.KZGCommitment, len(in.Commitments))
+			for i, c := range in.Commitments {
+				v, err := c.commitment()
+				if err != nil {
+					return false, err
+				}
+				commitments[i] = v
+			}
+			proofs := make([]common.KZGProof, len(in.Proofs))
+			for i, p := range in.Proofs {
+				v, err := p.proof()
+				if err != nil {
+					return false, err
+				}
+				proofs[i] = v
+			}
+			return ctx.VerifyBlobKZGProofBatch(blobs, commitments, proofs)
+		}, func(a, b bool) bool { return a == b })
+	})
+}
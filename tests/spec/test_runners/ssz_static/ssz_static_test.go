@@ -6,8 +6,6 @@ import (
 	"io/ioutil"
 	"testing"
 
-	"github.com/protolambda/zrnt/eth2/beacon/capella"
-
 	"github.com/golang/snappy"
 	"github.com/protolambda/ztyp/codec"
 	"github.com/protolambda/ztyp/tree"
@@ -15,7 +13,9 @@ import (
 
 	"github.com/protolambda/zrnt/eth2/beacon/altair"
 	"github.com/protolambda/zrnt/eth2/beacon/bellatrix"
+	"github.com/protolambda/zrnt/eth2/beacon/capella"
 	"github.com/protolambda/zrnt/eth2/beacon/common"
+	"github.com/protolambda/zrnt/eth2/beacon/deneb"
 	"github.com/protolambda/zrnt/eth2/beacon/phase0"
 	"github.com/protolambda/zrnt/eth2/configs"
 	"github.com/protolambda/zrnt/tests/spec/test_util"
@@ -102,6 +102,7 @@ var objs = map[test_util.ForkName]map[string]ObjAllocator{
 	"altair":    {},
 	"bellatrix": {},
 	"capella":   {},
+	"deneb":     {},
 }
 
 func init() {
@@ -134,6 +135,7 @@ func init() {
 		objs["altair"][k] = v
 		objs["bellatrix"][k] = v
 		objs["capella"][k] = v
+		objs["deneb"][k] = v
 	}
 	objs["phase0"]["BeaconBlockBody"] = func() interface{} { return new(phase0.BeaconBlockBody) }
 	objs["phase0"]["BeaconBlock"] = func() interface{} { return new(phase0.BeaconBlock) }
@@ -172,6 +174,18 @@ func init() {
 	objs["capella"]["Withdrawal"] = func() interface{} { return new(common.Withdrawal) }
 	objs["capella"]["BLSToExecutionChange"] = func() interface{} { return new(common.BLSToExecutionChange) }
 	objs["capella"]["SignedBLSToExecutionChange"] = func() interface{} { return new(common.SignedBLSToExecutionChange) }
+
+	objs["deneb"]["BeaconBlockBody"] = func() interface{} { return new(deneb.BeaconBlockBody) }
+	objs["deneb"]["BeaconBlock"] = func() interface{} { return new(deneb.BeaconBlock) }
+	objs["deneb"]["BeaconState"] = func() interface{} { return new(deneb.BeaconState) }
+	objs["deneb"]["SignedBeaconBlock"] = func() interface{} { return new(deneb.SignedBeaconBlock) }
+	objs["deneb"]["ExecutionPayload"] = func() interface{} { return new(deneb.ExecutionPayload) }
+	objs["deneb"]["ExecutionPayloadHeader"] = func() interface{} { return new(deneb.ExecutionPayloadHeader) }
+	objs["deneb"]["Withdrawal"] = func() interface{} { return new(common.Withdrawal) }
+	objs["deneb"]["BLSToExecutionChange"] = func() interface{} { return new(common.BLSToExecutionChange) }
+	objs["deneb"]["SignedBLSToExecutionChange"] = func() interface{} { return new(common.SignedBLSToExecutionChange) }
+	objs["deneb"]["BlobSidecar"] = func() interface{} { return new(deneb.BlobSidecar) }
+	objs["deneb"]["BlobIdentifier"] = func() interface{} { return new(deneb.BlobIdentifier) }
 }
 
 type RootsYAML struct {